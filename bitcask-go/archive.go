@@ -0,0 +1,253 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const archiveManifestFileName = "archive-manifest"
+
+// 两次写入时间戳的最小间隔, 也是按时间恢复的精度
+var timestampMarkInterval = 100 * time.Millisecond
+
+var timestampMarkKey = []byte("timestamp")
+
+// 归档清单中的一个数据文件, 每行一条
+type archiveEntry struct {
+	Fid       uint32 `json:"fid"`
+	Size      int64  `json:"size"`
+	FirstTime int64  `json:"first_time"` // 文件中第一个时间戳, 为 0 表示没有时间戳
+	LastTime  int64  `json:"last_time"`  // 归档的时间, 文件中的数据都在这之前写入
+	MaxSeqNo  uint64 `json:"max_seq_no"` // 归档时最新的事务序列号, 小于恢复目标时文件中的数据都在目标事务提交之前写入
+}
+
+// RestoreTarget 按时间点恢复的目标, 两个条件都设置时先满足的为准, 都为零值时恢复全部的数据
+type RestoreTarget struct {
+	// 恢复到这个时间之前写入的数据, 精度为写入时间戳的间隔
+	Time time.Time
+	// 恢复到这个序列号的事务提交为止, 之后写入的数据都不恢复, 包括不在事务中的 Put 和 Delete
+	// 序列号只在 WriteBatch 提交时增加, 备份中的数据总是全部恢复
+	SeqNo uint64
+	// 数据文件加密时用于读取归档的数据文件
+	KeyProvider KeyProvider
+}
+
+// 在活跃文件的开头和距离上一次超过间隔时写入时间戳
+// 副本的数据文件需要和主节点保持一致, 时间戳从主节点同步过来
+func (db *DB) markTimestamp() error {
+	if db.options.ArchiveDir == "" || db.isReplica() {
+		return nil
+	}
+	now := time.Now()
+	if db.activeFile.WriteOffset > 0 && now.Sub(db.lastMarkTime) < timestampMarkInterval {
+		return nil
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(now.UnixNano()))
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(timestampMarkKey, nonTransactionSeqNo),
+		Value: value,
+		Type:  data.LogRecordTimestamp,
+	})
+	if err := db.writeActiveFile(encRecord); err != nil {
+		return err
+	}
+	db.lastMarkTime = now
+	return nil
+}
+
+func decodeTimestamp(logRecord *data.LogRecord) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(logRecord.Value)))
+}
+
+// 将不再修改的数据文件硬链接到归档目录, merge 删除数据文件之后归档中仍然保留
+func (db *DB) archiveDataFile(dataFile *data.DataFile) error {
+	if db.options.ArchiveDir == "" || dataFile.WriteOffset == 0 {
+		return nil
+	}
+	src := data.GetDataFileName(db.options.DirPath, dataFile.FileId)
+	dest := data.GetDataFileName(db.options.ArchiveDir, dataFile.FileId)
+	if err := os.Link(src, dest); err != nil && !os.IsExist(err) {
+		// 不在同一个文件系统时无法硬链接, 直接拷贝
+		if err := utils.CopyFile(src, dest, dataFile.WriteOffset); err != nil {
+			return err
+		}
+	}
+
+	entry := archiveEntry{
+		Fid:      dataFile.FileId,
+		Size:     dataFile.WriteOffset,
+		LastTime: time.Now().UnixNano(),
+		MaxSeqNo: db.seqNo,
+	}
+	if logRecord, _, err := dataFile.ReadLogRecord(0); err == nil && logRecord.Type == data.LogRecordTimestamp {
+		entry.FirstTime = decodeTimestamp(logRecord).UnixNano()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	manifest, err := os.OpenFile(filepath.Join(db.options.ArchiveDir, archiveManifestFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer manifest.Close()
+	if _, err := manifest.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return manifest.Sync()
+}
+
+// 读取归档清单, 同一个文件被重复归档时以最后一次为准
+func readArchiveManifest(archiveDir string) ([]archiveEntry, error) {
+	file, err := os.Open(filepath.Join(archiveDir, archiveManifestFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make(map[uint32]archiveEntry)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry archiveEntry
+		// 最后一行可能没有写完整
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries[entry.Fid] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	result := make([]archiveEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Fid < result[j].Fid
+	})
+	return result, nil
+}
+
+// RestoreToPoint 先从备份恢复, 再依次拷贝备份之后归档的数据文件, 直到恢复目标为止
+// 目标所在的数据文件会在目标之后的第一条记录处截断
+func RestoreToPoint(backupDir string, archiveDir string, target RestoreTarget, dirPath string) error {
+	manifest, err := readBackupManifest(backupDir)
+	if err != nil {
+		return err
+	}
+	if !target.Time.IsZero() && target.Time.UnixNano() < manifest.Time {
+		return ErrRestoreTargetTooEarly
+	}
+	if target.SeqNo != 0 && target.SeqNo < manifest.SeqNo {
+		return ErrRestoreTargetTooEarly
+	}
+	entries, err := readArchiveManifest(archiveDir)
+	if err != nil {
+		return err
+	}
+	if err := Restore(backupDir, dirPath); err != nil {
+		return err
+	}
+
+	// 备份中最后一个数据文件, 副本的备份中可能只包含这个文件的一部分
+	var nextFid uint32
+	var lastSize int64
+	for _, file := range manifest.Files {
+		if !strings.HasSuffix(file.Name, data.DataFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(file.Name, data.DataFileNameSuffix))
+		if err != nil {
+			return ErrBackupCorrupt
+		}
+		if uint32(fid)+1 > nextFid {
+			nextFid, lastSize = uint32(fid)+1, file.Size
+		}
+	}
+
+	// 按序列号恢复时是否已经恢复到目标事务的提交, 备份之后的记录都在目标事务提交之后写入
+	reached := target.SeqNo != 0 && manifest.SeqNo >= target.SeqNo
+	for _, entry := range entries {
+		var startOffset int64
+		if nextFid > 0 && entry.Fid == nextFid-1 && entry.Size > lastSize {
+			startOffset = lastSize
+		} else if entry.Fid < nextFid {
+			continue
+		} else if entry.Fid != nextFid {
+			return ErrArchiveIncomplete
+		}
+		if !target.Time.IsZero() && entry.FirstTime > target.Time.UnixNano() {
+			break
+		}
+
+		stopOffset, stopped := entry.Size, false
+		if (!target.Time.IsZero() && entry.LastTime > target.Time.UnixNano()) ||
+			(target.SeqNo != 0 && entry.MaxSeqNo >= target.SeqNo) {
+			if stopOffset, stopped, err = findRestorePoint(archiveDir, entry.Fid, startOffset, target, &reached); err != nil {
+				return err
+			}
+		}
+		if stopOffset > 0 {
+			src := data.GetDataFileName(archiveDir, entry.Fid)
+			if err := utils.CopyFile(src, data.GetDataFileName(dirPath, entry.Fid), stopOffset); err != nil {
+				return err
+			}
+		}
+		if stopped {
+			break
+		}
+		nextFid = entry.Fid + 1
+	}
+	return nil
+}
+
+// 从 offset 开始查找第一条超过恢复目标的记录, 返回它的位置
+// 按序列号恢复时, 目标事务的完成标识之后的第一条记录就超过了目标, reached 记录是否已经读到这个完成标识
+func findRestorePoint(archiveDir string, fid uint32, offset int64, target RestoreTarget, reached *bool) (int64, bool, error) {
+	dataFile, err := data.OpenDataFile(archiveDir, fid, fio.StandardFileIO)
+	if err != nil {
+		return 0, false, err
+	}
+	dataFile.SetKeyProvider(target.KeyProvider)
+	defer dataFile.Close()
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return offset, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if *reached {
+			return offset, true, nil
+		}
+		if logRecord.Type == data.LogRecordTimestamp {
+			if !target.Time.IsZero() && decodeTimestamp(logRecord).After(target.Time) {
+				return offset, true, nil
+			}
+		} else if _, seqNo := parseLogRecordKey(logRecord.Key); target.SeqNo != 0 {
+			if seqNo > target.SeqNo {
+				return offset, true, nil
+			}
+			if seqNo == target.SeqNo && logRecord.Type == data.LogRecordTxnFinished {
+				*reached = true
+			}
+		}
+		offset += size
+	}
+}
@@ -0,0 +1,212 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func restoreAndOpen(t *testing.T, backupDir, archiveDir string, target RestoreTarget) *DB {
+	dir, _ := os.MkdirTemp("", "bitcask-go-pitr-restore")
+	assert.Nil(t, RestoreToPoint(backupDir, archiveDir, target, dir))
+	opts := DefaultOptions
+	opts.DirPath = dir
+	return openTestDB(t, "", opts)
+}
+
+func TestDB_RestoreToTime(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-pitr")
+	opts.ArchiveDir, _ = os.MkdirTemp("", "bitcask-go-pitr-archive")
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	defer os.RemoveAll(opts.ArchiveDir)
+	db := openTestDB(t, "", opts)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-pitr-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.Backup(backupDir))
+
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	time.Sleep(2 * timestampMarkInterval)
+	target := time.Now()
+	time.Sleep(2 * timestampMarkInterval)
+	for i := 200; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+
+	// merge 切换活跃文件, 重新打开之后旧的数据文件被删除, 归档中仍然保留
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db = openTestDB(t, "", opts)
+	defer destroyDB(db)
+	current, err := os.Stat(data.GetDataFileName(opts.DirPath, 1))
+	assert.Nil(t, err)
+	archived, err := os.Stat(data.GetDataFileName(opts.ArchiveDir, 1))
+	assert.Nil(t, err)
+	assert.False(t, os.SameFile(current, archived))
+
+	db1 := restoreAndOpen(t, backupDir, opts.ArchiveDir, RestoreTarget{Time: target})
+	defer destroyDB(db1)
+	assert.Equal(t, 190, len(db1.ListKeys()))
+	for i := 10; i < 200; i++ {
+		value, err := db1.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		expected, _ := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, expected, value)
+	}
+	_, err = db1.Get(utils.GetTestKey(200))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 恢复全部归档的数据
+	db2 := restoreAndOpen(t, backupDir, opts.ArchiveDir, RestoreTarget{})
+	defer destroyDB(db2)
+	assert.Equal(t, 290, len(db2.ListKeys()))
+
+	// 恢复的目标不能早于备份
+	dir, _ := os.MkdirTemp("", "bitcask-go-pitr-early")
+	defer os.RemoveAll(dir)
+	err = RestoreToPoint(backupDir, opts.ArchiveDir, RestoreTarget{Time: target.Add(-time.Hour)}, dir)
+	assert.Equal(t, ErrRestoreTargetTooEarly, err)
+}
+
+func TestDB_RestoreToSeqNo(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-pitr-seq")
+	opts.ArchiveDir, _ = os.MkdirTemp("", "bitcask-go-pitr-seq-archive")
+	opts.DataFileSize = 4 * 1024
+	defer os.RemoveAll(opts.ArchiveDir)
+	db := openTestDB(t, "", opts)
+	defer destroyDB(db)
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-pitr-seq-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.Backup(backupDir))
+
+	var seqNos []uint64
+	for n := 0; n < 10; n++ {
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := n * 20; i < (n+1)*20; i++ {
+			assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		}
+		assert.Nil(t, wb.Commit())
+		seqNos = append(seqNos, db.seqNo)
+	}
+	// 备份会切换活跃文件, 将最后的数据归档
+	assert.Nil(t, db.Backup(filepath.Join(backupDir, "latest")))
+
+	db1 := restoreAndOpen(t, backupDir, opts.ArchiveDir, RestoreTarget{SeqNo: seqNos[4]})
+	defer destroyDB(db1)
+	assert.Equal(t, 100, len(db1.ListKeys()))
+	_, err := db1.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 恢复之后可以继续写入
+	assert.Nil(t, db1.Put(utils.GetTestKey(100), []byte("v")))
+	assert.Equal(t, 101, len(db1.ListKeys()))
+
+	db2 := restoreAndOpen(t, backupDir, opts.ArchiveDir, RestoreTarget{SeqNo: seqNos[9]})
+	defer destroyDB(db2)
+	assert.Equal(t, 200, len(db2.ListKeys()))
+}
+
+func TestDB_RestoreToSeqNoWithPut(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-pitr-seq-put")
+	opts.ArchiveDir, _ = os.MkdirTemp("", "bitcask-go-pitr-seq-put-archive")
+	opts.DataFileSize = 4 * 1024
+	defer os.RemoveAll(opts.ArchiveDir)
+	db := openTestDB(t, "", opts)
+	defer destroyDB(db)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-0"), []byte("v")))
+	assert.Nil(t, wb.Commit())
+	backupSeqNo := db.seqNo
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-pitr-seq-put-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.Backup(backupDir))
+
+	// 事务之间穿插不在事务中的写入, 跨越多个数据文件
+	var seqNos []uint64
+	for n := 1; n <= 3; n++ {
+		for i := 0; i < 30; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(n*100+i), utils.RandomValue(64)))
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put([]byte("batch-"+strconv.Itoa(n)), []byte("v")))
+		assert.Nil(t, wb.Delete(utils.GetTestKey(n*100)))
+		assert.Nil(t, wb.Commit())
+		seqNos = append(seqNos, db.seqNo)
+	}
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(400+i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Backup(filepath.Join(backupDir, "latest")))
+
+	// 目标就是备份点时, 备份之后的写入都不恢复
+	db1 := restoreAndOpen(t, backupDir, opts.ArchiveDir, RestoreTarget{SeqNo: backupSeqNo})
+	defer destroyDB(db1)
+	assert.Equal(t, 1, len(db1.ListKeys()))
+
+	// 目标事务之前的写入都恢复, 之后的写入都不恢复
+	db2 := restoreAndOpen(t, backupDir, opts.ArchiveDir, RestoreTarget{SeqNo: seqNos[1]})
+	defer destroyDB(db2)
+	assert.Equal(t, 3+58, len(db2.ListKeys()))
+	_, err := db2.Get([]byte("batch-2"))
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(200))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(301))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	db3 := restoreAndOpen(t, backupDir, opts.ArchiveDir, RestoreTarget{SeqNo: seqNos[2]})
+	defer destroyDB(db3)
+	assert.Equal(t, 4+87, len(db3.ListKeys()))
+	_, err = db3.Get(utils.GetTestKey(400))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_RestoreArchiveIncomplete(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-pitr-gap")
+	opts.ArchiveDir, _ = os.MkdirTemp("", "bitcask-go-pitr-gap-archive")
+	opts.DataFileSize = 4 * 1024
+	defer os.RemoveAll(opts.ArchiveDir)
+	db := openTestDB(t, "", opts)
+	defer destroyDB(db)
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-pitr-gap-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.Backup(backupDir))
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+
+	// 缺少备份之后的第一个归档文件
+	entries, err := readArchiveManifest(opts.ArchiveDir)
+	assert.Nil(t, err)
+	assert.Greater(t, len(entries), 2)
+	manifest, err := os.ReadFile(filepath.Join(opts.ArchiveDir, archiveManifestFileName))
+	assert.Nil(t, err)
+	lines := strings.Split(string(manifest), "\n")
+	assert.Nil(t, os.WriteFile(filepath.Join(opts.ArchiveDir, archiveManifestFileName), []byte(lines[0]+"\n"+lines[2]+"\n"), 0644))
+
+	dir, _ := os.MkdirTemp("", "bitcask-go-pitr-gap-restore")
+	defer os.RemoveAll(dir)
+	assert.Equal(t, ErrArchiveIncomplete, RestoreToPoint(backupDir, opts.ArchiveDir, RestoreTarget{}, dir))
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrUnauthenticated  = errors.New("authentication required or invalid credentials")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Permission 用户可以执行的操作, 可以组合使用
+type Permission uint8
+
+const (
+	// PermRead 读取数据和统计信息
+	PermRead Permission = 1 << iota
+	// PermWrite 写入和删除数据
+	PermWrite
+	// PermAdmin merge, 备份, 导入导出等管理操作, 不受 key 前缀的限制
+	PermAdmin
+)
+
+var permissionNames = map[string]Permission{
+	"read":  PermRead,
+	"write": PermWrite,
+	"admin": PermAdmin,
+}
+
+// Config 网络服务的认证配置, 一般从 json 文件中读取
+type Config struct {
+	TLS   *TLSConfig `json:"tls,omitempty"`
+	Users []User     `json:"users"`
+}
+
+// TLSConfig 证书和私钥文件的路径, ClientCAFile 不为空时要求客户端提供证书
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+// User 用户可以通过用户名和密码, 或者任意一个 token 进行认证
+type User struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"`
+	Tokens   []string `json:"tokens,omitempty"`
+	// 可以访问的 key 前缀, 为空时可以访问所有的 key
+	Prefixes []string `json:"prefixes,omitempty"`
+	// read, write, admin
+	Permissions []string `json:"permissions"`
+}
+
+// LoadConfig 从 json 文件中读取认证配置
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("invalid auth config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Load 读取证书文件, 生成服务端使用的 tls 配置
+func (c *TLSConfig) Load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Principal 认证通过的用户
+type Principal struct {
+	Name        string
+	permissions Permission
+	prefixes    [][]byte
+	password    [sha256.Size]byte
+	hasPassword bool
+}
+
+// Authenticator 根据配置的用户校验密码和 token
+type Authenticator struct {
+	users  map[string]*Principal
+	tokens map[string]*Principal
+}
+
+// New 根据用户配置创建 Authenticator, 用户名和 token 不能重复
+func New(users []User) (*Authenticator, error) {
+	a := &Authenticator{
+		users:  make(map[string]*Principal),
+		tokens: make(map[string]*Principal),
+	}
+	for _, user := range users {
+		if user.Name == "" {
+			return nil, errors.New("user name is empty")
+		}
+		if _, ok := a.users[user.Name]; ok {
+			return nil, fmt.Errorf("duplicate user %s", user.Name)
+		}
+		p := &Principal{Name: user.Name}
+		for _, name := range user.Permissions {
+			perm, ok := permissionNames[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown permission %q of user %s", name, user.Name)
+			}
+			p.permissions |= perm
+		}
+		for _, prefix := range user.Prefixes {
+			p.prefixes = append(p.prefixes, []byte(prefix))
+		}
+		if user.Password != "" {
+			p.password = sha256.Sum256([]byte(user.Password))
+			p.hasPassword = true
+		}
+		for _, token := range user.Tokens {
+			if _, ok := a.tokens[token]; ok || token == "" {
+				return nil, fmt.Errorf("empty or duplicate token of user %s", user.Name)
+			}
+			a.tokens[token] = p
+		}
+		a.users[user.Name] = p
+	}
+	return a, nil
+}
+
+// Password 使用用户名和密码认证
+func (a *Authenticator) Password(name string, password string) (*Principal, error) {
+	p, ok := a.users[name]
+	// 比较摘要, 耗时和密码的内容无关
+	sum := sha256.Sum256([]byte(password))
+	if !ok || !p.hasPassword || subtle.ConstantTimeCompare(sum[:], p.password[:]) != 1 {
+		return nil, ErrUnauthenticated
+	}
+	return p, nil
+}
+
+// Token 使用 token 认证
+func (a *Authenticator) Token(token string) (*Principal, error) {
+	p, ok := a.tokens[token]
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	return p, nil
+}
+
+// Authenticate 解析 Authorization 头, 支持 Bearer <token> 和 Basic base64(<user>:<password>)
+func (a *Authenticator) Authenticate(authorization string) (*Principal, error) {
+	scheme, credentials, ok := strings.Cut(authorization, " ")
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		return a.Token(credentials)
+	case "basic":
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return nil, ErrUnauthenticated
+		}
+		name, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return nil, ErrUnauthenticated
+		}
+		return a.Password(name, password)
+	}
+	return nil, ErrUnauthenticated
+}
+
+// Allow 判断用户是否可以对 key 执行 perm 操作, key 为空时只判断操作的权限
+func (p *Principal) Allow(perm Permission, key []byte) error {
+	if p.permissions&perm != perm {
+		return ErrPermissionDenied
+	}
+	if key == nil || p.CanAccess(key) {
+		return nil
+	}
+	return ErrPermissionDenied
+}
+
+// CanAccess 判断 key 是否在用户可以访问的前缀范围内
+func (p *Principal) CanAccess(key []byte) bool {
+	if len(p.prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanPrefixes 返回以 prefix 为前缀的 key 中用户可以访问的前缀, 遍历时直接定位到这些前缀
+// p 为 nil 或者没有前缀限制时返回 prefix 本身, 返回空时用户不能访问任何以 prefix 为前缀的 key
+func (p *Principal) ScanPrefixes(prefix []byte) [][]byte {
+	if p == nil || len(p.prefixes) == 0 {
+		return [][]byte{prefix}
+	}
+	var prefixes [][]byte
+	for _, allowed := range p.prefixes {
+		switch {
+		case bytes.HasPrefix(prefix, allowed):
+			return [][]byte{prefix}
+		case bytes.HasPrefix(allowed, prefix):
+			prefixes = append(prefixes, allowed)
+		}
+	}
+	return prefixes
+}
+
+type principalKey struct{}
+
+// NewContext 返回携带认证用户的 context
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext 返回 context 中的认证用户, 没有时返回 nil
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticator(t *testing.T) {
+	a, err := New([]User{
+		{Name: "admin", Password: "secret", Permissions: []string{"read", "write", "admin"}},
+		{Name: "reader", Tokens: []string{"t1"}, Prefixes: []string{"user:"}, Permissions: []string{"read"}},
+	})
+	assert.Nil(t, err)
+
+	p, err := a.Password("admin", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", p.Name)
+	_, err = a.Password("admin", "wrong")
+	assert.Equal(t, ErrUnauthenticated, err)
+	// 没有设置密码的用户不能使用密码认证
+	_, err = a.Password("reader", "")
+	assert.Equal(t, ErrUnauthenticated, err)
+
+	p, err = a.Authenticate("Bearer t1")
+	assert.Nil(t, err)
+	assert.Equal(t, "reader", p.Name)
+	p, err = a.Authenticate("Basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret")))
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", p.Name)
+	for _, header := range []string{"", "Bearer t2", "Basic !!", "Digest x"} {
+		_, err = a.Authenticate(header)
+		assert.Equal(t, ErrUnauthenticated, err)
+	}
+
+	reader, _ := a.Token("t1")
+	assert.Nil(t, reader.Allow(PermRead, []byte("user:1")))
+	assert.Nil(t, reader.Allow(PermRead, nil))
+	assert.Equal(t, ErrPermissionDenied, reader.Allow(PermRead, []byte("order:1")))
+	assert.Equal(t, ErrPermissionDenied, reader.Allow(PermWrite, []byte("user:1")))
+	assert.Equal(t, ErrPermissionDenied, reader.Allow(PermAdmin, nil))
+	assert.True(t, FromContext(NewContext(context.Background(), reader)) == reader)
+	assert.Nil(t, FromContext(context.Background()))
+
+	// 遍历只定位到用户可以访问的前缀
+	multi := &Principal{prefixes: [][]byte{[]byte("user:"), []byte("order:")}}
+	assert.Equal(t, [][]byte{[]byte("user:"), []byte("order:")}, multi.ScanPrefixes(nil))
+	assert.Equal(t, [][]byte{[]byte("user:1")}, multi.ScanPrefixes([]byte("user:1")))
+	assert.Equal(t, [][]byte{[]byte("user:")}, multi.ScanPrefixes([]byte("us")))
+	assert.Empty(t, multi.ScanPrefixes([]byte("item:")))
+	p, _ = a.Password("admin", "secret")
+	assert.Equal(t, [][]byte{[]byte("item:")}, p.ScanPrefixes([]byte("item:")))
+	var anonymous *Principal
+	assert.Equal(t, [][]byte{nil}, anonymous.ScanPrefixes(nil))
+
+	_, err = New([]User{{Name: "u", Permissions: []string{"root"}}})
+	assert.NotNil(t, err)
+	_, err = New([]User{{Name: "u1", Tokens: []string{"t"}}, {Name: "u2", Tokens: []string{"t"}}})
+	assert.NotNil(t, err)
+	_, err = New([]User{{Name: "u"}, {Name: "u"}})
+	assert.NotNil(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-auth")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.json")
+	content := `{"tls": {"cert_file": "cert.pem", "key_file": "key.pem"}, "users": [{"name": "u", "tokens": ["t"], "permissions": ["read"]}]}`
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0644))
+
+	config, err := LoadConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, &TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}, config.TLS)
+	assert.Equal(t, []User{{Name: "u", Tokens: []string{"t"}, Permissions: []string{"read"}}}, config.Users)
+	// 证书文件不存在
+	_, err = config.TLS.Load()
+	assert.NotNil(t, err)
+
+	assert.Nil(t, os.WriteFile(path, []byte("{"), 0644))
+	_, err = LoadConfig(path)
+	assert.NotNil(t, err)
+}
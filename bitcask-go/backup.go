@@ -0,0 +1,293 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const backupManifestFileName = "backup-manifest"
+
+// 备份清单, 记录备份时刻的所有文件
+// 增量备份中没有变化的文件不会拷贝, 恢复时从父备份中读取
+type backupManifest struct {
+	Parent string       `json:"parent,omitempty"` // 父备份的目录, 相对于当前备份目录
+	Time   int64        `json:"time"`             // 备份点的时间
+	SeqNo  uint64       `json:"seq_no"`           // 备份点的事务序列号
+	Files  []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`     // 备份的长度, 副本的活跃文件只备份到冻结时的写入位置
+	ModTime   int64  `json:"mod_time"` // merge 之后文件名可能相同, 通过修改时间区分
+	Inherited bool   `json:"inherited,omitempty"`
+}
+
+// Backup 全量备份数据库到一个空目录, 备份目录可以直接作为数据目录打开
+func (db *DB) Backup(dir string) error {
+	return db.backup(context.Background(), dir, "")
+}
+
+// BackupContext 和 Backup 相同, 拷贝每个文件之前检查 ctx, 取消时删除已经拷贝的文件
+func (db *DB) BackupContext(ctx context.Context, dir string) error {
+	return db.backup(ctx, dir, "")
+}
+
+// IncrementalBackup 增量备份数据库, 只拷贝相对于 parentDir 中的备份新增或变化的文件
+// 增量备份需要通过 Restore 恢复
+func (db *DB) IncrementalBackup(dir string, parentDir string) error {
+	return db.backup(context.Background(), dir, parentDir)
+}
+
+// IncrementalBackupContext 和 IncrementalBackup 相同, 取消时删除已经拷贝的文件
+func (db *DB) IncrementalBackupContext(ctx context.Context, dir string, parentDir string) error {
+	return db.backup(ctx, dir, parentDir)
+}
+
+func (db *DB) backup(ctx context.Context, dir string, parentDir string) (err error) {
+	start := time.Now()
+	info := BackupInfo{Dir: dir, ParentDir: parentDir}
+	defer func() {
+		info.Duration, info.Err = time.Since(start), err
+		if err != nil {
+			db.logger.Error("backup failed", "dir", dir, "error", err)
+		} else {
+			db.logger.Info("backup completed", "dir", dir, "parent", parentDir, "files", info.Files, "size", info.Size)
+		}
+		db.notifyListener(func(listener EventListener) {
+			listener.OnBackupCompleted(info)
+		})
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := prepareEmptyDir(dir); err != nil {
+		return err
+	}
+	var parent *backupManifest
+	if parentDir != "" {
+		var err error
+		if parent, err = readBackupManifest(parentDir); err != nil {
+			return err
+		}
+	}
+
+	frozen, err := db.freezeFiles()
+	if err != nil {
+		return err
+	}
+
+	// 冻结的文件不会再被修改, 拷贝时不需要持有锁
+	manifest := &backupManifest{Time: frozen.Time, SeqNo: frozen.SeqNo}
+	if parentDir != "" {
+		if manifest.Parent, err = relativePath(dir, parentDir); err != nil {
+			return err
+		}
+	}
+	// 失败或者取消时删除已经拷贝的文件, 目录可以重新用于备份
+	var copied []string
+	defer func() {
+		if err != nil {
+			for _, name := range copied {
+				_ = os.Remove(name)
+			}
+		}
+	}()
+	for _, file := range frozen.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if parent != nil {
+			_, file.Inherited = parent.find(file)
+		}
+		if !file.Inherited {
+			copied = append(copied, filepath.Join(dir, file.Name))
+			if err := utils.CopyFile(filepath.Join(db.options.DirPath, file.Name), filepath.Join(dir, file.Name), file.Size); err != nil {
+				return err
+			}
+			info.Files++
+			info.Size += file.Size
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	// 最后写入清单, 没有清单的目录不是完整的备份
+	return writeBackupManifest(dir, manifest)
+}
+
+// 冻结一个一致的备份点: 持久化并切换活跃文件, 返回备份点之前的所有文件
+// 事务提交时持有写锁, 备份点不会落在事务中间
+func (db *DB) freezeFiles() (*backupManifest, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var files []backupFile
+	if db.activeFile != nil {
+		if err := db.syncActiveFile(); err != nil {
+			return nil, err
+		}
+		activeSize := db.activeFile.WriteOffset
+		// 副本的数据文件需要和主节点保持一致, 只读模式不能修改数据目录, 都不能切换活跃文件, 只备份到当前的写入位置
+		keepActive := db.isReplica() || db.options.ReadOnly
+		if activeSize > 0 && !keepActive {
+			db.oldFiles[db.activeFile.FileId] = db.activeFile
+			if err := db.setActiveDataFile(); err != nil {
+				return nil, err
+			}
+		}
+		for fid := range db.oldFiles {
+			file, err := statBackupFile(db.options.DirPath, filepath.Base(data.GetDataFileName("", fid)))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, *file)
+		}
+		if keepActive && activeSize > 0 {
+			file, err := statBackupFile(db.options.DirPath, filepath.Base(data.GetDataFileName("", db.activeFile.FileId)))
+			if err != nil {
+				return nil, err
+			}
+			file.Size = activeSize
+			files = append(files, *file)
+		}
+	}
+
+	// merge 生成的文件只在启动时替换, 运行过程中不会被修改
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName} {
+		file, err := statBackupFile(db.options.DirPath, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, *file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return &backupManifest{Time: time.Now().UnixNano(), SeqNo: db.seqNo, Files: files}, nil
+}
+
+func statBackupFile(dirPath string, name string) (*backupFile, error) {
+	info, err := os.Stat(filepath.Join(dirPath, name))
+	if err != nil {
+		return nil, err
+	}
+	return &backupFile{Name: name, Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+// Restore 将备份恢复到一个空的数据目录, 增量备份会沿着父备份找到每个文件
+// 布隆过滤器和持久化索引不在备份中, 打开时重新构建
+func Restore(backupDir string, dirPath string) error {
+	manifest, err := readBackupManifest(backupDir)
+	if err != nil {
+		return err
+	}
+	if err := prepareEmptyDir(dirPath); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		src, err := locateBackupFile(backupDir, manifest, file)
+		if err != nil {
+			return err
+		}
+		if err := utils.CopyFile(src, filepath.Join(dirPath, file.Name), file.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 找到文件内容实际所在的备份目录
+func locateBackupFile(dir string, manifest *backupManifest, file backupFile) (string, error) {
+	for file.Inherited {
+		if manifest.Parent == "" {
+			return "", ErrBackupCorrupt
+		}
+		parentDir := manifest.Parent
+		if !filepath.IsAbs(parentDir) {
+			parentDir = filepath.Join(dir, parentDir)
+		}
+		parent, err := readBackupManifest(parentDir)
+		if err != nil {
+			return "", err
+		}
+		f, ok := parent.find(file)
+		if !ok {
+			return "", ErrBackupCorrupt
+		}
+		dir, manifest, file = parentDir, parent, f
+	}
+	return filepath.Join(dir, file.Name), nil
+}
+
+// 查找清单中内容相同的文件
+func (m *backupManifest) find(file backupFile) (backupFile, bool) {
+	for _, f := range m.Files {
+		if f.Name == file.Name && f.Size == file.Size && f.ModTime == file.ModTime {
+			return f, true
+		}
+	}
+	return backupFile{}, false
+}
+
+func readBackupManifest(dir string) (*backupManifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, backupManifestFileName))
+	if os.IsNotExist(err) {
+		return nil, ErrBackupCorrupt
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &backupManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, ErrBackupCorrupt
+	}
+	return manifest, nil
+}
+
+func writeBackupManifest(dir string, manifest *backupManifest) error {
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tmpFile := filepath.Join(dir, backupManifestFileName+".tmp")
+	if err := os.WriteFile(tmpFile, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filepath.Join(dir, backupManifestFileName))
+}
+
+func relativePath(base string, target string) (string, error) {
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return "", err
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(base, target)
+}
+
+// 目录不存在时创建, 已存在时必须为空
+func prepareEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return os.MkdirAll(dir, os.ModePerm)
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return ErrDirectoryNotEmpty
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_IncrementalBackup(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-incr-backup")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	backupRoot, _ := os.MkdirTemp("", "bitcask-go-incr-backup-root")
+	defer os.RemoveAll(backupRoot)
+	fullDir := filepath.Join(backupRoot, "full")
+	assert.Nil(t, db.Backup(fullDir))
+	// 备份目录必须为空
+	assert.Equal(t, ErrDirectoryNotEmpty, db.Backup(fullDir))
+
+	for i := 1000; i < 1200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+
+	incrDir := filepath.Join(backupRoot, "incr-1")
+	assert.Nil(t, db.IncrementalBackup(incrDir, fullDir))
+	// 增量备份只拷贝新的数据文件
+	fullManifest, err := readBackupManifest(fullDir)
+	assert.Nil(t, err)
+	incrManifest, err := readBackupManifest(incrDir)
+	assert.Nil(t, err)
+	entries, _ := os.ReadDir(incrDir)
+	assert.Equal(t, len(incrManifest.Files)-len(fullManifest.Files)+1, len(entries))
+
+	// 第二次增量备份基于第一次增量备份
+	assert.Nil(t, db.Put([]byte("last-key"), []byte("last-value")))
+	incrDir2 := filepath.Join(backupRoot, "incr-2")
+	assert.Nil(t, db.IncrementalBackup(incrDir2, incrDir))
+
+	// 备份之后的写入不在备份中
+	assert.Nil(t, db.Put([]byte("after-backup"), []byte("v")))
+
+	// 移动整个备份目录之后仍然可以恢复
+	movedRoot := backupRoot + "-moved"
+	assert.Nil(t, os.Rename(backupRoot, movedRoot))
+	defer os.RemoveAll(movedRoot)
+
+	restoreDir, _ := os.MkdirTemp("", "bitcask-go-incr-restore")
+	assert.Nil(t, Restore(filepath.Join(movedRoot, "incr-2"), restoreDir))
+	assert.Equal(t, ErrDirectoryNotEmpty, Restore(filepath.Join(movedRoot, "incr-2"), restoreDir))
+	restoreOpts := DefaultOptions
+	restoreOpts.DirPath = restoreDir
+	db2, err := Open(restoreOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	for i := 0; i < 1200; i++ {
+		value, err := db2.Get(utils.GetTestKey(i))
+		if i < 100 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		expected, _ := db.Get(utils.GetTestKey(i))
+		assert.Equal(t, expected, value)
+	}
+	value, err := db2.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), value)
+	value, err = db2.Get([]byte("last-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("last-value"), value)
+	_, err = db2.Get([]byte("after-backup"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 从全量备份恢复只包含全量备份时的数据
+	restoreDir2, _ := os.MkdirTemp("", "bitcask-go-full-restore")
+	assert.Nil(t, Restore(filepath.Join(movedRoot, "full"), restoreDir2))
+	restoreOpts.DirPath = restoreDir2
+	db3, err := Open(restoreOpts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db3.ListKeys()))
+
+	// 没有清单的目录不能恢复
+	emptyDir, _ := os.MkdirTemp("", "bitcask-go-empty-backup")
+	defer os.RemoveAll(emptyDir)
+	assert.Equal(t, ErrBackupCorrupt, Restore(emptyDir, filepath.Join(emptyDir, "restore")))
+}
+
+func TestDB_BackupAfterMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	backupRoot, _ := os.MkdirTemp("", "bitcask-go-backup-merge-root")
+	defer os.RemoveAll(backupRoot)
+	fullDir := filepath.Join(backupRoot, "full")
+	assert.Nil(t, db.Backup(fullDir))
+
+	// merge 之后重新打开, 低编号的数据文件被替换
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	incrDir := filepath.Join(backupRoot, "incr")
+	assert.Nil(t, db.IncrementalBackup(incrDir, fullDir))
+	restoreDir, _ := os.MkdirTemp("", "bitcask-go-backup-merge-restore")
+	assert.Nil(t, Restore(incrDir, restoreDir))
+	restoreOpts := DefaultOptions
+	restoreOpts.DirPath = restoreDir
+	db2, err := Open(restoreOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	for i := 500; i < 1000; i++ {
+		expected, _ := db.Get(utils.GetTestKey(i))
+		value, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, value)
+	}
+}
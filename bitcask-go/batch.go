@@ -2,9 +2,13 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const nonTransactionSeqNo uint64 = 0
@@ -12,24 +16,36 @@ const nonTransactionSeqNo uint64 = 0
 var txnFinKey = []byte("txn-fin")
 
 type WriteBatch struct {
-	options       WriteBatchOptions
-	mu            *sync.Mutex
-	db            *DB
-	pendingWrites map[string]*data.LogRecord
+	options         WriteBatchOptions
+	mu              *sync.Mutex
+	db              *DB
+	pendingWrites   map[string]*data.LogRecord
+	namespaceWrites map[string]*data.LogRecord // 命名空间中的数据, key 为 命名空间 id | key
+	rangeDeletes    []*data.LogRecord          // 范围删除, 提交时在其他数据之前应用
+}
+
+// NamespaceWriteBatch 写入一个命名空间的 WriteBatch
+// 同一个 WriteBatch 得到的多个 NamespaceWriteBatch 在一个事务中提交
+type NamespaceWriteBatch struct {
+	wb *WriteBatch
+	ns *Namespace
 }
 
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
-	if db.options.IndexType == BPlusTree && !db.seqNoExists && !db.isInitial {
-		panic("cannot use WriteBatch with BPlusTree, seq no file not exists")
-	}
 	return &WriteBatch{
-		options:       opts,
-		mu:            &sync.Mutex{},
-		db:            db,
-		pendingWrites: make(map[string]*data.LogRecord),
+		options:         opts,
+		mu:              &sync.Mutex{},
+		db:              db,
+		pendingWrites:   make(map[string]*data.LogRecord),
+		namespaceWrites: make(map[string]*data.LogRecord),
 	}
 }
 
+// Namespace 返回写入命名空间 ns 的 WriteBatch, 和当前的 WriteBatch 一起提交
+func (wb *WriteBatch) Namespace(ns *Namespace) *NamespaceWriteBatch {
+	return &NamespaceWriteBatch{wb: wb, ns: ns}
+}
+
 func (wb *WriteBatch) Put(key, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
@@ -53,7 +69,10 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	defer wb.mu.Unlock()
 
 	// 数据不存在直接返回
-	logRecordPos := wb.db.index.Get(key)
+	var logRecordPos *data.LogRecordPos
+	if wb.db.keyMayExist(key) {
+		logRecordPos = wb.db.index.Get(key)
+	}
 	if logRecordPos == nil {
 		if wb.pendingWrites[string(key)] != nil {
 			delete(wb.pendingWrites, string(key))
@@ -66,24 +85,136 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	return nil
 }
 
+// DeleteRange 在提交时删除 [start, end) 范围内的所有 key, end 为空时删除 start 之后所有的 key
+// 范围删除先于同一批次中的其他写入应用, 批次中写入范围内的 key 不会被删除
+func (wb *WriteBatch) DeleteRange(start, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.rangeDeletes = append(wb.rangeDeletes, &data.LogRecord{
+		Key:   start,
+		Value: end,
+		Type:  data.LogRecordRangeDelete,
+	})
+	return nil
+}
+
+// DropPrefix 在提交时删除以 prefix 为前缀的所有 key
+func (wb *WriteBatch) DropPrefix(prefix []byte) error {
+	return wb.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+func (nwb *NamespaceWriteBatch) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	nwb.wb.mu.Lock()
+	defer nwb.wb.mu.Unlock()
+
+	nsKey := nwb.ns.encodeKey(key)
+	nwb.wb.namespaceWrites[string(nsKey)] = &data.LogRecord{
+		Key:   nsKey,
+		Value: value,
+		Type:  data.LogRecordNamespacePut,
+	}
+	return nil
+}
+
+func (nwb *NamespaceWriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	nwb.wb.mu.Lock()
+	defer nwb.wb.mu.Unlock()
+
+	// 数据不存在直接返回
+	nsKey := nwb.ns.encodeKey(key)
+	nwb.wb.db.mu.RLock()
+	logRecordPos := nwb.ns.index.Get(key)
+	nwb.wb.db.mu.RUnlock()
+	if logRecordPos == nil {
+		delete(nwb.wb.namespaceWrites, string(nsKey))
+		return nil
+	}
+	nwb.wb.namespaceWrites[string(nsKey)] = &data.LogRecord{Key: nsKey, Type: data.LogRecordNamespaceDelete}
+	return nil
+}
+
+// Commit 提交整个 WriteBatch, 包括其他命名空间和默认命名空间中的数据
+func (nwb *NamespaceWriteBatch) Commit() error {
+	return nwb.wb.Commit()
+}
+
 func (wb *WriteBatch) Commit() error {
+	return wb.CommitContext(context.Background())
+}
+
+// CommitContext 和 Commit 相同, 在开始写入之前 ctx 已经取消时不提交, 开始写入之后不会中断
+func (wb *WriteBatch) CommitContext(ctx context.Context) (err error) {
+	defer wb.db.observe(OpBatchCommit, time.Now(), &err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	if len(wb.pendingWrites) == 0 {
+	if len(wb.pendingWrites) == 0 && len(wb.namespaceWrites) == 0 && len(wb.rangeDeletes) == 0 {
 		return nil
 	}
+	if err := wb.db.checkWritable(); err != nil {
+		return err
+	}
 
-	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
+	if uint(len(wb.pendingWrites)+len(wb.namespaceWrites)+len(wb.rangeDeletes)) > wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 保证事务提交的串行化
+	// 保证事务提交的串行化, 变更事件在释放锁之后发送
+	var pub *publication
+	defer func() { pub.deliver() }()
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
+	// 等待锁的过程中可能已经取消
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// 命名空间在提交之前可能已经被删除
+	for _, record := range wb.namespaceWrites {
+		if id, _ := parseNamespaceKey(record.Key); wb.db.namespaceIds[id] == nil {
+			return ErrNamespaceDropped
+		}
+	}
+
+	// 范围内没有数据时不写入
+	var rangeDeletes []*data.LogRecord
+	for _, record := range wb.rangeDeletes {
+		if wb.db.hasKeyInRange(record.Key, record.Value) {
+			rangeDeletes = append(rangeDeletes, record)
+		}
+	}
+	if len(wb.pendingWrites) == 0 && len(wb.namespaceWrites) == 0 && len(rangeDeletes) == 0 {
+		wb.rangeDeletes = nil
+		return nil
+	}
 
 	// 获取当前最新事务序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	// 范围删除最先写入, 重放时和提交时一样先于其他数据应用
+	rangePositions := make([]*data.LogRecordPos, len(rangeDeletes))
+	for i, record := range rangeDeletes {
+		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeqNo(record.Key, seqNo),
+			Value: record.Value,
+			Type:  record.Type,
+		})
+		if err != nil {
+			return err
+		}
+		rangePositions[i] = logRecordPos
+	}
 	positions := map[string]*data.LogRecordPos{}
 	for _, record := range wb.pendingWrites {
 		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
@@ -96,40 +227,81 @@ func (wb *WriteBatch) Commit() error {
 		}
 		positions[string(record.Key)] = logRecordPos
 	}
+	nsPositions := make(map[string]*data.LogRecordPos, len(wb.namespaceWrites))
+	for nsKey, record := range wb.namespaceWrites {
+		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeqNo(record.Key, seqNo),
+			Value: record.Value,
+			Type:  record.Type,
+		})
+		if err != nil {
+			return err
+		}
+		nsPositions[nsKey] = logRecordPos
+	}
 	finishedLogRecord := &data.LogRecord{
 		Key:  logRecordKeyWithSeqNo(txnFinKey, seqNo),
 		Type: data.LogRecordTxnFinished,
 	}
-	_, err := wb.db.appendLogRecord(finishedLogRecord)
+	finishedPos, err := wb.db.appendLogRecord(finishedLogRecord)
 	if err != nil {
 		return err
 	}
 
 	// 持久化
 	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		err := wb.db.activeFile.Sync()
+		err := wb.db.syncActiveFile()
 		if err != nil {
 			return err
 		}
 	}
 
-	// 更新内存索引
+	var events []Event
+	for i, record := range rangeDeletes {
+		wb.db.reclaimSize += int64(rangePositions[i].Size)
+		rangeEvents, err := wb.db.deleteRangeFromIndex(record.Key, record.Value, rangePositions[i], true)
+		if err != nil {
+			return err
+		}
+		events = append(events, rangeEvents...)
+	}
+
+	// 更新索引, 持久化索引在一个事务中完成整个批次的更新
+	entries := make([]*index.BatchEntry, 0, len(wb.pendingWrites))
 	for _, record := range wb.pendingWrites {
 		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordTypeNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		}
 		if record.Type == data.LogRecordTypeDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			entries = append(entries, &index.BatchEntry{Key: record.Key})
+			wb.db.reclaimSize += int64(pos.Size)
+		} else {
+			entries = append(entries, &index.BatchEntry{Key: record.Key, Pos: pos})
 		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
+	}
+	if len(entries) > 0 {
+		if err := wb.db.applyToIndex(entries, finishedPos); err != nil {
+			return err
+		}
+	}
+	for nsKey, record := range wb.namespaceWrites {
+		if _, err := wb.db.replayNamespaceRecord(record.Key, record, nsPositions[nsKey]); err != nil {
+			return err
+		}
+	}
+
+	// 事务提交之后发布变更事件
+	for _, record := range wb.pendingWrites {
+		if record.Type == data.LogRecordTypeDeleted {
+			events = append(events, Event{Type: EventDelete, Key: record.Key})
+		} else {
+			events = append(events, Event{Type: EventPut, Key: record.Key, Value: record.Value})
 		}
 	}
+	pub = wb.db.publish(events)
 
 	// 清空暂存数据结构
 	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.namespaceWrites = make(map[string]*data.LogRecord)
+	wb.rangeDeletes = nil
 	return nil
 }
 
@@ -0,0 +1,105 @@
+package bitcask_go
+
+import (
+	"bitcask-go/bloom"
+	"bitcask-go/data"
+	"os"
+	"path/filepath"
+)
+
+const (
+	bloomFilterKey             = "bloomFilter"
+	bloomFilterInitialCapacity = 1 << 16
+)
+
+// 加载布隆过滤器, 返回是否从文件中加载到了持久化的过滤器
+func (db *DB) loadBloomFilter() (bool, error) {
+	if db.options.BloomFalsePositive <= 0 {
+		return false, nil
+	}
+
+	fileName := filepath.Join(db.options.DirPath, data.BloomFilterFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		db.filter = bloom.NewFilter(bloomFilterInitialCapacity, db.options.BloomFalsePositive)
+		return false, nil
+	}
+
+	filterFile, err := data.OpenReadOnlyFile(db.options.DirPath, data.BloomFilterFileName)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = filterFile.Close()
+	}()
+	filterFile.SetKeyProvider(db.options.KeyProvider)
+	record, _, err := filterFile.ReadLogRecord(0)
+	if err != nil {
+		return false, err
+	}
+	filter, err := bloom.Decode(record.Value)
+	if err != nil {
+		return false, err
+	}
+	db.filter = filter
+	// 过滤器只在正常关闭时写入, 加载之后删除, 避免异常退出后使用过期的过滤器, 只读模式下不修改数据目录
+	if db.options.ReadOnly {
+		return true, nil
+	}
+	return true, os.Remove(fileName)
+}
+
+// 通过遍历索引重建布隆过滤器, 用于不会在启动时重放数据文件的索引
+// 丢弃重放时加入的 key, 过滤器中只包含索引中的 key
+func (db *DB) rebuildBloomFilter() {
+	if db.filter == nil {
+		return
+	}
+	capacity := uint64(db.index.Size())
+	if capacity < bloomFilterInitialCapacity {
+		capacity = bloomFilterInitialCapacity
+	}
+	db.filter = bloom.NewFilter(capacity, db.options.BloomFalsePositive)
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		db.filter.Add(iterator.Key())
+	}
+}
+
+// 持久化布隆过滤器到数据目录中
+func (db *DB) saveBloomFilter() error {
+	if db.filter == nil {
+		return nil
+	}
+	return writeBloomFilter(db.options.DirPath, db.filter, db.options.KeyProvider)
+}
+
+// 过滤器可以用于判断 key 是否存在, 和数据文件一样加密
+func writeBloomFilter(dirPath string, filter *bloom.Filter, provider data.KeyProvider) error {
+	fileName := filepath.Join(dirPath, data.BloomFilterFileName)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	filterFile, err := data.OpenBloomFilterFile(dirPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = filterFile.Close()
+	}()
+	filterFile.SetKeyProvider(provider)
+	record := &data.LogRecord{
+		Key:   []byte(bloomFilterKey),
+		Value: filter.Encode(),
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := filterFile.Write(encRecord); err != nil {
+		return err
+	}
+	return filterFile.Sync()
+}
+
+// 根据布隆过滤器判断 key 是否一定不存在
+func (db *DB) keyMayExist(key []byte) bool {
+	return db.filter == nil || db.filter.MayContain(key)
+}
@@ -0,0 +1,213 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+var (
+	ErrInvalidFilterData = errors.New("invalid bloom filter data")
+)
+
+const (
+	// 每次扩容时新的子过滤器容量的增长倍数
+	growthFactor = 2
+	// 每次扩容时新的子过滤器误判率的收紧比例, 总误判率不超过 fpRate / (1 - tighteningRatio)
+	tighteningRatio = 0.5
+)
+
+// Filter 可扩展的布隆过滤器
+// 由若干个容量递增的子过滤器组成, key 数量超过当前容量时追加新的子过滤器, 保证整体误判率
+type Filter struct {
+	lock    *sync.RWMutex
+	fpRate  float64   // 期望的整体误判率
+	filters []*filter // 子过滤器
+}
+
+// 单个固定容量的布隆过滤器
+type filter struct {
+	bits     []uint64 // 位数组
+	m        uint64   // 位数组的长度
+	k        uint64   // 哈希函数个数
+	capacity uint64   // 容量
+	count    uint64   // 已添加的 key 数量
+}
+
+// NewFilter 创建布隆过滤器, capacity 为初始容量, fpRate 为期望误判率
+func NewFilter(capacity uint64, fpRate float64) *Filter {
+	if capacity == 0 {
+		capacity = 1
+	}
+	return &Filter{
+		lock:    &sync.RWMutex{},
+		fpRate:  fpRate,
+		filters: []*filter{newFilter(capacity, fpRate*(1-tighteningRatio))},
+	}
+}
+
+func newFilter(capacity uint64, fpRate float64) *filter {
+	// m = -n * ln(p) / (ln2)^2, k = m / n * ln2
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(capacity) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &filter{
+		bits:     make([]uint64, (m+63)/64),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+	}
+}
+
+// Add 添加一个 key
+func (f *Filter) Add(key []byte) {
+	h1, h2 := hashKey(key)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	last := f.filters[len(f.filters)-1]
+	if last.count >= last.capacity {
+		fpRate := f.fpRate * (1 - tighteningRatio) * math.Pow(tighteningRatio, float64(len(f.filters)))
+		last = newFilter(last.capacity*growthFactor, fpRate)
+		f.filters = append(f.filters, last)
+	}
+	last.add(h1, h2)
+}
+
+// MayContain 判断 key 是否可能存在, 返回 false 时 key 一定不存在
+func (f *Filter) MayContain(key []byte) bool {
+	h1, h2 := hashKey(key)
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	for _, sf := range f.filters {
+		if sf.mayContain(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Count 返回添加 key 的次数, 重复添加的 key 会被多次计数
+func (f *Filter) Count() uint64 {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	var count uint64
+	for _, sf := range f.filters {
+		count += sf.count
+	}
+	return count
+}
+
+func (sf *filter) add(h1, h2 uint64) {
+	for i := uint64(0); i < sf.k; i++ {
+		idx := (h1 + i*h2) % sf.m
+		sf.bits[idx/64] |= 1 << (idx % 64)
+	}
+	sf.count++
+}
+
+func (sf *filter) mayContain(h1, h2 uint64) bool {
+	for i := uint64(0); i < sf.k; i++ {
+		idx := (h1 + i*h2) % sf.m
+		if sf.bits[idx/64]&(1<<(idx%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 使用 double hashing 由两个哈希值模拟 k 个哈希函数
+func hashKey(key []byte) (uint64, uint64) {
+	hash := fnv.New64a()
+	_, _ = hash.Write(key)
+	h1 := hash.Sum64()
+	// 对 h1 再做一次混淆得到 h2, 保证 h2 为奇数
+	h2 := h1
+	h2 ^= h2 >> 33
+	h2 *= 0xff51afd7ed558ccd
+	h2 ^= h2 >> 33
+	return h1, h2 | 1
+}
+
+// Encode 将布隆过滤器编码为字节数组
+// fpRate | filter num | capacity | count | k | m | bits ...
+func (f *Filter) Encode() []byte {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	var size = 8 + binary.MaxVarintLen64
+	for _, sf := range f.filters {
+		size += binary.MaxVarintLen64*4 + len(sf.bits)*8
+	}
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint64(buf[:8], math.Float64bits(f.fpRate))
+	var index = 8
+	index += binary.PutUvarint(buf[index:], uint64(len(f.filters)))
+	for _, sf := range f.filters {
+		index += binary.PutUvarint(buf[index:], sf.capacity)
+		index += binary.PutUvarint(buf[index:], sf.count)
+		index += binary.PutUvarint(buf[index:], sf.k)
+		index += binary.PutUvarint(buf[index:], sf.m)
+		for _, word := range sf.bits {
+			binary.LittleEndian.PutUint64(buf[index:index+8], word)
+			index += 8
+		}
+	}
+	return buf[:index]
+}
+
+// Decode 从字节数组中解码布隆过滤器
+func Decode(buf []byte) (*Filter, error) {
+	if len(buf) < 8 {
+		return nil, ErrInvalidFilterData
+	}
+	f := &Filter{
+		lock:   &sync.RWMutex{},
+		fpRate: math.Float64frombits(binary.LittleEndian.Uint64(buf[:8])),
+	}
+	var index = 8
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return 0, ErrInvalidFilterData
+		}
+		index += n
+		return v, nil
+	}
+
+	num, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < num; i++ {
+		var fields [4]uint64
+		for j := range fields {
+			if fields[j], err = readUvarint(); err != nil {
+				return nil, err
+			}
+		}
+		sf := &filter{capacity: fields[0], count: fields[1], k: fields[2], m: fields[3]}
+		words := int((sf.m + 63) / 64)
+		if sf.m == 0 || len(buf)-index < words*8 {
+			return nil, ErrInvalidFilterData
+		}
+		sf.bits = make([]uint64, words)
+		for j := range sf.bits {
+			sf.bits[j] = binary.LittleEndian.Uint64(buf[index : index+8])
+			index += 8
+		}
+		f.filters = append(f.filters, sf)
+	}
+	if len(f.filters) == 0 {
+		return nil, ErrInvalidFilterData
+	}
+	return f, nil
+}
@@ -0,0 +1,60 @@
+package bloom
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFilter_Add(t *testing.T) {
+	f := NewFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(utils.GetTestKey(i))
+	}
+	assert.Equal(t, uint64(1000), f.Count())
+
+	// 添加过的 key 一定存在
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.MayContain(utils.GetTestKey(i)))
+	}
+}
+
+func TestFilter_FalsePositive(t *testing.T) {
+	// 超过初始容量, 会自动扩容
+	f := NewFilter(1000, 0.01)
+	for i := 0; i < 10000; i++ {
+		f.Add(utils.GetTestKey(i))
+	}
+	assert.Greater(t, len(f.filters), 1)
+	for i := 0; i < 10000; i++ {
+		assert.True(t, f.MayContain(utils.GetTestKey(i)))
+	}
+
+	var falsePositive int
+	for i := 10000; i < 110000; i++ {
+		if f.MayContain(utils.GetTestKey(i)) {
+			falsePositive++
+		}
+	}
+	rate := float64(falsePositive) / 100000
+	t.Log(rate)
+	assert.Less(t, rate, 0.02)
+}
+
+func TestFilter_Encode(t *testing.T) {
+	f := NewFilter(100, 0.01)
+	for i := 0; i < 500; i++ {
+		f.Add(utils.GetTestKey(i))
+	}
+
+	f2, err := Decode(f.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, f.Count(), f2.Count())
+	assert.Equal(t, len(f.filters), len(f2.filters))
+	for i := 0; i < 500; i++ {
+		assert.True(t, f2.MayContain(utils.GetTestKey(i)))
+	}
+
+	_, err = Decode([]byte("bad"))
+	assert.Equal(t, ErrInvalidFilterData, err)
+}
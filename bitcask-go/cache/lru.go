@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"bitcask-go/data"
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// 每个缓存项除了 value 之外额外占用的内存估算值
+const entryOverhead = 64
+
+// Key 缓存的 key, 由数据在磁盘上的位置唯一确定
+// 数据文件只会追加写入, 同一位置的数据不会改变, key 被更新后位置随之改变, 旧的缓存项自然失效
+type Key struct {
+	Fid    uint32
+	Offset int64
+}
+
+// KeyOf 根据数据的位置索引得到缓存的 key
+func KeyOf(pos *data.LogRecordPos) Key {
+	return Key{Fid: pos.Fid, Offset: pos.Offset}
+}
+
+type entry struct {
+	key   Key
+	value []byte
+}
+
+// LRUCache 按照字节大小限制容量的 LRU 缓存
+type LRUCache struct {
+	lock     *sync.Mutex
+	capacity int64                 // 缓存容量, 单位字节
+	size     int64                 // 当前占用的大小
+	ll       *list.List            // 最近使用的在链表头部
+	items    map[Key]*list.Element // key 对应的链表节点
+	hits     uint64                // 命中次数
+	misses   uint64                // 未命中次数
+}
+
+// NewLRUCache 创建容量为 capacity 字节的 LRU 缓存
+func NewLRUCache(capacity int64) *LRUCache {
+	return &LRUCache{
+		lock:     &sync.Mutex{},
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[Key]*list.Element),
+	}
+}
+
+// Get 获取缓存的 value, 返回的是缓存数据的拷贝
+func (c *LRUCache) Get(key Key) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	c.ll.MoveToFront(elem)
+	value := elem.Value.(*entry).value
+	return append(make([]byte, 0, len(value)), value...), true
+}
+
+// Put 添加缓存, 超过容量时淘汰最久未使用的数据
+func (c *LRUCache) Put(key Key, value []byte) {
+	cost := entrySize(value)
+	if cost > c.capacity {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		return
+	}
+	ent := &entry{key: key, value: append(make([]byte, 0, len(value)), value...)}
+	c.items[key] = c.ll.PushFront(ent)
+	c.size += cost
+
+	for c.size > c.capacity {
+		c.removeOldest()
+	}
+}
+
+func (c *LRUCache) removeOldest() {
+	elem := c.ll.Back()
+	if elem == nil {
+		return
+	}
+	ent := elem.Value.(*entry)
+	c.ll.Remove(elem)
+	delete(c.items, ent.key)
+	c.size -= entrySize(ent.value)
+}
+
+// Len 缓存的数据条数
+func (c *LRUCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+// Size 缓存当前占用的字节数
+func (c *LRUCache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.size
+}
+
+// Hits 缓存命中次数
+func (c *LRUCache) Hits() uint64 {
+	return atomic.LoadUint64(&c.hits)
+}
+
+// Misses 缓存未命中次数
+func (c *LRUCache) Misses() uint64 {
+	return atomic.LoadUint64(&c.misses)
+}
+
+func entrySize(value []byte) int64 {
+	return int64(len(value)) + entryOverhead
+}
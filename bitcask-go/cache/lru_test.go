@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"bitcask-go/data"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLRUCache_Get(t *testing.T) {
+	c := NewLRUCache(1024)
+
+	val, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.False(t, ok)
+	assert.Nil(t, val)
+
+	c.Put(Key{Fid: 1, Offset: 0}, []byte("value-1"))
+	val, ok = c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value-1"), val)
+
+	// 修改返回的数据不影响缓存
+	val[0] = 'x'
+	val, ok = c.Get(KeyOf(&data.LogRecordPos{Fid: 1, Offset: 0, Size: 20}))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value-1"), val)
+
+	assert.Equal(t, uint64(2), c.Hits())
+	assert.Equal(t, uint64(1), c.Misses())
+}
+
+func TestLRUCache_Evict(t *testing.T) {
+	// 每条数据占用 100 + 64 字节, 最多容纳 3 条
+	c := NewLRUCache(500)
+	value := make([]byte, 100)
+	c.Put(Key{Fid: 1, Offset: 0}, value)
+	c.Put(Key{Fid: 1, Offset: 100}, value)
+	c.Put(Key{Fid: 1, Offset: 200}, value)
+	assert.Equal(t, 3, c.Len())
+
+	// 访问第一条, 使其成为最近使用的数据
+	_, ok := c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+
+	c.Put(Key{Fid: 1, Offset: 300}, value)
+	assert.Equal(t, 3, c.Len())
+	assert.LessOrEqual(t, c.Size(), int64(500))
+
+	_, ok = c.Get(Key{Fid: 1, Offset: 100})
+	assert.False(t, ok)
+	_, ok = c.Get(Key{Fid: 1, Offset: 0})
+	assert.True(t, ok)
+
+	// 超过容量的数据不会被缓存
+	c.Put(Key{Fid: 2, Offset: 0}, make([]byte, 1024))
+	_, ok = c.Get(Key{Fid: 2, Offset: 0})
+	assert.False(t, ok)
+}
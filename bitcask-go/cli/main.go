@@ -0,0 +1,101 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage:
+  bitcask-cli -dir <data dir> export [-format binary|jsonl] [-prefix <prefix>] [-o <file>]
+  bitcask-cli -dir <data dir> import [-prefix <prefix>] [-i <file>]
+`
+
+func main() {
+	dirPath := flag.String("dir", "", "data directory of the database")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	if *dirPath == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	options := bitcask.DefaultOptions
+	options.DirPath = *dirPath
+	// 导出时只读打开, 可以导出正在被其他进程写入的数据目录
+	options.ReadOnly = flag.Arg(0) == "export"
+	db, err := bitcask.Open(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open bitcask db, %v\n", err)
+		os.Exit(1)
+	}
+
+	switch flag.Arg(0) {
+	case "export":
+		err = runExport(db, flag.Args()[1:])
+	case "import":
+		err = runImport(db, flag.Args()[1:])
+	default:
+		flag.Usage()
+		err = fmt.Errorf("unknown command %q", flag.Arg(0))
+	}
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func runExport(db *bitcask.DB, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "jsonl", "export format, binary or jsonl")
+	prefix := flags.String("prefix", "", "only export keys with the prefix")
+	output := flags.String("o", "", "output file, default stdout")
+	_ = flags.Parse(args)
+
+	format, err := bitcask.ParseExportFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return db.ExportWithOptions(w, bitcask.ExportOptions{Format: format, Prefix: []byte(*prefix)})
+}
+
+func runImport(db *bitcask.DB, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	prefix := flags.String("prefix", "", "only import keys with the prefix")
+	input := flags.String("i", "", "input file, default stdin")
+	_ = flags.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	opts := bitcask.DefaultImportOptions
+	opts.Prefix = []byte(*prefix)
+	n, err := db.ImportWithOptions(r, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "imported %d entries\n", n)
+	return nil
+}
@@ -0,0 +1,238 @@
+// Package cluster 基于 raft 的多副本 KV 集群, 写入通过 raft 日志复制之后应用到每个节点的 DB
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"errors"
+	"github.com/hashicorp/raft"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrNotLeader     = errors.New("node is not the leader")
+	ErrInvalidConfig = errors.New("node id, dir path and transport must be set")
+)
+
+const (
+	dataDirName     = "data"
+	raftLogDirName  = "raft"
+	snapshotDirName = "snapshots"
+	// 保留的快照数量
+	snapshotRetain = 2
+
+	defaultApplyTimeout = 10 * time.Second
+)
+
+// Config 集群节点的配置
+type Config struct {
+	NodeID    string          // 节点 id, 在集群中唯一
+	DirPath   string          // 节点的数据目录, 其中包含 DB 数据, raft 日志和快照
+	Transport raft.Transport  // 节点间通信, 测试时可以使用 raft.NewInmemTransport
+	Options   bitcask.Options // DB 的配置, DirPath 会被忽略
+	// raft 的配置, 为空时使用 raft.DefaultConfig, LocalID 会被设置为 NodeID
+	Raft *raft.Config
+	// 是否以只包含自己的配置初始化集群, 只需要在第一个节点上设置, 其它节点通过 Join 加入
+	Bootstrap bool
+	// 写入等待提交的超时时间
+	ApplyTimeout time.Duration
+}
+
+// Node 集群中的一个节点
+type Node struct {
+	config   Config
+	raft     *raft.Raft
+	fsm      *fsm
+	logStore *logStore
+}
+
+// NewNode 创建并启动集群节点
+func NewNode(config Config) (*Node, error) {
+	if config.NodeID == "" || config.DirPath == "" || config.Transport == nil {
+		return nil, ErrInvalidConfig
+	}
+	if config.ApplyTimeout <= 0 {
+		config.ApplyTimeout = defaultApplyTimeout
+	}
+	raftConfig := raft.DefaultConfig()
+	if config.Raft != nil {
+		c := *config.Raft
+		raftConfig = &c
+	}
+	raftConfig.LocalID = raft.ServerID(config.NodeID)
+	// DB 是持久化的, 不需要在启动时从快照恢复
+	raftConfig.NoSnapshotRestoreOnStart = true
+
+	if err := os.MkdirAll(config.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	var logOutput io.Writer = os.Stderr
+	if raftConfig.LogOutput != nil {
+		logOutput = raftConfig.LogOutput
+	}
+	snapshots, err := raft.NewFileSnapshotStore(filepath.Join(config.DirPath, snapshotDirName), snapshotRetain, logOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	logOptions := bitcask.DefaultOptions
+	logOptions.DirPath = filepath.Join(config.DirPath, raftLogDirName)
+	logOptions.SyncWrites = false
+	logStore, err := openLogStore(logOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	dataOptions := config.Options
+	dataOptions.DirPath = filepath.Join(config.DirPath, dataDirName)
+	fsm, err := openFSM(dataOptions)
+	if err != nil {
+		_ = logStore.Close()
+		return nil, err
+	}
+
+	closeAll := func() {
+		_ = fsm.close()
+		_ = logStore.Close()
+	}
+	r, err := raft.NewRaft(raftConfig, fsm, logStore, logStore, snapshots, config.Transport)
+	if err != nil {
+		closeAll()
+		return nil, err
+	}
+
+	if config.Bootstrap {
+		hasState, err := raft.HasExistingState(logStore, logStore, snapshots)
+		if err != nil {
+			_ = r.Shutdown().Error()
+			closeAll()
+			return nil, err
+		}
+		if !hasState {
+			configuration := raft.Configuration{Servers: []raft.Server{{
+				ID:      raftConfig.LocalID,
+				Address: config.Transport.LocalAddr(),
+			}}}
+			if err := r.BootstrapCluster(configuration).Error(); err != nil {
+				_ = r.Shutdown().Error()
+				closeAll()
+				return nil, err
+			}
+		}
+	}
+
+	return &Node{config: config, raft: r, fsm: fsm, logStore: logStore}, nil
+}
+
+// Put 写入数据, 只能在 leader 上调用
+func (n *Node) Put(key []byte, value []byte) error {
+	return n.Batch([]Op{{Key: key, Value: value}})
+}
+
+// Delete 删除数据, 只能在 leader 上调用
+func (n *Node) Delete(key []byte) error {
+	return n.Batch([]Op{{Key: key, Delete: true}})
+}
+
+// Batch 原子地执行一批写入, 只能在 leader 上调用
+func (n *Node) Batch(ops []Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	for _, op := range ops {
+		if len(op.Key) == 0 {
+			return bitcask.ErrKeyIsEmpty
+		}
+	}
+	future := n.raft.Apply(encodeCommand(ops), n.config.ApplyTimeout)
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return ErrNotLeader
+		}
+		return err
+	}
+	// 状态机应用写入的结果
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// Get 线性一致地读取数据, 只能在 leader 上调用
+// 先确认自己仍然是 leader, 然后等待状态机应用到确认时的提交位置
+func (n *Node) Get(key []byte) ([]byte, error) {
+	readIndex := n.raft.CommitIndex()
+	if err := n.raft.VerifyLeader().Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return nil, ErrNotLeader
+		}
+		return nil, err
+	}
+	if err := n.waitApplied(readIndex); err != nil {
+		return nil, err
+	}
+	return n.fsm.get(key)
+}
+
+// LocalGet 从本地状态机读取数据, 可以在任意节点上调用, 可能读到旧的数据
+func (n *Node) LocalGet(key []byte) ([]byte, error) {
+	return n.fsm.get(key)
+}
+
+func (n *Node) waitApplied(index uint64) error {
+	deadline := time.Now().Add(n.config.ApplyTimeout)
+	for n.raft.AppliedIndex() < index {
+		if time.Now().After(deadline) {
+			return raft.ErrEnqueueTimeout
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+// Join 将节点加入集群, 只能在 leader 上调用
+func (n *Node) Join(nodeID string, addr raft.ServerAddress) error {
+	err := n.raft.AddVoter(raft.ServerID(nodeID), addr, 0, n.config.ApplyTimeout).Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		return ErrNotLeader
+	}
+	return err
+}
+
+// Leave 将节点移出集群, 只能在 leader 上调用
+func (n *Node) Leave(nodeID string) error {
+	err := n.raft.RemoveServer(raft.ServerID(nodeID), 0, n.config.ApplyTimeout).Error()
+	if errors.Is(err, raft.ErrNotLeader) {
+		return ErrNotLeader
+	}
+	return err
+}
+
+// IsLeader 当前节点是否是 leader
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// Leader 返回 leader 的地址和 id, 没有 leader 时为空
+func (n *Node) Leader() (raft.ServerAddress, string) {
+	addr, id := n.raft.LeaderWithID()
+	return addr, string(id)
+}
+
+// Snapshot 立即生成一个快照, 并截断快照之前的日志
+func (n *Node) Snapshot() error {
+	return n.raft.Snapshot().Error()
+}
+
+// Shutdown 停止节点并关闭 DB
+func (n *Node) Shutdown() error {
+	if err := n.raft.Shutdown().Error(); err != nil {
+		return err
+	}
+	if err := n.fsm.close(); err != nil {
+		return err
+	}
+	return n.logStore.Close()
+}
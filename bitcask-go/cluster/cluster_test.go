@@ -0,0 +1,319 @@
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/utils"
+	"bytes"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCluster struct {
+	dir        string
+	raftConfig *raft.Config
+	nodes      []*Node
+	transports []*raft.InmemTransport
+}
+
+func testRaftConfig() *raft.Config {
+	config := raft.DefaultConfig()
+	config.HeartbeatTimeout = 50 * time.Millisecond
+	config.ElectionTimeout = 50 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.CommitTimeout = 5 * time.Millisecond
+	config.LogOutput = io.Discard
+	return config
+}
+
+func (c *testCluster) startNode(t *testing.T, i int, bootstrap bool) *Node {
+	addr, trans := raft.NewInmemTransport(raft.ServerAddress(fmt.Sprintf("node-%d", i)))
+	for _, other := range c.transports {
+		if other != nil && other.LocalAddr() != addr {
+			other.Connect(addr, trans)
+			trans.Connect(other.LocalAddr(), other)
+		}
+	}
+	node, err := NewNode(Config{
+		NodeID:    fmt.Sprintf("node-%d", i),
+		DirPath:   filepath.Join(c.dir, fmt.Sprintf("node-%d", i)),
+		Transport: trans,
+		Options:   bitcask.DefaultOptions,
+		Raft:      c.raftConfig,
+		Bootstrap: bootstrap,
+	})
+	assert.Nil(t, err)
+	for len(c.nodes) <= i {
+		c.nodes = append(c.nodes, nil)
+		c.transports = append(c.transports, nil)
+	}
+	c.nodes[i], c.transports[i] = node, trans
+	return node
+}
+
+func (c *testCluster) leader(t *testing.T) *Node {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, node := range c.nodes {
+			if node != nil && node.IsLeader() {
+				return node
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+func (c *testCluster) join(t *testing.T, i int) *Node {
+	node := c.startNode(t, i, false)
+	assert.Nil(t, c.leader(t).Join(node.config.NodeID, node.config.Transport.LocalAddr()))
+	return node
+}
+
+func (c *testCluster) shutdown() {
+	for _, node := range c.nodes {
+		if node != nil {
+			_ = node.Shutdown()
+		}
+	}
+	_ = os.RemoveAll(c.dir)
+}
+
+func newTestCluster(t *testing.T, n int) *testCluster {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cluster")
+	c := &testCluster{dir: dir, raftConfig: testRaftConfig()}
+	c.startNode(t, 0, true)
+	c.leader(t)
+	for i := 1; i < n; i++ {
+		c.join(t, i)
+	}
+	return c
+}
+
+// 等待节点的本地状态机中 key 的值为 value, value 为 nil 表示 key 不存在
+func waitLocalValue(t *testing.T, node *Node, key, value []byte) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		val, err := node.LocalGet(key)
+		if value == nil && err == bitcask.ErrKeyNotFound {
+			return
+		}
+		if value != nil && err == nil && string(val) == string(value) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("node %s: key %s is not replicated", node.config.NodeID, key)
+}
+
+func TestCluster_Replication(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.shutdown()
+
+	leader := c.leader(t)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, leader.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, leader.Batch([]Op{
+		{Key: utils.GetTestKey(1), Delete: true},
+		{Key: []byte("batch"), Value: []byte("value")},
+	}))
+
+	// leader 上的读取是线性一致的
+	val, err := leader.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(99), val)
+	_, err = leader.Get(utils.GetTestKey(0))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	for _, node := range c.nodes {
+		waitLocalValue(t, node, utils.GetTestKey(99), utils.GetTestKey(99))
+		waitLocalValue(t, node, []byte("batch"), []byte("value"))
+		waitLocalValue(t, node, utils.GetTestKey(0), nil)
+		waitLocalValue(t, node, utils.GetTestKey(1), nil)
+		if node == leader {
+			continue
+		}
+		// follower 拒绝写入和线性一致读
+		assert.Equal(t, ErrNotLeader, node.Put([]byte("key"), []byte("value")))
+		_, err := node.Get(utils.GetTestKey(99))
+		assert.Equal(t, ErrNotLeader, err)
+		addr, id := node.Leader()
+		assert.Equal(t, leader.config.Transport.LocalAddr(), addr)
+		assert.Equal(t, leader.config.NodeID, id)
+	}
+
+	// 状态机返回的错误
+	assert.Equal(t, bitcask.ErrKeyIsEmpty, leader.Put(nil, []byte("value")))
+}
+
+func TestCluster_Snapshot(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cluster-snapshot")
+	c := &testCluster{dir: dir, raftConfig: testRaftConfig()}
+	defer c.shutdown()
+
+	// 生成快照之后只保留一条日志
+	c.raftConfig.TrailingLogs = 1
+	leader := c.startNode(t, 0, true)
+	c.leader(t)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, leader.Snapshot())
+	for i := 100; i < 110; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	firstIndex, err := leader.logStore.FirstIndex()
+	assert.Nil(t, err)
+	assert.Greater(t, firstIndex, uint64(100))
+
+	// 新加入的节点通过快照同步被截断的日志
+	follower := c.join(t, 1)
+	for i := 0; i < 110; i++ {
+		waitLocalValue(t, follower, utils.GetTestKey(i), utils.GetTestKey(i))
+	}
+}
+
+func TestCluster_Restart(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.shutdown()
+
+	leader := c.leader(t)
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	// 重启一个 follower, 期间写入的数据在重启之后同步
+	var restart int
+	for i, node := range c.nodes {
+		if node != leader {
+			restart = i
+			break
+		}
+	}
+	waitLocalValue(t, c.nodes[restart], utils.GetTestKey(49), utils.GetTestKey(49))
+	assert.Nil(t, c.nodes[restart].Shutdown())
+	c.nodes[restart] = nil
+	for i := 50; i < 100; i++ {
+		assert.Nil(t, leader.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, leader.Put(utils.GetTestKey(0), []byte("new-value")))
+
+	node := c.startNode(t, restart, false)
+	waitLocalValue(t, node, utils.GetTestKey(99), utils.GetTestKey(99))
+	waitLocalValue(t, node, utils.GetTestKey(0), []byte("new-value"))
+}
+
+// 打包一个只包含一个 key 的 DB 作为快照
+func testSnapshot(t *testing.T, opts bitcask.Options, key, value []byte) io.ReadCloser {
+	opts.DirPath = t.TempDir()
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put(key, value))
+	assert.Nil(t, db.Close())
+	buf := new(bytes.Buffer)
+	assert.Nil(t, tarDir(opts.DirPath, buf))
+	return io.NopCloser(buf)
+}
+
+func TestFSM_Restore(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath = filepath.Join(t.TempDir(), "data")
+	f, err := openFSM(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, f.db.Put([]byte("old"), []byte("old")))
+
+	// 新的目录打不开时换回原来的目录, DB 仍然可用
+	encrypted := bitcask.DefaultOptions
+	encrypted.KeyProvider = bitcask.NewStaticKeyProvider(1, map[uint32][]byte{1: bytes.Repeat([]byte("k"), 32)})
+	assert.NotNil(t, f.Restore(testSnapshot(t, encrypted, []byte("new"), []byte("new"))))
+	value, err := f.get([]byte("old"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), value)
+	_, err = os.Stat(opts.DirPath + restoreOldDirSuffix)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, f.Restore(testSnapshot(t, bitcask.DefaultOptions, []byte("new"), []byte("new"))))
+	value, err = f.get([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), value)
+	_, err = f.get([]byte("old"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	_, err = os.Stat(opts.DirPath + restoreOldDirSuffix)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, f.close())
+
+	// 原来的目录已经改名, 新的目录还没有换入时崩溃, 重启时换回原来的目录
+	assert.Nil(t, os.Rename(opts.DirPath, opts.DirPath+restoreOldDirSuffix))
+	f, err = openFSM(opts)
+	assert.Nil(t, err)
+	value, err = f.get([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), value)
+	assert.Nil(t, f.close())
+
+	// 新的目录已经换入时崩溃, 重启时删除原来的目录
+	assert.Nil(t, os.MkdirAll(opts.DirPath+restoreOldDirSuffix, os.ModePerm))
+	f, err = openFSM(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(opts.DirPath + restoreOldDirSuffix)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, f.close())
+}
+
+func TestLogStore(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-raft-log")
+	opts.DirPath = dir
+	defer os.RemoveAll(dir)
+
+	ls, err := openLogStore(opts)
+	assert.Nil(t, err)
+	first, _ := ls.FirstIndex()
+	last, _ := ls.LastIndex()
+	assert.Equal(t, uint64(0), first)
+	assert.Equal(t, uint64(0), last)
+
+	var logs []*raft.Log
+	for i := 1; i <= 10; i++ {
+		logs = append(logs, &raft.Log{
+			Index:      uint64(i),
+			Term:       1,
+			Type:       raft.LogCommand,
+			Data:       []byte(fmt.Sprintf("data-%d", i)),
+			AppendedAt: time.Unix(0, int64(i)),
+		})
+	}
+	assert.Nil(t, ls.StoreLogs(logs))
+	var log raft.Log
+	assert.Nil(t, ls.GetLog(5, &log))
+	assert.Equal(t, *logs[4], log)
+	assert.Equal(t, raft.ErrLogNotFound, ls.GetLog(11, &log))
+
+	assert.Nil(t, ls.DeleteRange(1, 3))
+	assert.Equal(t, raft.ErrLogNotFound, ls.GetLog(3, &log))
+
+	assert.Nil(t, ls.SetUint64([]byte("term"), 3))
+	_, err = ls.Get([]byte("not-exist"))
+	assert.NotNil(t, err)
+	assert.Nil(t, ls.Close())
+
+	// 重新打开之后恢复日志范围和元数据
+	ls, err = openLogStore(opts)
+	assert.Nil(t, err)
+	defer ls.Close()
+	first, _ = ls.FirstIndex()
+	last, _ = ls.LastIndex()
+	assert.Equal(t, uint64(4), first)
+	assert.Equal(t, uint64(10), last)
+	term, err := ls.GetUint64([]byte("term"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), term)
+}
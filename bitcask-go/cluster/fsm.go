@@ -0,0 +1,307 @@
+package cluster
+
+import (
+	"archive/tar"
+	bitcask "bitcask-go"
+	"encoding/binary"
+	"errors"
+	"github.com/hashicorp/raft"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var errInvalidCommand = errors.New("invalid raft command")
+
+// 从快照恢复时原来的数据目录先改名为这个后缀, 新的 DB 打开之后才删除
+const restoreOldDirSuffix = ".restore-old"
+
+// Op 一次写入操作
+type Op struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// 命令编码: op 数量 | (是否删除 | key size | key | value size | value)...
+func encodeCommand(ops []Op) []byte {
+	var buf []byte
+	buf = binary.AppendUvarint(buf, uint64(len(ops)))
+	for _, op := range ops {
+		var typ byte
+		if op.Delete {
+			typ = 1
+		}
+		buf = append(buf, typ)
+		buf = binary.AppendUvarint(buf, uint64(len(op.Key)))
+		buf = append(buf, op.Key...)
+		buf = binary.AppendUvarint(buf, uint64(len(op.Value)))
+		buf = append(buf, op.Value...)
+	}
+	return buf
+}
+
+func decodeCommand(buf []byte) ([]Op, error) {
+	readBytes := func() ([]byte, error) {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < size {
+			return nil, errInvalidCommand
+		}
+		b := buf[n : n+int(size)]
+		buf = buf[n+int(size):]
+		return b, nil
+	}
+
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, errInvalidCommand
+	}
+	buf = buf[n:]
+	ops := make([]Op, 0, count)
+	for i := uint64(0); i < count; i++ {
+		if len(buf) == 0 {
+			return nil, errInvalidCommand
+		}
+		op := Op{Delete: buf[0] == 1}
+		buf = buf[1:]
+		var err error
+		if op.Key, err = readBytes(); err != nil {
+			return nil, err
+		}
+		if op.Value, err = readBytes(); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// fsm 将 DB 作为 raft 的状态机
+// DB 本身是持久化的, 重启时不从快照恢复, raft 重放快照之后的日志, 重放的写入是幂等的
+type fsm struct {
+	lock    *sync.RWMutex // 从快照恢复时需要替换 DB
+	db      *bitcask.DB
+	options bitcask.Options
+}
+
+func openFSM(options bitcask.Options) (*fsm, error) {
+	if err := recoverRestore(options.DirPath); err != nil {
+		return nil, err
+	}
+	db, err := bitcask.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	return &fsm{lock: &sync.RWMutex{}, db: db, options: options}, nil
+}
+
+func (f *fsm) Apply(log *raft.Log) interface{} {
+	if log.Type != raft.LogCommand {
+		return nil
+	}
+	ops, err := decodeCommand(log.Data)
+	if err != nil {
+		return err
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if len(ops) == 1 {
+		if ops[0].Delete {
+			return f.db.Delete(ops[0].Key)
+		}
+		return f.db.Put(ops[0].Key, ops[0].Value)
+	}
+
+	wb := f.db.NewWriteBatch(bitcask.WriteBatchOptions{MaxBatchNum: uint(len(ops)), SyncWrites: f.options.SyncWrites})
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			err = wb.Delete(op.Key)
+		} else {
+			err = wb.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return wb.Commit()
+}
+
+// Snapshot 使用 DB.Backup 拷贝一份当前的数据, 之后在 Persist 中写入快照, 不阻塞后续的写入
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(f.options.DirPath), "snapshot-")
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if err := f.db.Backup(dir); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	return &fsmSnapshot{dir: dir}, nil
+}
+
+// Restore 使用快照中的数据替换当前的 DB
+func (f *fsm) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	dir, err := os.MkdirTemp(filepath.Dir(f.options.DirPath), "restore-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	if err := untarDir(snapshot, dir); err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err := f.db.Close(); err != nil {
+		return err
+	}
+	// 先把原来的目录改名保留, 任何一步失败都换回原来的目录, 中途崩溃时由 recoverRestore 处理
+	oldDir := f.options.DirPath + restoreOldDirSuffix
+	if err := os.RemoveAll(oldDir); err != nil {
+		return f.rollbackRestore(oldDir, err)
+	}
+	if err := os.Rename(f.options.DirPath, oldDir); err != nil {
+		return f.rollbackRestore(oldDir, err)
+	}
+	if err := os.Rename(dir, f.options.DirPath); err != nil {
+		return f.rollbackRestore(oldDir, err)
+	}
+	db, err := bitcask.Open(f.options)
+	if err != nil {
+		return f.rollbackRestore(oldDir, err)
+	}
+	f.db = db
+	return os.RemoveAll(oldDir)
+}
+
+// 恢复失败时删除换入的目录, 换回原来的目录并重新打开, 保证之后的读写使用的 DB 是打开的
+func (f *fsm) rollbackRestore(oldDir string, err error) error {
+	if _, statErr := os.Stat(oldDir); statErr == nil {
+		if rmErr := os.RemoveAll(f.options.DirPath); rmErr != nil {
+			return errors.Join(err, rmErr)
+		}
+		if renameErr := os.Rename(oldDir, f.options.DirPath); renameErr != nil {
+			return errors.Join(err, renameErr)
+		}
+	}
+	db, openErr := bitcask.Open(f.options)
+	if openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	f.db = db
+	return err
+}
+
+// 处理从快照恢复的过程中崩溃留下的目录
+// 数据目录不存在时新的目录还没有换入, 换回原来的目录; 否则新的目录已经完整换入, 删除原来的目录
+func recoverRestore(dirPath string) error {
+	oldDir := dirPath + restoreOldDirSuffix
+	if _, err := os.Stat(oldDir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return os.Rename(oldDir, dirPath)
+	} else if err != nil {
+		return err
+	}
+	return os.RemoveAll(oldDir)
+}
+
+func (f *fsm) get(key []byte) ([]byte, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.db.Get(key)
+}
+
+func (f *fsm) close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.db.Close()
+}
+
+type fsmSnapshot struct {
+	dir string // DB 备份的目录
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := tarDir(s.dir, sink); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {
+	_ = os.RemoveAll(s.dir)
+}
+
+// 将目录中的文件打包写入 w
+func tarDir(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		file, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, file)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// 将 tarDir 打包的文件解压到目录中
+func untarDir(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(header.Name)
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		_ = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
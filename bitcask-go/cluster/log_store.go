@@ -0,0 +1,237 @@
+package cluster
+
+import (
+	bitcask "bitcask-go"
+	"encoding/binary"
+	"errors"
+	"github.com/hashicorp/raft"
+	"sync"
+	"time"
+)
+
+var (
+	logKeyPrefix    = []byte("l")
+	stableKeyPrefix = []byte("s")
+
+	// raft 通过错误信息判断 key 是否存在
+	errStableKeyNotFound = errors.New("not found")
+	errInvalidLogEntry   = errors.New("invalid raft log entry")
+)
+
+// 单个 WriteBatch 删除日志的最大条数
+const deleteBatchSize = 1024
+
+// logStore 使用 bitcask 存储 raft 日志和元数据, 实现 raft.LogStore 和 raft.StableStore
+type logStore struct {
+	db        *bitcask.DB
+	lock      *sync.RWMutex
+	lowIndex  uint64 // 第一条日志的索引
+	highIndex uint64 // 最后一条日志的索引
+}
+
+func openLogStore(options bitcask.Options) (*logStore, error) {
+	db, err := bitcask.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	ls := &logStore{db: db, lock: &sync.RWMutex{}}
+
+	// 加载第一条和最后一条日志的索引
+	for _, reverse := range []bool{false, true} {
+		iter := db.NewIterator(bitcask.IteratorOptions{Prefix: logKeyPrefix, Reverse: reverse})
+		iter.Rewind()
+		if iter.Valid() {
+			if reverse {
+				ls.highIndex = decodeLogKey(iter.Key())
+			} else {
+				ls.lowIndex = decodeLogKey(iter.Key())
+			}
+		}
+		iter.Close()
+	}
+	return ls, nil
+}
+
+func logKey(index uint64) []byte {
+	key := make([]byte, len(logKeyPrefix)+8)
+	copy(key, logKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(logKeyPrefix):], index)
+	return key
+}
+
+func decodeLogKey(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[len(logKeyPrefix):])
+}
+
+func stableKey(key []byte) []byte {
+	return append(append([]byte{}, stableKeyPrefix...), key...)
+}
+
+// 日志编码: index | term | type | appendedAt | data size | data | extensions size | extensions
+func encodeLog(log *raft.Log) []byte {
+	buf := make([]byte, 8+8+1+8, 8+8+1+8+2*binary.MaxVarintLen64+len(log.Data)+len(log.Extensions))
+	binary.BigEndian.PutUint64(buf[0:8], log.Index)
+	binary.BigEndian.PutUint64(buf[8:16], log.Term)
+	buf[16] = byte(log.Type)
+	binary.BigEndian.PutUint64(buf[17:25], uint64(log.AppendedAt.UnixNano()))
+	buf = binary.AppendUvarint(buf, uint64(len(log.Data)))
+	buf = append(buf, log.Data...)
+	buf = binary.AppendUvarint(buf, uint64(len(log.Extensions)))
+	buf = append(buf, log.Extensions...)
+	return buf
+}
+
+func decodeLog(buf []byte, log *raft.Log) error {
+	if len(buf) < 25 {
+		return errInvalidLogEntry
+	}
+	log.Index = binary.BigEndian.Uint64(buf[0:8])
+	log.Term = binary.BigEndian.Uint64(buf[8:16])
+	log.Type = raft.LogType(buf[16])
+	log.AppendedAt = time.Unix(0, int64(binary.BigEndian.Uint64(buf[17:25])))
+	buf = buf[25:]
+
+	readBytes := func() ([]byte, error) {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < size {
+			return nil, errInvalidLogEntry
+		}
+		var b []byte
+		if size > 0 {
+			b = buf[n : n+int(size)]
+		}
+		buf = buf[n+int(size):]
+		return b, nil
+	}
+	var err error
+	if log.Data, err = readBytes(); err != nil {
+		return err
+	}
+	log.Extensions, err = readBytes()
+	return err
+}
+
+func (ls *logStore) FirstIndex() (uint64, error) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+	return ls.lowIndex, nil
+}
+
+func (ls *logStore) LastIndex() (uint64, error) {
+	ls.lock.RLock()
+	defer ls.lock.RUnlock()
+	return ls.highIndex, nil
+}
+
+func (ls *logStore) GetLog(index uint64, log *raft.Log) error {
+	value, err := ls.db.Get(logKey(index))
+	if err == bitcask.ErrKeyNotFound {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return decodeLog(value, log)
+}
+
+func (ls *logStore) StoreLog(log *raft.Log) error {
+	return ls.StoreLogs([]*raft.Log{log})
+}
+
+func (ls *logStore) StoreLogs(logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	wb := ls.db.NewWriteBatch(bitcask.WriteBatchOptions{MaxBatchNum: uint(len(logs)), SyncWrites: true})
+	for _, log := range logs {
+		if err := wb.Put(logKey(log.Index), encodeLog(log)); err != nil {
+			return err
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return err
+	}
+
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	for _, log := range logs {
+		if ls.lowIndex == 0 || log.Index < ls.lowIndex {
+			ls.lowIndex = log.Index
+		}
+		if log.Index > ls.highIndex {
+			ls.highIndex = log.Index
+		}
+	}
+	return nil
+}
+
+func (ls *logStore) DeleteRange(min, max uint64) error {
+	opts := bitcask.WriteBatchOptions{MaxBatchNum: deleteBatchSize, SyncWrites: false}
+	for start := min; start <= max; start += deleteBatchSize {
+		wb := ls.db.NewWriteBatch(opts)
+		for index := start; index <= max && index < start+deleteBatchSize; index++ {
+			if err := wb.Delete(logKey(index)); err != nil {
+				return err
+			}
+		}
+		if err := wb.Commit(); err != nil {
+			return err
+		}
+		// 避免 max 为最大值时溢出
+		if start+deleteBatchSize < start {
+			break
+		}
+	}
+	if err := ls.db.Sync(); err != nil {
+		return err
+	}
+
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	if min <= ls.lowIndex {
+		ls.lowIndex = max + 1
+	}
+	if max >= ls.highIndex {
+		ls.highIndex = min - 1
+	}
+	if ls.lowIndex > ls.highIndex {
+		ls.lowIndex, ls.highIndex = 0, 0
+	}
+	return nil
+}
+
+func (ls *logStore) Set(key []byte, val []byte) error {
+	if err := ls.db.Put(stableKey(key), val); err != nil {
+		return err
+	}
+	return ls.db.Sync()
+}
+
+func (ls *logStore) Get(key []byte) ([]byte, error) {
+	value, err := ls.db.Get(stableKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return nil, errStableKeyNotFound
+	}
+	return value, err
+}
+
+func (ls *logStore) SetUint64(key []byte, val uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, val)
+	return ls.Set(key, buf)
+}
+
+func (ls *logStore) GetUint64(key []byte) (uint64, error) {
+	value, err := ls.db.Get(stableKey(key))
+	if err == bitcask.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+func (ls *logStore) Close() error {
+	return ls.db.Close()
+}
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrDecryptFailed      = errors.New("failed to decrypt log record, the key may be wrong")
+	ErrMissingKeyProvider = errors.New("log record is encrypted but no key provider is set")
+	ErrKeyNotProvided     = errors.New("encryption key is not provided")
+)
+
+const (
+	keyIdSize = 4
+	nonceSize = 12
+)
+
+// KeyProvider 提供加密数据使用的主密钥, 每个密钥通过 id 区分
+type KeyProvider interface {
+	// CurrentKey 返回加密新数据使用的密钥 id 和密钥
+	CurrentKey() (uint32, []byte, error)
+	// Key 返回 id 对应的密钥, 用于解密旧的数据
+	Key(id uint32) ([]byte, error)
+}
+
+// StaticKeyProvider 使用固定的一组密钥, 轮换密钥时加入新的密钥并修改当前密钥 id
+type StaticKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func NewStaticKeyProvider(current uint32, keys map[uint32][]byte) *StaticKeyProvider {
+	return &StaticKeyProvider{current: current, keys: keys}
+}
+
+func (p *StaticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	key, err := p.Key(p.current)
+	return p.current, key, err
+}
+
+func (p *StaticKeyProvider) Key(id uint32) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok || len(key) == 0 {
+		return nil, ErrKeyNotProvided
+	}
+	return key, nil
+}
+
+// Cipher 使用 AES-GCM 加密一个文件中的记录
+// 每个文件使用主密钥和文件名派生的密钥, 每条记录使用随机的 nonce
+//
+// 加密之后的记录也是一条 LogRecord, 类型为 LogRecordEncrypted
+// key 为 密钥 id | nonce, value 为加密之后的原始记录
+// 同样长度的原始记录加密之后长度相同, 副本加密之后的写入位置和主节点保持一致
+type Cipher struct {
+	provider KeyProvider
+	fileName string
+	lock     *sync.Mutex
+	aeads    map[uint32]cipher.AEAD // 派生密钥的缓存
+}
+
+func NewCipher(provider KeyProvider, fileName string) *Cipher {
+	return &Cipher{
+		provider: provider,
+		fileName: fileName,
+		lock:     &sync.Mutex{},
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+}
+
+func (c *Cipher) aead(id uint32, key []byte) (cipher.AEAD, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if aead, ok := c.aeads[id]; ok {
+		return aead, nil
+	}
+	if key == nil {
+		var err error
+		if key, err = c.provider.Key(id); err != nil {
+			return nil, err
+		}
+	}
+	// HMAC-SHA256 派生 AES-256 的文件密钥
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("bitcask-go/" + c.fileName))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[id] = aead
+	return aead, nil
+}
+
+// Encrypt 使用当前密钥加密一条编码后的记录, 返回编码后的加密记录
+func (c *Cipher) Encrypt(encRecord []byte) ([]byte, error) {
+	id, key, err := c.provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := c.aead(id, key)
+	if err != nil {
+		return nil, err
+	}
+	envelope := make([]byte, keyIdSize+nonceSize)
+	binary.BigEndian.PutUint32(envelope, id)
+	if _, err := rand.Read(envelope[keyIdSize:]); err != nil {
+		return nil, err
+	}
+	encrypted, _ := EncodeLogRecord(&LogRecord{
+		Key:   envelope,
+		Value: aead.Seal(nil, envelope[keyIdSize:], encRecord, nil),
+		Type:  LogRecordEncrypted,
+	})
+	return encrypted, nil
+}
+
+// Decrypt 解密一条加密记录, 返回原始的记录
+func (c *Cipher) Decrypt(logRecord *LogRecord) (*LogRecord, error) {
+	if len(logRecord.Key) != keyIdSize+nonceSize {
+		return nil, ErrDecryptFailed
+	}
+	aead, err := c.aead(binary.BigEndian.Uint32(logRecord.Key), nil)
+	if err != nil {
+		return nil, err
+	}
+	encRecord, err := aead.Open(nil, logRecord.Key[keyIdSize:], logRecord.Value, nil)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	record, _, err := DecodeLogRecord(encRecord)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return record, nil
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestCipher_EncryptDecrypt(t *testing.T) {
+	provider := NewStaticKeyProvider(1, map[uint32][]byte{1: []byte("secret-key-1")})
+	c := NewCipher(provider, "000000001.data")
+
+	encRecord, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	encrypted, err := c.Encrypt(encRecord)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(encrypted, []byte("bitcask-go")))
+
+	// 同样长度的记录加密之后长度相同, nonce 不同
+	encrypted2, err := c.Encrypt(encRecord)
+	assert.Nil(t, err)
+	assert.Equal(t, len(encrypted), len(encrypted2))
+	assert.NotEqual(t, encrypted, encrypted2)
+	assert.Greater(t, int64(len(encrypted)), size)
+
+	outer, _, err := DecodeLogRecord(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordEncrypted, outer.Type)
+	record, err := c.Decrypt(outer)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("name"), record.Key)
+	assert.Equal(t, []byte("bitcask-go"), record.Value)
+
+	// 其它文件派生的密钥不同
+	_, err = NewCipher(provider, "000000002.data").Decrypt(outer)
+	assert.Equal(t, ErrDecryptFailed, err)
+	// 密钥不存在
+	_, err = NewCipher(NewStaticKeyProvider(2, map[uint32][]byte{2: []byte("k")}), "000000001.data").Decrypt(outer)
+	assert.Equal(t, ErrKeyNotProvided, err)
+}
+
+func TestDataFile_Encrypted(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-cipher")
+	defer os.RemoveAll(dir)
+	provider := NewStaticKeyProvider(1, map[uint32][]byte{1: []byte("secret-key-1")})
+
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFileIO)
+	assert.Nil(t, err)
+	dataFile.SetKeyProvider(provider)
+	rec1, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	rec2, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Type: LogRecordTypeDeleted})
+	assert.Nil(t, dataFile.Write(rec1))
+	offset := dataFile.WriteOffset
+	assert.Nil(t, dataFile.Write(rec2))
+
+	record, size, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, offset, size)
+	assert.Equal(t, []byte("bitcask-go"), record.Value)
+	record, size, err = dataFile.ReadLogRecord(offset)
+	assert.Nil(t, err)
+	assert.Equal(t, dataFile.WriteOffset-offset, size)
+	assert.Equal(t, LogRecordTypeDeleted, record.Type)
+	assert.Nil(t, dataFile.Close())
+
+	content, err := os.ReadFile(GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, []byte("bitcask-go")))
+
+	// 没有设置密钥时不能读取加密的记录
+	dataFile, err = OpenDataFile(dir, 0, fio.StandardFileIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrMissingKeyProvider, err)
+}
@@ -18,12 +18,15 @@ const (
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	BloomFilterFileName   = "bloom-filter"
 )
 
 type DataFile struct {
 	FileId      uint32        // 文件ID
 	WriteOffset int64         // 文件写到了哪个位置
 	IoManager   fio.IOManager // io 管理
+	name        string        // 文件名, 用于派生加密密钥
+	cipher      *Cipher       // 为空时不加密
 }
 
 // OpenDataFile 打开数据文件
@@ -42,7 +45,7 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	if err != nil {
 		return nil, err
 	}
-	return &DataFile{FileId: fileId, WriteOffset: 0, IoManager: ioManager}, nil
+	return &DataFile{FileId: fileId, WriteOffset: 0, IoManager: ioManager, name: filepath.Base(fileName)}, nil
 }
 
 // OpenHintFile 打开 hint 索引文件
@@ -51,6 +54,11 @@ func OpenHintFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFileIO)
 }
 
+// OpenReadOnlyFile 只读打开数据目录中已经存在的 hint 文件, merge 完成标识等文件
+func OpenReadOnlyFile(dirPath string, fileName string) (*DataFile, error) {
+	return newDataFile(filepath.Join(dirPath, fileName), 0, fio.ReadOnlyFileIO)
+}
+
 func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
 	return newDataFile(fileName, 0, fio.StandardFileIO)
@@ -61,6 +69,12 @@ func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFileIO)
 }
 
+// OpenBloomFilterFile 打开布隆过滤器文件
+func OpenBloomFilterFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, BloomFilterFileName)
+	return newDataFile(fileName, 0, fio.StandardFileIO)
+}
+
 func (df *DataFile) Sync() error {
 
 	return df.IoManager.Sync()
@@ -77,7 +91,35 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	return df.Write(encRecord)
 }
 
+// WriteNamespaceHintRecord 写入命名空间中数据的索引信息, key 为 命名空间 id | key
+func (df *DataFile) WriteNamespaceHintRecord(nsKey []byte, pos *LogRecordPos) error {
+	record := &LogRecord{
+		Key:   nsKey,
+		Value: EncodeLogRecordPos(pos),
+		Type:  LogRecordNamespacePut,
+	}
+	encRecord, _ := EncodeLogRecord(record)
+
+	return df.Write(encRecord)
+}
+
+// SetKeyProvider 设置加密使用的密钥, 之后写入的记录都会被加密, 为空时不加密
+func (df *DataFile) SetKeyProvider(provider KeyProvider) {
+	if provider == nil {
+		df.cipher = nil
+		return
+	}
+	df.cipher = NewCipher(provider, df.name)
+}
+
+// Write 写入一条编码后的记录, 设置了密钥时先加密
 func (df *DataFile) Write(buf []byte) error {
+	if df.cipher != nil {
+		var err error
+		if buf, err = df.cipher.Encrypt(buf); err != nil {
+			return err
+		}
+	}
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
 		return err
@@ -135,6 +177,15 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if crc != header.crc {
 		return nil, 0, ErrInvalidCRC
 	}
+	// 返回解密之后的记录, 长度仍然是加密记录在文件中的长度
+	if logRecord.Type == LogRecordEncrypted {
+		if df.cipher == nil {
+			return nil, 0, ErrMissingKeyProvider
+		}
+		if logRecord, err = df.cipher.Decrypt(logRecord); err != nil {
+			return nil, 0, err
+		}
+	}
 	return logRecord, recordSize, nil
 }
 
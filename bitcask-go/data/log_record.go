@@ -11,6 +11,17 @@ const (
 	LogRecordTypeNormal LogRecordType = iota
 	LogRecordTypeDeleted
 	LogRecordTxnFinished
+	LogRecordTimestamp // 归档模式下定期写入的时间戳, 用于按时间点恢复
+	LogRecordEncrypted // 加密之后的记录, 读取时解密出原始的记录
+
+	// 命名空间中的数据, key 为 命名空间 id | key
+	LogRecordNamespacePut
+	LogRecordNamespaceDelete
+	// 创建和删除命名空间, key 为命名空间的名称
+	LogRecordNamespaceCreate
+	LogRecordNamespaceDrop
+	// 范围删除, key 为起始的 key, value 为结束的 key, 为空时表示没有上界
+	LogRecordRangeDelete
 )
 
 // crc type keySize valueSize
@@ -120,3 +131,26 @@ func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 
 	return crc
 }
+
+// DecodeLogRecord 从完整编码的字节数组中解码 LogRecord, 返回 LogRecord 和编码长度
+func DecodeLogRecord(buf []byte) (*LogRecord, int64, error) {
+	header, headerSize := decodeLogRecordHeader(buf)
+	if header == nil {
+		return nil, 0, ErrInvalidCRC
+	}
+	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
+	recordSize := headerSize + keySize + valueSize
+	if recordSize > int64(len(buf)) {
+		return nil, 0, ErrInvalidCRC
+	}
+
+	logRecord := &LogRecord{Type: header.recordType}
+	if keySize > 0 || valueSize > 0 {
+		logRecord.Key = buf[headerSize : headerSize+keySize]
+		logRecord.Value = buf[headerSize+keySize : recordSize]
+	}
+	if getLogRecordCRC(logRecord, buf[crc32.Size:headerSize]) != header.crc {
+		return nil, 0, ErrInvalidCRC
+	}
+	return logRecord, recordSize, nil
+}
@@ -98,3 +98,25 @@ func TestGetLogRecord(t *testing.T) {
 	crc = getLogRecordCRC(rec3, headBuf3[crc32.Size:])
 	assert.Equal(t, uint32(290887979), crc)
 }
+
+func TestDecodeLogRecordBytes(t *testing.T) {
+	rec := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcask-go"),
+		Type:  LogRecordTypeNormal,
+	}
+	buf, size := EncodeLogRecord(rec)
+	decoded, n, err := DecodeLogRecord(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, size, n)
+	assert.Equal(t, rec, decoded)
+
+	// 数据被截断
+	_, _, err = DecodeLogRecord(buf[:len(buf)-1])
+	assert.Equal(t, ErrInvalidCRC, err)
+
+	// 数据被篡改
+	buf[len(buf)-1]++
+	_, _, err = DecodeLogRecord(buf)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
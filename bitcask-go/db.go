@@ -1,142 +1,279 @@
 package bitcask_go
 
 import (
+	"bitcask-go/bloom"
+	"bitcask-go/cache"
 	"bitcask-go/data"
 	"bitcask-go/fio"
 	"bitcask-go/index"
-	"bitcask-go/utils"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	seqNoKey      = "seq.no"
 	fileFlockName = "flock"
+	// 启动时重放数据文件, 每次批量更新索引的最大条数
+	replayBatchSize = 1024
 )
 
 type DB struct {
-	options     Options
-	mu          *sync.RWMutex
-	fileIds     []int                     // 文件 di, 只在加载索引的时候使用
-	activeFile  *data.DataFile            // 当前活跃的数据文件，可以写入
-	oldFiles    map[uint32]*data.DataFile // 旧的数据文件，只能用于读
-	index       index.Indexer             // 内存索引
-	seqNo       uint64                    // 事务序列号, 全局递增
-	isMerging   bool                      // 是不是在merge
-	seqNoExists bool                      // 标识存储事务序列号的文件是否存在
-	isInitial   bool                      // 标识第一次初始化数据目录
-	fileLock    *flock.Flock              // 文件锁
-	bytesWrite  uint                      // 累计写了多少个字节
-	reclaimSize int64                     // 有多少无效数据
+	options         Options
+	mu              *sync.RWMutex
+	fileIds         []int                     // 文件 di, 只在加载索引的时候使用
+	activeFile      *data.DataFile            // 当前活跃的数据文件，可以写入
+	oldFiles        map[uint32]*data.DataFile // 旧的数据文件，只能用于读
+	index           index.Indexer             // 内存索引
+	seqNo           uint64                    // 事务序列号, 全局递增
+	isMerging       bool                      // 是不是在merge
+	mergedFileId    uint32                    // 已应用到持久化索引中的 merge 对应的 nonMergeFileId
+	fileLock        *flock.Flock              // 文件锁
+	bytesWrite      uint                      // 累计写了多少个字节
+	reclaimSize     int64                     // 有多少无效数据
+	filter          *bloom.Filter             // 布隆过滤器, 用于快速判断 key 不存在
+	filterCovered   bool                      // 启动时加载的布隆过滤器已经包含数据文件中的全部 key, 重放时不需要加入
+	valueCache      *cache.LRUCache           // 热点数据的 value 缓存
+	notifyLock      *sync.Mutex
+	appendNotify    chan struct{}                        // 有新数据写入时关闭, 用于唤醒复制连接
+	replicator      *replicator                          // 副本模式下从主节点同步数据
+	replicaTxns     map[uint64][]*data.TransactionRecord // 副本上还没有同步到, 或者只读模式下还没有读到完成标识的事务
+	watchLock       *sync.RWMutex
+	watchers        map[*watcher]struct{} // key 变更的订阅
+	watcherNum      int32                 // 订阅数量, 没有订阅时写入不需要加锁
+	watchClosed     chan struct{}         // 关闭数据库时关闭
+	commitSeq       uint64                // 提交序号
+	publishTicket   uint64                // 最近一次分配的事件发送序号
+	publishedTicket uint64                // 已经发送完成的事件发送序号
+	publishLock     *sync.Mutex
+	publishCond     *sync.Cond            // 按照提交的顺序在释放 db.mu 之后发送事件
+	droppedEvents   uint64                // 订阅的缓冲区已满而丢弃的事件数量
+	lastMarkTime    time.Time             // 最近一次写入时间戳的时间
+	namespaces      map[string]*Namespace // 名称到命名空间
+	namespaceIds    map[uint32]*Namespace // 数据记录中的命名空间 id 到命名空间
+	nextNamespaceId uint32                // 下一个创建的命名空间的 id, 0 表示默认命名空间
+	metrics         *metrics              // 操作的计数和延迟统计
+	disk            diskMonitor           // 磁盘可用空间的检查状态
+	mergeFinishedAt time.Time             // 只读模式下加载时 merge 完成标识的修改时间, 用于发现数据文件被替换
+	eventQueue      *eventQueue           // 异步通知 EventListener 的队列, 同步通知时为空
+	logger          *slog.Logger
 }
 
 type Stat struct {
 	KeyNum          uint
 	DataFileNum     uint
 	ReclaimableSize int64
-	DiskSize        int64
+	DiskSize        int64 // 只统计数据文件的总大小, 不包含 hint 文件, 持久化索引和布隆过滤器等文件
 }
 
 // Open 打开 bitcask 存储引擎实例
-func Open(options Options) (*DB, error) {
+func Open(options Options) (_ *DB, err error) {
 	// 检查配置选项
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
-	var isInitial bool
-	// 如果文件不存在，则创建文件
-	_, err := os.Stat(options.DirPath)
-	if os.IsNotExist(err) {
-		isInitial = true
+	start := time.Now()
+	logger := options.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	// 如果文件不存在，则创建文件, 只读模式下目录必须存在
+	_, err = os.Stat(options.DirPath)
+	if os.IsNotExist(err) && !options.ReadOnly {
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
+	} else if err != nil {
+		return nil, err
 	}
 
-	// 判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileFlockName))
-	hold, err := fileLock.TryLock()
-	if err != nil {
-		return nil, err
+	// 判断当前数据目录是否正在使用, 只读模式不获取文件锁, 不影响写入的进程
+	var fileLock *flock.Flock
+	if !options.ReadOnly {
+		fileLock = flock.New(filepath.Join(options.DirPath, fileFlockName))
+		var hold bool
+		// 不能用 := 声明新的 err, 下面的 defer 需要读取返回的错误
+		if hold, err = fileLock.TryLock(); err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
+		// 打开失败时释放文件锁
+		defer func() {
+			if err != nil {
+				_ = fileLock.Unlock()
+			}
+		}()
 	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
+
+	// 初始化索引, 优先使用自定义的索引
+	var indexer index.Indexer
+	if options.CustomIndexer != "" {
+		indexer, err = index.NewCustomIndexer(options.CustomIndexer, options.DirPath, options.SyncWrites)
+	} else {
+		indexer, err = index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites)
 	}
-	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
 	}
-	if len(entries) == 0 {
-		isInitial = true
+	defer func() {
+		if err != nil {
+			_ = indexer.Close()
+		}
+	}()
+	if l, ok := indexer.(interface{ SetLogger(*slog.Logger) }); ok {
+		l.SetLogger(logger)
+	}
+	if _, ok := indexer.(index.PersistentIndexer); ok {
+		if options.ReadOnly {
+			return nil, errors.New("read-only mode only supports in-memory indexes")
+		}
+		if options.KeyProvider != nil {
+			return nil, errors.New("encryption only supports in-memory indexes")
+		}
 	}
 
 	// 初始化 DB 实例
 	db := &DB{
-		options:   options,
-		mu:        new(sync.RWMutex),
-		oldFiles:  make(map[uint32]*data.DataFile),
-		index:     index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
-		isInitial: isInitial,
-		fileLock:  fileLock,
+		options:     options,
+		mu:          new(sync.RWMutex),
+		oldFiles:    make(map[uint32]*data.DataFile),
+		index:       indexer,
+		fileLock:    fileLock,
+		notifyLock:  &sync.Mutex{},
+		watchLock:   &sync.RWMutex{},
+		watchers:    make(map[*watcher]struct{}),
+		watchClosed: make(chan struct{}),
+		publishLock: &sync.Mutex{},
+
+		namespaces:      make(map[string]*Namespace),
+		namespaceIds:    make(map[uint32]*Namespace),
+		nextNamespaceId: 1,
+		metrics:         newMetrics(),
+		logger:          logger,
+	}
+	db.publishCond = sync.NewCond(db.publishLock)
+	if options.EventListener != nil && options.EventQueueSize > 0 {
+		db.eventQueue = newEventQueue(options.EventQueueSize)
+		defer func() {
+			if err != nil {
+				db.eventQueue.close()
+			}
+		}()
+	}
+	if options.ValueCacheSize > 0 {
+		db.valueCache = cache.NewLRUCache(options.ValueCacheSize)
+	}
+	if db.isReplica() || options.ReadOnly {
+		db.replicaTxns = make(map[uint64][]*data.TransactionRecord)
+	}
+	if options.ArchiveDir != "" {
+		if err := os.MkdirAll(options.ArchiveDir, os.ModePerm); err != nil {
+			return nil, err
+		}
 	}
 
-	// 加载 merge 数据目录
-	if err := db.loadMergeFiles(); err != nil {
+	// 正常关闭时保存的布隆过滤器包含全部的 key, merge 生成的过滤器只包含 merge 时的有效 key
+	// 数据目录中已经有过滤器时 merge 的过滤器不会覆盖它
+	_, err = os.Stat(filepath.Join(options.DirPath, data.BloomFilterFileName))
+	filterCovered := err == nil
+	// 加载 merge 数据目录, 只读模式下由写入的进程在启动时应用 merge 的结果
+	if options.ReadOnly {
+		if db.mergeFinishedAt, err = mergeFinishedTime(options.DirPath); err != nil {
+			return nil, err
+		}
+	} else if err := db.loadMergeFiles(); err != nil {
 		return nil, err
 	}
 	// 加载对应的数据文件
 	if err := db.loadDataFile(); err != nil {
 		return nil, err
 	}
+	// 加载布隆过滤器, 包含全部 key 时重放数据文件不需要再加入过滤器
+	filterLoaded, err := db.loadBloomFilter()
+	if err != nil {
+		return nil, err
+	}
+	db.filterCovered = filterLoaded && filterCovered
 
-	if options.IndexType != BPlusTree {
-		// 从 hint 索引中加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
+	if pi, ok := db.index.(index.PersistentIndexer); ok {
+		// 持久化索引只需要应用 merge 的结果, 并重放检查点之后的数据
+		if err := db.loadMergedHintFile(pi); err != nil {
 			return nil, err
 		}
-		// 从数据文件加载索引
 		if err := db.loadIndexFromDataFile(); err != nil {
 			return nil, err
 		}
-
-		if db.options.MMapAtStartup {
-			err := db.resetIoType()
-			if err != nil {
-				return nil, err
-			}
+		if !filterLoaded {
+			db.rebuildBloomFilter()
+		}
+		// 旧版本使用单独的文件保存事务序列号, 现在已经记录在检查点中
+		if err := os.RemoveAll(filepath.Join(options.DirPath, data.SeqNoFileName)); err != nil {
+			return nil, err
+		}
+	} else {
+		// 从 hint 索引中加载索引, 持久化的布隆过滤器中已经包含了 hint 文件中的 key
+		if err := db.loadIndexFromHintFile(!filterLoaded); err != nil {
+			return nil, err
+		}
+		// 从数据文件加载索引
+		if err := db.loadIndexFromDataFile(); err != nil {
+			return nil, err
 		}
 	}
 
-	if options.IndexType == BPlusTree {
-		if err := db.loadSeqNo(); err != nil {
+	db.filterCovered = false
+
+	// 只读模式不需要切换回可以写入的 IO
+	if db.options.MMapAtStartup && !db.options.ReadOnly {
+		err := db.resetIoType()
+		if err != nil {
 			return nil, err
 		}
-		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
-			}
-			db.activeFile.WriteOffset = size
-		}
 	}
 
+	// 副本模式下启动同步协程
+	if db.isReplica() {
+		db.replicator = newReplicator(db, options.ReplicaOf)
+		go db.replicator.run()
+	}
+
+	db.mu.RLock()
+	dataFiles, _ := db.dataFileStat()
+	db.mu.RUnlock()
+	logger.Info("bitcask opened", "dir", options.DirPath, "keys", db.index.Size(),
+		"data_files", dataFiles, "duration", time.Since(start))
 	return db, nil
 }
 
 // Put 写入key value 数据，key不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.PutContext(context.Background(), key, value)
+}
+
+// PutContext 和 Put 相同, ctx 已经取消时不写入
+func (db *DB) PutContext(ctx context.Context, key []byte, value []byte) (err error) {
+	defer db.observe(OpPut, time.Now(), &err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	// 判断key是否有效
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
 
 	// 构造 LogRecord
 	logRecord := &data.LogRecord{
@@ -144,23 +281,49 @@ func (db *DB) Put(key []byte, value []byte) error {
 		Value: value,
 		Type:  data.LogRecordTypeNormal,
 	}
-
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	// 写入数据和更新索引在同一把锁内完成, 保证索引的更新顺序和数据写入的顺序一致
+	// 变更事件在释放锁之后发送
+	var pub *publication
+	defer func() { pub.deliver() }()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	if err := db.applyToIndex([]*index.BatchEntry{{Key: key, Pos: pos}}, pos); err != nil {
+		return err
 	}
+	pub = db.publish([]Event{{Type: EventPut, Key: key, Value: value}})
 	return nil
 }
 
 func (db *DB) Delete(key []byte) error {
+	return db.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext 和 Delete 相同, ctx 已经取消时不删除
+func (db *DB) DeleteContext(ctx context.Context, key []byte) (err error) {
+	defer db.observe(OpDelete, time.Now(), &err)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
 
 	// 检查 key 是否存在，如果不存在直接返回
+	if !db.keyMayExist(key) {
+		return nil
+	}
+
+	var pub *publication
+	defer func() { pub.deliver() }()
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	if pos := db.index.Get(key); pos == nil {
 		return nil
 	}
@@ -171,31 +334,41 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordTypeDeleted,
 	}
 
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
-		return nil
+		return err
 	}
 	db.reclaimSize += int64(pos.Size)
 
-	// 从内存索引中将对应的key删除
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
-		return ErrIndexUpdateFailed
-	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	// 从索引中将对应的key删除
+	if err := db.applyToIndex([]*index.BatchEntry{{Key: key}}, pos); err != nil {
+		return err
 	}
+	pub = db.publish([]Event{{Type: EventDelete, Key: key}})
 	return nil
 }
 
 // Get 根据 key 读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
+	return db.GetContext(context.Background(), key)
+}
+
+// GetContext 和 Get 相同, ctx 已经取消时不读取
+func (db *DB) GetContext(ctx context.Context, key []byte) (value []byte, err error) {
+	defer db.observe(OpGet, time.Now(), &err)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	// 布隆过滤器判断 key 一定不存在，无需查询索引
+	if !db.keyMayExist(key) {
+		return nil, ErrKeyNotFound
+	}
 	// 从内存中把数据信息拿出来
 	logRecordPos := db.index.Get(key)
 	// 如果 kye 不在内存索引中，key 不存在
@@ -206,12 +379,28 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	return db.getValueByPosition(logRecordPos)
 }
 
-func (db *DB) Close() error {
+func (db *DB) Close() (err error) {
 	defer func() {
-		if err := db.fileLock.Unlock(); err != nil {
-			panic(fmt.Sprintf("failked to unlock directory: %v", err))
+		// 关闭过程中触发的事件也需要通知到
+		if db.eventQueue != nil {
+			db.eventQueue.close()
+		}
+		// 只读模式没有获取文件锁
+		if db.fileLock != nil {
+			if unlockErr := db.fileLock.Unlock(); unlockErr != nil {
+				db.logger.Error("failed to unlock directory", "dir", db.options.DirPath, "error", unlockErr)
+				err = errors.Join(err, fmt.Errorf("failed to unlock directory: %w", unlockErr))
+			}
+		}
+		if err != nil {
+			db.logger.Error("failed to close bitcask", "dir", db.options.DirPath, "error", err)
 		}
 	}()
+	// 先停止副本的同步, 同步协程会获取 db.mu
+	if db.replicator != nil {
+		db.replicator.close()
+	}
+	db.closeWatchers()
 	if db.activeFile == nil {
 		return nil
 	}
@@ -222,22 +411,11 @@ func (db *DB) Close() error {
 		return err
 	}
 
-	// 保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
-	if err != nil {
-		return nil
-	}
-	record := &data.LogRecord{
-		Key:   []byte(seqNoKey),
-		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
-	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNoFile.Write(encRecord); err != nil {
-		return err
-	}
-
-	if err := seqNoFile.Sync(); err != nil {
-		return err
+	// 保存布隆过滤器, 只读模式下不修改数据目录
+	if !db.options.ReadOnly {
+		if err := db.saveBloomFilter(); err != nil {
+			return err
+		}
 	}
 
 	// 关闭当前活跃文件
@@ -260,29 +438,22 @@ func (db *DB) Sync() error {
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	if err := db.activeFile.Sync(); err != nil {
+	if err := db.syncActiveFile(); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Stat 返回数据库的相关信息
+// Stat 返回数据库的相关信息, 和 Metrics 一样使用打开的数据文件的大小, 不需要遍历数据目录
 func (db *DB) Stat() *Stat {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	var dataFiles = uint(len(db.oldFiles))
-	if db.activeFile != nil {
-		dataFiles++
-	}
-	dirSize, err := utils.DirSize(db.options.DirPath)
-	if err != nil {
-		panic("fail to get dir size" + err.Error())
-	}
+	dataFiles, dataFileSize := db.dataFileStat()
 	return &Stat{
 		KeyNum:          uint(db.index.Size()),
 		DataFileNum:     dataFiles,
 		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize,
+		DiskSize:        dataFileSize,
 	}
 }
 
@@ -304,12 +475,20 @@ func (db *DB) ListKeys() [][]byte {
 
 // Fold 获取所有数据, 并执行用户指定的操作, 函数返回false 退出遍历
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.FoldContext(context.Background(), fn)
+}
+
+// FoldContext 和 Fold 相同, 每条数据之前检查 ctx, 取消时返回 ctx 的错误
+func (db *DB) FoldContext(ctx context.Context, fn func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -323,6 +502,13 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 }
 
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	// 先从缓存中查找
+	if db.valueCache != nil {
+		if value, ok := db.valueCache.Get(cache.KeyOf(logRecordPos)); ok {
+			return value, nil
+		}
+	}
+
 	// 根据文件ID找到对应的数据文件
 	var dataFile *data.DataFile
 	if logRecordPos.Fid == db.activeFile.FileId {
@@ -343,17 +529,17 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	if logRecord.Type == data.LogRecordTypeDeleted {
 		return nil, ErrKeyNotFound
 	}
+	if db.valueCache != nil {
+		db.valueCache.Put(cache.KeyOf(logRecordPos), logRecord.Value)
+	}
 	return logRecord.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
-}
-
 // 追加写数据到活跃文件中
 func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	if err := db.checkDiskSpace(); err != nil {
+		return nil, err
+	}
 	if db.activeFile == nil {
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
@@ -362,7 +548,7 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 
 	encRecord, size := data.EncodeLogRecord(logRecord)
 	if db.activeFile.WriteOffset+size > db.options.DataFileSize {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
 		// 将当前活跃文件转化为一个旧的文件
@@ -374,18 +560,25 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
+	if err := db.markTimestamp(); err != nil {
+		return nil, err
+	}
+
 	writeOff := db.activeFile.WriteOffset
-	if err := db.activeFile.Write(encRecord); err != nil {
+	if err := db.writeActiveFile(encRecord); err != nil {
 		return nil, err
 	}
+	// 加密之后写入的长度和编码的长度不同
+	size = db.activeFile.WriteOffset - writeOff
 	db.bytesWrite += uint(size)
+	db.observeBytesWritten(size)
 	var needSync = db.options.SyncWrites
 	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
 		needSync = true
 	}
 
 	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
 		if db.bytesWrite > 0 {
@@ -393,6 +586,7 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 
 	}
+	db.notifyAppend()
 	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}
 	return pos, nil
 }
@@ -403,22 +597,54 @@ func (db *DB) setActiveDataFile() error {
 
 	if db.activeFile != nil {
 		initialFileId = db.activeFile.FileId + 1
+		// 切换之后原来的活跃文件不会再被修改, 可以归档
+		if err := db.archiveDataFile(db.activeFile); err != nil {
+			return err
+		}
 	}
 
 	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFileIO)
 	if err != nil {
 		return err
 	}
+	dataFile.SetKeyProvider(db.options.KeyProvider)
+	if db.activeFile != nil {
+		db.notifyFileRotated(db.activeFile, dataFile)
+	}
 	db.activeFile = dataFile
 	return nil
 }
 
 // 加载数据文件
 func (db *DB) loadDataFile() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	fileIds, err := listDataFileIds(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	db.fileIds = fileIds
+	// 遍历文件id，打开对应的数据文件
+	for i, fid := range fileIds {
+		dataFile, err := db.openDataFile(uint32(fid), i == len(fileIds)-1)
+		if err != nil {
+			return err
+		}
+		// 最后一个文件表示活跃文件
+		if i == len(fileIds)-1 {
+			db.activeFile = dataFile
+		} else {
+			db.oldFiles[uint32(fid)] = dataFile
+		}
+	}
+
+	return nil
+}
+
+// 获取数据目录中所有数据文件的 id, 从小到大排序
+func listDataFileIds(dirPath string) ([]int, error) {
+	dirEntries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
 
 	var fileIds []int
 	// 遍历目录中的所有文件，找到.data结尾的文件
@@ -428,7 +654,7 @@ func (db *DB) loadDataFile() error {
 			fileId, err := strconv.Atoi(splitNames[0])
 			// 数据目录可能被破坏掉了
 			if err != nil {
-				return ErrDataDirectoryCorrupt
+				return nil, ErrDataDirectoryCorrupt
 			}
 			fileIds = append(fileIds, fileId)
 		}
@@ -436,26 +662,26 @@ func (db *DB) loadDataFile() error {
 
 	// 对文件 id 排序，从小到大加载数据文件
 	sort.Ints(fileIds)
-	db.fileIds = fileIds
-	// 遍历文件id，打开对应的数据文件
-	for i, fid := range fileIds {
-		ioType := fio.StandardFileIO
-		if db.options.MMapAtStartup {
-			ioType = fio.MemoryMap
-		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
-		if err != nil {
-			return err
-		}
-		// 最后一个文件表示活跃文件
-		if i == len(fileIds)-1 {
-			db.activeFile = dataFile
-		} else {
-			db.oldFiles[uint32(fid)] = dataFile
+	return fileIds, nil
+}
+
+// 打开数据文件, 只读模式下写入的进程可能还在追加最后一个文件, 不能使用内存映射
+func (db *DB) openDataFile(fid uint32, last bool) (*data.DataFile, error) {
+	ioType := fio.StandardFileIO
+	if db.options.ReadOnly {
+		ioType = fio.ReadOnlyFileIO
+		if db.options.MMapAtStartup && !last {
+			ioType = fio.ReadOnlyMemoryMap
 		}
+	} else if db.options.MMapAtStartup {
+		ioType = fio.MemoryMap
 	}
-
-	return nil
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fid, ioType)
+	if err != nil {
+		return nil, err
+	}
+	dataFile.SetKeyProvider(db.options.KeyProvider)
+	return dataFile, nil
 }
 
 // 从数据文件加载索引
@@ -468,32 +694,101 @@ func (db *DB) loadIndexFromDataFile() error {
 	hasMerged, nonMergeFileId := false, uint32(0)
 	mergedFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
 	if _, err := os.Stat(mergedFileName); err == nil {
-		fid, err := db.getNonMergeFileId(mergedFileName)
+		fid, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
 		}
 		hasMerged = true
 		nonMergeFileId = fid
 	}
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordTypeDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
-		} else {
-			oldPos = db.index.Put(key, pos)
+
+	// 持久化索引从检查点之后开始重放
+	var startFileId uint32 = 0
+	var startOffset int64 = 0
+	var currentSeqNo = nonTransactionSeqNo
+	if pi, ok := db.index.(index.PersistentIndexer); ok {
+		checkpoint, err := pi.Checkpoint()
+		if err != nil {
+			return err
 		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+		if checkpoint != nil {
+			startFileId, startOffset, currentSeqNo = checkpoint.Fid, checkpoint.Offset, checkpoint.SeqNo
 		}
 	}
+	// merge 过的文件已经通过 hint 文件加载
+	if hasMerged && startFileId < nonMergeFileId {
+		startFileId, startOffset = nonMergeFileId, 0
+	}
 
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
+	offset, activeFileLoaded, err := db.replayDataFiles(db.fileIds, startFileId, startOffset, currentSeqNo, transactionRecords)
+	if err != nil {
+		return err
+	}
+	// 副本继续同步时还会收到未完成事务的剩余数据, 只读模式刷新时也会继续读取
+	if db.isReplica() || db.options.ReadOnly {
+		db.replicaTxns = transactionRecords
+	}
+
+	// 活跃文件 offset 更新
+	if activeFileLoaded {
+		// 只读模式下末尾不完整的记录可能是写入的进程正在写入的数据, 不能截断
+		if !db.options.ReadOnly {
+			if err := db.truncateActiveFile(offset); err != nil {
+				return err
+			}
+		}
+		db.activeFile.WriteOffset = offset
+	} else {
+		size, err := db.activeFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		db.activeFile.WriteOffset = size
+	}
+	return nil
+}
+
+// 按顺序重放数据文件, 从 startFileId 的 startOffset 开始, 没有读到完成标识的事务保留在 transactionRecords 中
+// 返回最后一个文件中完整记录的结束位置, 以及最后一个文件是否被重放
+func (db *DB) replayDataFiles(fileIds []int, startFileId uint32, startOffset int64, currentSeqNo uint64,
+	transactionRecords map[uint64][]*data.TransactionRecord) (int64, bool, error) {
+	// 批量更新索引, lastPos 是最后一条已完整提交的记录的位置
+	var entries []*index.BatchEntry
+	var lastPos *data.LogRecordPos
+	flushEntries := func() error {
+		if len(entries) == 0 {
+			return nil
+		}
+		db.seqNo = currentSeqNo
+		err := db.applyToIndex(entries, lastPos)
+		entries = nil
+		return err
+	}
+	addEntry := func(key []byte, logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+		if ok, err := db.replayNamespaceRecord(key, logRecord, pos); ok || err != nil {
+			return err
+		}
+		// 范围删除需要在之前的记录都应用到索引之后才能确定删除的 key
+		if logRecord.Type == data.LogRecordRangeDelete {
+			if err := flushEntries(); err != nil {
+				return err
+			}
+			db.seqNo = currentSeqNo
+			db.reclaimSize += int64(pos.Size)
+			_, err := db.deleteRangeFromIndex(key, logRecord.Value, pos, false)
+			return err
+		}
+		entries = append(entries, db.replayEntry(key, logRecord.Type, pos))
+		return nil
+	}
+
+	var lastOffset int64
+	var lastLoaded bool
 	// 遍历文件id，处理文件当中的内容
-	for i, fid := range db.fileIds {
+	for i, fid := range fileIds {
 		fileId := uint32(fid)
-		if hasMerged && fileId < nonMergeFileId {
+		if fileId < startFileId {
 			continue
 		}
 		var dataFile *data.DataFile
@@ -504,27 +799,38 @@ func (db *DB) loadIndexFromDataFile() error {
 		}
 		// 处理文件当中的内容
 		var offset int64 = 0
+		if fileId == startFileId {
+			offset = startOffset
+		}
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
-				return err
+				return 0, false, err
 			}
 
-			// 构建内存索引，保存到内存索引中
+			// 构建索引，保存到索引中
 			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
 			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+			if logRecord.Type == data.LogRecordTimestamp {
+				// 时间戳不包含数据
+			} else if seqNo == nonTransactionSeqNo {
+				if err := addEntry(realKey, logRecord, logRecordPos); err != nil {
+					return 0, false, err
+				}
+				lastPos = logRecordPos
 			} else {
 				if logRecord.Type == data.LogRecordTxnFinished {
 					for _, txnRecord := range transactionRecords[seqNo] {
 						realKey, _ = parseLogRecordKey(txnRecord.Record.Key)
-						updateIndex(realKey, txnRecord.Record.Type, txnRecord.Pos)
+						if err := addEntry(realKey, txnRecord.Record, txnRecord.Pos); err != nil {
+							return 0, false, err
+						}
 					}
 					delete(transactionRecords, seqNo)
+					lastPos = logRecordPos
 				} else {
 					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
 						Record: logRecord,
@@ -537,13 +843,110 @@ func (db *DB) loadIndexFromDataFile() error {
 				currentSeqNo = seqNo
 			}
 			offset += size
+
+			if len(entries) >= replayBatchSize {
+				if err := flushEntries(); err != nil {
+					return 0, false, err
+				}
+			}
 		}
-		// 活跃文件 offset 更新
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOffset = offset
+		if i == len(fileIds)-1 {
+			lastOffset, lastLoaded = offset, true
 		}
 	}
+	if err := flushEntries(); err != nil {
+		return 0, false, err
+	}
 	db.seqNo = currentSeqNo
+	return lastOffset, lastLoaded, nil
+}
+
+// 写入过程中崩溃时活跃文件的末尾可能有不完整的记录, 截断之后才能继续追加写入
+func (db *DB) truncateActiveFile(offset int64) error {
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= offset {
+		return nil
+	}
+	if err := os.Truncate(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId), offset); err != nil {
+		return err
+	}
+	info := TruncatedInfo{FileId: db.activeFile.FileId, Offset: offset, Size: size - offset}
+	db.logger.Warn("truncated incomplete records at the end of data file",
+		"file_id", info.FileId, "offset", info.Offset, "size", info.Size)
+	db.notifyListener(func(listener EventListener) {
+		listener.OnRecoveryTruncated(info)
+	})
+	return nil
+}
+
+// 根据重放的记录构造索引的更新, 同时维护无效数据的大小
+func (db *DB) replayEntry(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) *index.BatchEntry {
+	if typ == data.LogRecordTypeDeleted {
+		db.reclaimSize += int64(pos.Size)
+		return &index.BatchEntry{Key: key}
+	}
+	return &index.BatchEntry{Key: key, Pos: pos}
+}
+
+// 将写入的数据应用到索引中, lastPos 为本次写入的最后一条记录的位置
+// 持久化索引会在同一个事务中记录检查点
+func (db *DB) applyToIndex(entries []*index.BatchEntry, lastPos *data.LogRecordPos) error {
+	var oldPositions []*data.LogRecordPos
+	var err error
+	if pi, ok := db.index.(index.PersistentIndexer); ok {
+		// lastPos 为 nil 时不更新检查点, 用于分批应用同一条记录
+		var checkpoint *index.Checkpoint
+		if lastPos != nil {
+			checkpoint = &index.Checkpoint{
+				Fid:       lastPos.Fid,
+				Offset:    lastPos.Offset + int64(lastPos.Size),
+				SeqNo:     db.seqNo,
+				MergedFid: db.mergedFileId,
+			}
+		}
+		oldPositions, err = pi.ApplyBatch(entries, checkpoint)
+	} else {
+		oldPositions = make([]*data.LogRecordPos, len(entries))
+		for i, entry := range entries {
+			if entry.Pos == nil {
+				oldPositions[i], _, err = db.index.Delete(entry.Key)
+			} else {
+				oldPositions[i], err = db.index.Put(entry.Key, entry.Pos)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// 数据已经写入文件, 重新打开时会重放到索引中, 布隆过滤器需要包含这些 key
+		if db.filter != nil && !db.filterCovered {
+			for _, entry := range entries {
+				if entry.Pos != nil {
+					db.filter.Add(entry.Key)
+				}
+			}
+		}
+		return db.indexWriteError(err)
+	}
+
+	for _, oldPos := range oldPositions {
+		if oldPos != nil {
+			db.reclaimSize += int64(oldPos.Size)
+		}
+	}
+	// 只把新加入索引的 key 加入布隆过滤器, 覆盖写入的 key 已经在过滤器中
+	// 和索引在同一把锁内更新, 索引中存在的 key 一定能通过过滤器
+	if db.filter != nil && !db.filterCovered {
+		for i, entry := range entries {
+			if entry.Pos != nil && oldPositions[i] == nil {
+				db.filter.Add(entry.Key)
+			}
+		}
+	}
 	return nil
 }
 
@@ -556,35 +959,33 @@ func checkOptions(options Options) error {
 		return errors.New("data file size must be positive")
 	}
 
+	if options.ValueCacheSize < 0 {
+		return errors.New("value cache size must not be negative")
+	}
+
 	if options.DataFileMergeRatio > 1.0 || options.DataFileMergeRatio < 0.0 {
 		return errors.New("data file merge ratio must be between 0.0 and 1.0")
 	}
 
-	return nil
-}
-
-func (db *DB) loadSeqNo() error {
-	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
-		return nil
+	if options.BloomFalsePositive >= 1.0 || options.BloomFalsePositive < 0.0 {
+		return errors.New("bloom filter false positive rate must be in [0.0, 1.0)")
 	}
 
-	seqNoFile, err := data.OpenSeqNoFile(fileName)
-	if err != nil {
-		return err
-	}
-	record, _, err := seqNoFile.ReadLogRecord(0)
-	if err != nil {
-		return err
+	// 持久化的索引文件中以明文保存 key, 加密时只能使用内存索引
+	if options.KeyProvider != nil && options.IndexType == BPlusTree && options.CustomIndexer == "" {
+		return errors.New("encryption only supports in-memory indexes")
 	}
 
-	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
-	if err != nil {
-		return err
+	if options.ReadOnly {
+		if options.IndexType == BPlusTree && options.CustomIndexer == "" {
+			return errors.New("read-only mode only supports in-memory indexes")
+		}
+		if options.ReplicaOf != "" || options.ArchiveDir != "" {
+			return errors.New("read-only mode cannot be used with replication or archiving")
+		}
 	}
-	db.seqNo = seqNo
-	db.seqNoExists = true
-	return os.Remove(fileName)
+
+	return nil
 }
 
 func (db *DB) resetIoType() error {
@@ -602,10 +1003,3 @@ func (db *DB) resetIoType() error {
 	}
 	return nil
 }
-
-// Backup 备份数据库
-func (db *DB) Backup(dir string) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileFlockName})
-}
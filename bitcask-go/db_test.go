@@ -1,12 +1,29 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
+	"bitcask-go/index"
 	"bitcask-go/utils"
+	"bytes"
+	"context"
 	"github.com/stretchr/testify/assert"
+	"log/slog"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
+// 打开测试用的 DB, name 不为空时在以 name 为前缀的临时目录中打开, 否则使用 opts.DirPath
+func openTestDB(t *testing.T, name string, opts Options) *DB {
+	if name != "" {
+		opts.DirPath, _ = os.MkdirTemp("", name)
+	}
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db
+}
+
 // 测试完成之后销毁 DB 数据目录
 func destroyDB(db *DB) {
 	if db != nil {
@@ -372,3 +389,363 @@ func TestDB_Backup(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db1)
 }
+
+func TestDB_BloomFilter(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	assert.NotNil(t, db.filter)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	// 写入的 key 都能通过过滤器
+	for i := 0; i < 10000; i++ {
+		assert.True(t, db.keyMayExist(utils.GetTestKey(i)))
+	}
+	_, err = db.Get([]byte("some key unknown"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启后从文件中加载过滤器
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.BloomFilterFileName))
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10000), db2.filter.Count())
+	_, err = os.Stat(filepath.Join(dir, data.BloomFilterFileName))
+	assert.True(t, os.IsNotExist(err))
+	for i := 0; i < 10000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+func TestDB_BloomFilterCount(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom-count")
+	opts.DirPath = dir
+	opts.DataFileSize = 256 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 覆盖写入的 key 不会重复加入过滤器
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 1000; j++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(j), utils.RandomValue(24)))
+		}
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(0), utils.RandomValue(24)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(1000), utils.RandomValue(24)))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, uint64(1001), db.filter.Count())
+
+	// 重启时加载的过滤器已经包含全部的 key, 重放数据文件不会再加入
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1001), db.filter.Count())
+	}
+
+	// 异常退出时没有保存过滤器, 重放数据文件重新构建
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.Remove(filepath.Join(dir, data.BloomFilterFileName)))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1001), db.filter.Count())
+
+	// merge 生成的过滤器只包含 merge 时的 key, 之后写入的新 key 在重放时加入
+	assert.Nil(t, db.Merge())
+	for i := 1001; i < 1101; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.Remove(filepath.Join(dir, data.BloomFilterFileName)))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1101), db.filter.Count())
+	for i := 0; i < 1101; i++ {
+		assert.True(t, db.keyMayExist(utils.GetTestKey(i)))
+	}
+}
+
+func TestDB_BloomFilterDisabled(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bloom-disabled")
+	opts.DirPath = dir
+	opts.BloomFalsePositive = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Nil(t, db.filter)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-cache")
+	opts.DirPath = dir
+	opts.ValueCacheSize = 1024 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	val1 := utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(1), val1)
+	assert.Nil(t, err)
+
+	// 第一次读取未命中, 之后命中缓存
+	for i := 0; i < 3; i++ {
+		val, err := db.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, val1, val)
+	}
+	m := db.Metrics()
+	assert.Equal(t, uint64(2), m.CacheHits)
+	assert.Equal(t, uint64(1), m.CacheMisses)
+
+	// 更新之后位置改变, 不会读到旧的缓存
+	val2 := utils.RandomValue(128)
+	err = db.Put(utils.GetTestKey(1), val2)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, val2, val)
+
+	// 删除之后读取不到
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 模拟进程异常退出, 不执行 Close 中的持久化逻辑
+func crashDB(db *DB) {
+	_ = db.index.Close()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, oldFile := range db.oldFiles {
+		_ = oldFile.Close()
+	}
+	_ = db.fileLock.Unlock()
+}
+
+func TestDB_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(200), utils.RandomValue(24))
+	_ = wb.Delete(utils.GetTestKey(2))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	// 重启之后事务序列号和索引都能恢复
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), db2.seqNo)
+	assert.Equal(t, 99, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(200))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 重启之后可以继续使用 WriteBatch
+	wb2 := db2.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb2.Put(utils.GetTestKey(300), utils.RandomValue(24))
+	err = wb2.Commit()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), db2.seqNo)
+}
+
+func TestDB_BPlusTreeRecovery(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-bptree-recovery")
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	// 数据已经写入数据文件, 但是还没有更新索引时进程退出
+	for i := 100; i < 200; i++ {
+		_, err := db.appendLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeqNo(utils.GetTestKey(i), nonTransactionSeqNo),
+			Value: utils.RandomValue(24),
+		})
+		assert.Nil(t, err)
+	}
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:  logRecordKeyWithSeqNo(utils.GetTestKey(0), nonTransactionSeqNo),
+		Type: data.LogRecordTypeDeleted,
+	})
+	assert.Nil(t, err)
+	// 事务的数据写入了一半
+	_, err = db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(utils.GetTestKey(300), 5),
+		Value: utils.RandomValue(24),
+	})
+	assert.Nil(t, err)
+	crashDB(db)
+
+	// 重启之后重放检查点之后的数据
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 199, len(db2.ListKeys()))
+	for i := 1; i < 200; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	_, err = db2.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(300))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint64(5), db2.seqNo)
+}
+
+func TestDB_CustomIndexer(t *testing.T) {
+	err := index.Register("test-custom-btree", func(dirPath string, syncWrites bool) (index.Indexer, error) {
+		return index.NewBTree(), nil
+	})
+	assert.Nil(t, err)
+
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-custom-indexer")
+	opts.DirPath = dir
+	opts.CustomIndexer = "test-custom-btree"
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// 未注册的索引
+	opts2 := DefaultOptions
+	opts2.DirPath, _ = os.MkdirTemp("", "bitcask-go-custom-indexer-unknown")
+	defer func() {
+		_ = os.RemoveAll(opts2.DirPath)
+	}()
+	opts2.CustomIndexer = "unknown"
+	_, err = Open(opts2)
+	assert.Equal(t, index.ErrUnsupportedIndexType, err)
+}
+
+func TestDB_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-logger")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "msg=\"bitcask opened\"")
+	// 空的数据库还没有数据文件
+	assert.Contains(t, buf.String(), "data_files=0")
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(16)))
+	assert.Nil(t, db.Merge())
+	assert.Contains(t, buf.String(), "msg=\"merge started\"")
+	assert.Contains(t, buf.String(), "msg=\"merge completed\"")
+	assert.Contains(t, buf.String(), "msg=\"data file rotated\"")
+
+	// Stat 不遍历数据目录, 目录被删除之后仍然返回打开的数据文件的统计
+	assert.Nil(t, os.RemoveAll(dir))
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+}
+
+func TestDB_Context(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.PutContext(context.Background(), utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.PutContext(ctx, utils.GetTestKey(10), []byte("v")))
+	_, err = db.GetContext(ctx, utils.GetTestKey(1))
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, context.Canceled, db.DeleteContext(ctx, utils.GetTestKey(1)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(10), []byte("v")))
+	assert.Equal(t, context.Canceled, wb.CommitContext(ctx))
+	assert.Equal(t, 10, len(db.ListKeys()))
+
+	// 遍历过程中取消
+	ctx, cancel = context.WithCancel(context.Background())
+	var n int
+	err = db.FoldContext(ctx, func(key []byte, value []byte) bool {
+		if n++; n == 3 {
+			cancel()
+		}
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 3, n)
+
+	// 取消的备份不会留下拷贝的文件
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-context-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Equal(t, context.Canceled, db.BackupContext(ctx, backupDir))
+	entries, err := os.ReadDir(backupDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(entries))
+	assert.Nil(t, db.BackupContext(context.Background(), backupDir))
+}
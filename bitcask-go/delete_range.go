@@ -0,0 +1,113 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bytes"
+	"sync/atomic"
+)
+
+// 范围删除每次更新索引的 key 数量, 不需要把范围内所有的 key 同时放在内存中
+const rangeDeleteBatchSize = 1024
+
+// DeleteRange 删除 [start, end) 范围内的所有 key, end 为空时删除 start 之后所有的 key
+// 只写入一条范围删除的记录, 重启时重放这条记录删除范围内已经存在的 key
+func (db *DB) DeleteRange(start []byte, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+
+	var pub *publication
+	defer func() { pub.deliver() }()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 范围内没有数据时不写入
+	if !db.hasKeyInRange(start, end) {
+		return nil
+	}
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(start, nonTransactionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDelete,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.reclaimSize += int64(pos.Size)
+
+	events, err := db.deleteRangeFromIndex(start, end, pos, true)
+	if err != nil {
+		return err
+	}
+	pub = db.publish(events)
+	return nil
+}
+
+// DropPrefix 删除以 prefix 为前缀的所有 key
+func (db *DB) DropPrefix(prefix []byte) error {
+	return db.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+// 索引中 [start, end) 范围内是否有 key, end 为空时没有上界
+func (db *DB) hasKeyInRange(start []byte, end []byte) bool {
+	var found bool
+	db.index.AscendRange(start, end, func([]byte, *data.LogRecordPos) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// 分批删除索引中 [start, end) 范围内的 key, pos 为范围删除记录的位置
+// withEvents 为 true 并且有订阅时返回删除的变更事件, 没有订阅时不需要保存删除的 key
+// 每批只遍历范围内的一段 key, 删除之后从下一个 key 继续, 不会遍历或者拷贝范围之外的索引
+// 持久化索引只在最后一批记录检查点, 中途退出时重启会重新应用这条记录
+func (db *DB) deleteRangeFromIndex(start []byte, end []byte, pos *data.LogRecordPos, withEvents bool) ([]Event, error) {
+	withEvents = withEvents && atomic.LoadInt32(&db.watcherNum) > 0
+	var events []Event
+	for {
+		var keys [][]byte
+		db.index.AscendRange(start, end, func(key []byte, _ *data.LogRecordPos) bool {
+			keys = append(keys, bytes.Clone(key))
+			return len(keys) < rangeDeleteBatchSize
+		})
+		last := len(keys) < rangeDeleteBatchSize
+
+		entries := make([]*index.BatchEntry, len(keys))
+		for i, key := range keys {
+			entries[i] = &index.BatchEntry{Key: key}
+		}
+		checkpoint := pos
+		if !last {
+			checkpoint = nil
+		}
+		if err := db.applyToIndex(entries, checkpoint); err != nil {
+			return nil, err
+		}
+		if withEvents {
+			for _, key := range keys {
+				events = append(events, Event{Type: EventDelete, Key: key})
+			}
+		}
+		if last {
+			return events, nil
+		}
+		start = append(bytes.Clone(keys[len(keys)-1]), 0)
+	}
+}
+
+// 大于所有以 prefix 为前缀的 key 的最小的 key, 不存在时返回 nil
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
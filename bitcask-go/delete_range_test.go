@@ -0,0 +1,150 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	// 删除 [10, 20)
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20)))
+	assert.Equal(t, 90, len(db.ListKeys()))
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+
+	// 范围删除之后写入的 key 不受影响
+	assert.Nil(t, db.Put(utils.GetTestKey(15), []byte("new")))
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(90), nil))
+	assert.Equal(t, 81, len(db.ListKeys()))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 81, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(15))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db.Get(utils.GetTestKey(95))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_DropPrefix(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-drop-prefix")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(append([]byte("user:"), utils.GetTestKey(i)...), utils.RandomValue(16)))
+		assert.Nil(t, db.Put(append([]byte("order:"), utils.GetTestKey(i)...), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.DropPrefix([]byte("user:")))
+	assert.Equal(t, 50, len(db.ListKeys()))
+	assert.True(t, db.Stat().ReclaimableSize > 0)
+
+	// merge 之后删除的数据和范围删除的记录都被清理
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db.ListKeys()))
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+	iter := db.NewIterator(IteratorOptions{Prefix: []byte("user:")})
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	assert.Equal(t, []byte("b"), prefixEnd([]byte("a\xff")))
+	assert.Nil(t, prefixEnd([]byte("\xff")))
+}
+
+func TestDB_WriteBatchDeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-delete-range")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(append([]byte("user:"), utils.GetTestKey(i)...), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Put([]byte("meta"), []byte("v")))
+
+	// 范围删除和其他写入在一个事务中提交, 批次中写入范围内的 key 不会被删除
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete([]byte("meta")))
+	assert.Nil(t, wb.DropPrefix([]byte("user:")))
+	assert.Nil(t, wb.Put([]byte("user:new"), []byte("new")))
+	assert.Nil(t, wb.DeleteRange([]byte("z"), []byte("a")))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, [][]byte{[]byte("user:new")}, db.ListKeys())
+
+	// 范围内没有数据时只提交其他写入
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.DropPrefix([]byte("order:")))
+	assert.Nil(t, wb.Commit())
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user:new")}, db.ListKeys())
+	val, err := db.Get([]byte("user:new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+}
+
+func TestDB_DeleteRangeBatches(t *testing.T) {
+	for _, indexType := range []IndexerType{Btree, ART, BPlusTree, SkipList} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-delete-range-batches")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for i := 0; i < 3000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+		}
+		// 范围内的 key 超过一批, 分批删除
+		ch, cancel := db.WatchWithOptions(nil, WatchOptions{BufferSize: 4096})
+		assert.Nil(t, db.DeleteRange(utils.GetTestKey(100), utils.GetTestKey(2600)))
+		cancel()
+		var events int
+		for event := range ch {
+			assert.Equal(t, EventDelete, event.Type)
+			events++
+		}
+		assert.Equal(t, 2500, events)
+		assert.Equal(t, 500, len(db.ListKeys()))
+		_, err = db.Get(utils.GetTestKey(99))
+		assert.Nil(t, err)
+		_, err = db.Get(utils.GetTestKey(2599))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get(utils.GetTestKey(2600))
+		assert.Nil(t, err)
+
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 500, len(db.ListKeys()))
+		destroyDB(db)
+	}
+}
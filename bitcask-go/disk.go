@@ -0,0 +1,117 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 获取磁盘可用空间, 测试中替换为模拟的实现
+var availableDiskSize = utils.AvailableDiskSize
+
+// 两次检查磁盘可用空间的最小间隔, 只读状态下经过这个间隔之后的写入会重新检查, 空间释放之后恢复写入
+const diskCheckInterval = time.Second
+
+// 磁盘可用空间的检查状态, 除了 full 之外都在持有写锁时访问
+type diskMonitor struct {
+	full      int32     // 为 1 时处于磁盘空间不足的只读状态
+	checkedAt time.Time // 最近一次检查的时间
+	written   int64     // 最近一次检查之后写入的字节数
+}
+
+func (m *diskMonitor) isFull() bool {
+	return atomic.LoadInt32(&m.full) == 1
+}
+
+// 写入之前检查磁盘可用空间, 空间不足时返回 ErrDiskFull
+// 距离上次检查超过间隔, 或者写入的数据超过阈值的一半时重新获取可用空间, 避免每次写入都调用 statfs
+func (db *DB) checkDiskSpace() error {
+	m := &db.disk
+	threshold := db.options.MinFreeDiskSize
+	if threshold == 0 && !m.isFull() {
+		return nil
+	}
+	if time.Since(m.checkedAt) >= diskCheckInterval || (threshold > 0 && uint64(m.written) >= threshold/2) {
+		available, err := availableDiskSize(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		m.checkedAt, m.written = time.Now(), 0
+		db.setDiskFull(available == 0 || available < threshold, available)
+	}
+	if m.isFull() {
+		return ErrDiskFull
+	}
+	return nil
+}
+
+// 切换磁盘空间不足的状态, 状态变化时记录日志并通知 EventListener
+func (db *DB) setDiskFull(full bool, available uint64) {
+	var value int32
+	if full {
+		value = 1
+	}
+	if atomic.SwapInt32(&db.disk.full, value) == value {
+		return
+	}
+	info := DiskFullInfo{Full: full, Available: available, Threshold: db.options.MinFreeDiskSize}
+	if full {
+		db.logger.Warn("disk is almost full, rejecting writes", "dir", db.options.DirPath,
+			"available", info.Available, "threshold", info.Threshold)
+	} else {
+		db.logger.Info("disk space is freed, accepting writes", "dir", db.options.DirPath,
+			"available", info.Available, "threshold", info.Threshold)
+	}
+	db.notifyListener(func(listener EventListener) {
+		listener.OnDiskFullChanged(info)
+	})
+}
+
+// 写入活跃文件, 失败时截断写入了一部分的记录, 避免之后追加的记录跟在不完整的数据后面
+// 磁盘已满时进入只读状态并返回 ErrDiskFull
+func (db *DB) writeActiveFile(buf []byte) error {
+	err := db.activeFile.Write(buf)
+	if err == nil {
+		db.disk.written += int64(len(buf))
+		return nil
+	}
+	// 写入位置只在写入成功时更新, 截断到写入位置即可丢弃不完整的记录
+	if terr := db.truncatePartialWrite(); terr != nil {
+		db.logger.Error("failed to truncate partial write", "file_id", db.activeFile.FileId, "error", terr)
+		err = errors.Join(err, terr)
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		db.logger.Error("failed to write data file", "file_id", db.activeFile.FileId, "error", err)
+		db.disk.checkedAt = time.Now()
+		db.setDiskFull(true, 0)
+		return ErrDiskFull
+	}
+	return err
+}
+
+// 索引更新失败时记录日志, 磁盘已满时和写入数据文件一样进入只读状态并返回 ErrDiskFull
+// 数据已经写入文件, 重新打开数据库时会重放到索引中
+func (db *DB) indexWriteError(err error) error {
+	db.logger.Error("failed to update index", "dir", db.options.DirPath, "error", err)
+	if errors.Is(err, syscall.ENOSPC) {
+		db.disk.checkedAt = time.Now()
+		db.setDiskFull(true, 0)
+		return ErrDiskFull
+	}
+	return err
+}
+
+func (db *DB) truncatePartialWrite() error {
+	size, err := db.activeFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= db.activeFile.WriteOffset {
+		return nil
+	}
+	return os.Truncate(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId), db.activeFile.WriteOffset)
+}
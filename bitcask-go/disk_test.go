@@ -0,0 +1,214 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 模拟的磁盘可用空间
+type fakeDisk struct {
+	lock      sync.Mutex
+	available uint64
+	checks    int
+}
+
+func (d *fakeDisk) set(available uint64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.available = available
+}
+
+func (d *fakeDisk) availableDiskSize(string) (uint64, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.checks++
+	return d.available, nil
+}
+
+func useFakeDisk(t *testing.T, available uint64) *fakeDisk {
+	disk := &fakeDisk{available: available}
+	availableDiskSize = disk.availableDiskSize
+	t.Cleanup(func() {
+		availableDiskSize = utils.AvailableDiskSize
+	})
+	return disk
+}
+
+type diskListener struct {
+	NoopEventListener
+	lock    sync.Mutex
+	changes []DiskFullInfo
+}
+
+func (l *diskListener) OnDiskFullChanged(info DiskFullInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.changes = append(l.changes, info)
+}
+
+// 写入一半之后返回 ENOSPC
+type partialWriteIO struct {
+	fio.IOManager
+}
+
+func (p *partialWriteIO) Write(b []byte) (int, error) {
+	n, _ := p.IOManager.Write(b[:len(b)/2])
+	return n, syscall.ENOSPC
+}
+
+func TestDB_DiskFull(t *testing.T) {
+	disk := useFakeDisk(t, 10*1024*1024)
+	listener := &diskListener{}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-full")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MinFreeDiskSize = 1024 * 1024
+	opts.EventListener = listener
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.False(t, db.Metrics().DiskFull)
+
+	// 可用空间低于阈值之后, 下一次检查时进入只读状态
+	disk.set(512 * 1024)
+	db.disk.checkedAt = time.Time{}
+	assert.Equal(t, ErrDiskFull, db.Put(utils.GetTestKey(100), utils.RandomValue(128)))
+	assert.Equal(t, ErrDiskFull, db.Delete(utils.GetTestKey(0)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(101), utils.RandomValue(128)))
+	assert.Equal(t, ErrDiskFull, wb.Commit())
+	assert.True(t, db.Metrics().DiskFull)
+
+	// 只读状态下可以读取和 merge
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+
+	// 空间释放之后自动恢复写入
+	disk.set(10 * 1024 * 1024)
+	db.disk.checkedAt = time.Time{}
+	assert.Nil(t, db.Put(utils.GetTestKey(100), utils.RandomValue(128)))
+	assert.False(t, db.Metrics().DiskFull)
+
+	assert.Equal(t, 2, len(listener.changes))
+	assert.True(t, listener.changes[0].Full)
+	assert.Equal(t, uint64(512*1024), listener.changes[0].Available)
+	assert.Equal(t, opts.MinFreeDiskSize, listener.changes[0].Threshold)
+	assert.False(t, listener.changes[1].Full)
+
+	// 可用空间不足以容纳有效数据时不能 merge
+	disk.set(1024)
+	assert.Equal(t, ErrNoEnoughSpaceForMerge, db.Merge())
+}
+
+func TestDB_DiskCheckInterval(t *testing.T) {
+	disk := useFakeDisk(t, 10*1024*1024)
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-check")
+	opts.DirPath = dir
+	opts.MinFreeDiskSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 间隔之内只有写入的数据超过阈值的一半时才重新检查
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(128)))
+	assert.Equal(t, 1, disk.checks)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(128)))
+	assert.Equal(t, 1, disk.checks)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(1024)))
+	}
+	assert.True(t, disk.checks > 1)
+
+	// 没有配置阈值时不检查
+	opts.MinFreeDiskSize = 0
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	checks := disk.checks
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(128)))
+	assert.Equal(t, checks, disk.checks)
+}
+
+func TestDB_DiskFullPartialWrite(t *testing.T) {
+	useFakeDisk(t, 10*1024*1024)
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-partial")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+
+	// 写入一半时磁盘已满, 不完整的记录被截断
+	ioManager := db.activeFile.IoManager
+	db.activeFile.IoManager = &partialWriteIO{IOManager: ioManager}
+	assert.Equal(t, ErrDiskFull, db.Put([]byte("k2"), []byte("v2")))
+	db.activeFile.IoManager = ioManager
+	size, err := ioManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, db.activeFile.WriteOffset, size)
+	assert.True(t, db.Metrics().DiskFull)
+
+	// 没有配置阈值时, 经过检查间隔之后只要有可用空间就恢复写入
+	db.disk.checkedAt = time.Time{}
+	assert.Nil(t, db.Put([]byte("k3"), []byte("v3")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 更新索引时返回 ENOSPC
+type diskFullIndexer struct {
+	index.Indexer
+}
+
+func (d *diskFullIndexer) Put(key []byte, pos *data.LogRecordPos) (*data.LogRecordPos, error) {
+	return nil, syscall.ENOSPC
+}
+
+func TestDB_DiskFullIndex(t *testing.T) {
+	useFakeDisk(t, 10*1024*1024)
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-disk-index")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 索引写入失败时和数据文件一样进入只读状态
+	indexer := db.index
+	db.index = &diskFullIndexer{Indexer: indexer}
+	assert.Equal(t, ErrDiskFull, db.Put([]byte("k1"), []byte("v1")))
+	db.index = indexer
+	assert.True(t, db.Metrics().DiskFull)
+	assert.Equal(t, ErrDiskFull, db.Put([]byte("k2"), []byte("v2")))
+
+	// 数据已经写入文件, 重新打开之后重放到索引中
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+}
@@ -0,0 +1,151 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 数据目录中的数据文件, hint 文件, merge 完成标识, 布隆过滤器和索引文件都不包含明文
+func assertNoPlaintext(t *testing.T, dir string, plaintext []byte) {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		name := entry.Name()
+		if filepath.Ext(name) != data.DataFileNameSuffix && name != data.HintFileName && name != data.MergeFinishedFileName &&
+			name != data.BloomFilterFileName && name != "bptree-index" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(content, plaintext), name)
+	}
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.KeyProvider = NewStaticKeyProvider(1, map[uint32][]byte{1: []byte("secret-key-1")})
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	value := bytes.Repeat([]byte("plaintext-value"), 4)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), value))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	assertNoPlaintext(t, opts.DirPath, []byte("plaintext-value"))
+	assertNoPlaintext(t, opts.DirPath, []byte("bitcask-go-key"))
+
+	// 没有密钥时无法打开
+	assert.Nil(t, db.Close())
+	_, err = os.Stat(filepath.Join(opts.DirPath, data.BloomFilterFileName))
+	assert.Nil(t, err)
+	assertNoPlaintext(t, opts.DirPath, []byte(bloomFilterKey))
+	plainOpts := opts
+	plainOpts.KeyProvider = nil
+	_, err = Open(plainOpts)
+	assert.Equal(t, data.ErrMissingKeyProvider, err)
+
+	// 轮换密钥之后新的数据使用新的密钥
+	opts.KeyProvider = NewStaticKeyProvider(2, map[uint32][]byte{1: []byte("secret-key-1"), 2: []byte("secret-key-2")})
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 1000; i < 1200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	got, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, got)
+
+	// merge 使用新的密钥重新加密所有数据, 之后不再需要旧的密钥
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	opts.KeyProvider = NewStaticKeyProvider(2, map[uint32][]byte{2: []byte("secret-key-2")})
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	_, err = os.Stat(filepath.Join(opts.DirPath, data.HintFileName))
+	assert.Nil(t, err)
+	assertNoPlaintext(t, opts.DirPath, []byte("plaintext-value"))
+	assert.Equal(t, 1200, len(db.ListKeys()))
+	for i := 1; i < 1200; i++ {
+		got, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, got)
+	}
+	got, err = db.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, got)
+}
+
+func TestDB_EncryptionPersistentIndex(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-encryption-index")
+	opts.KeyProvider = NewStaticKeyProvider(1, map[uint32][]byte{1: []byte("secret-key-1")})
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+	defer os.RemoveAll(opts.DirPath)
+
+	// 持久化的索引以明文保存 key, 加密时不能使用
+	bptreeOpts := opts
+	bptreeOpts.IndexType = BPlusTree
+	_, err = Open(bptreeOpts)
+	assert.NotNil(t, err)
+	bptreeOpts.IndexType = Btree
+	bptreeOpts.CustomIndexer = "bptree"
+	_, err = Open(bptreeOpts)
+	assert.NotNil(t, err)
+	assertNoPlaintext(t, opts.DirPath, []byte("bitcask-go-key"))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_EncryptionReplication(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-encryption-primary")
+	opts.DataFileSize = 4 * 1024
+	opts.KeyProvider = NewStaticKeyProvider(1, map[uint32][]byte{1: []byte("primary-key")})
+	primary, err := Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(primary)
+	server, err := primary.StartReplication("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer server.Close()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+
+	// 副本可以使用不同的密钥, 加密之后的写入位置和主节点相同
+	replicaOpts := DefaultOptions
+	replicaOpts.DirPath, _ = os.MkdirTemp("", "bitcask-go-encryption-replica")
+	replicaOpts.ReplicaOf = server.Addr().String()
+	replicaOpts.KeyProvider = NewStaticKeyProvider(7, map[uint32][]byte{7: []byte("replica-key")})
+	replica, err := Open(replicaOpts)
+	assert.Nil(t, err)
+	defer destroyDB(replica)
+
+	for i := 100; i < 150; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	waitReplicaSynced(t, primary, replica)
+	assertSameData(t, primary, replica)
+	assert.Nil(t, replica.ReplicationError())
+}
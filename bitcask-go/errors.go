@@ -13,4 +13,21 @@ var (
 	ErrDatabaseIsUsing       = errors.New("the database directory is using by another process")
 	ErrMergeRatioUnReached   = errors.New("merge ratio is unreached")
 	ErrNoEnoughSpaceForMerge = errors.New("no enough space for merge")
+	ErrWriteOnReplica        = errors.New("cannot write to a replica")
+	ErrDiskFull              = errors.New("no enough disk space, the database is read-only")
+	ErrReadOnly              = errors.New("the database is opened in read-only mode")
+	ErrDataFilesReplaced     = errors.New("data files have been replaced by merge, reopen the database")
+	ErrReplicationCursorLost = errors.New("replication cursor is no longer available on the primary")
+	ErrReplicationOutOfOrder = errors.New("replicated record is out of order")
+	ErrDirectoryNotEmpty     = errors.New("the directory is not empty")
+	ErrBackupCorrupt         = errors.New("backup manifest is missing or corrupted")
+	ErrRestoreTargetTooEarly = errors.New("restore target is earlier than the backup")
+	ErrArchiveIncomplete     = errors.New("archived data files are not continuous with the backup")
+	ErrInvalidExportFormat   = errors.New("invalid export format")
+	ErrInvalidExportData     = errors.New("invalid export data")
+	ErrNamespaceNameIsEmpty  = errors.New("the namespace name is empty")
+	ErrNamespaceExists       = errors.New("namespace already exists")
+	ErrNamespaceNotFound     = errors.New("namespace not found")
+	ErrNamespaceDropped      = errors.New("namespace has been dropped")
+	ErrNamespaceUnsupported  = errors.New("namespaces only support in-memory indexes")
 )
@@ -0,0 +1,207 @@
+package bitcask_go
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// ExportFormat 导出数据的格式
+type ExportFormat = int8
+
+const (
+	// ExportBinary 二进制格式: magic | (key size | key | value size | value)...
+	ExportBinary ExportFormat = iota + 1
+	// ExportJSONL 每行一个 json 对象, 不是 utf8 编码的数据使用 base64 编码
+	ExportJSONL
+)
+
+// 二进制格式的文件头, 导入时用于识别格式
+var exportMagic = []byte("BCKX\x01")
+
+const exportEncodingBase64 = "base64"
+
+// 导入的一条数据中 key 和 value 的总长度上限, 和数据文件中记录的长度限制一致
+const maxImportEntrySize = math.MaxInt32
+
+// JSONL 格式中的一条数据
+type exportEntry struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"` // 为 base64 时 key 和 value 都经过 base64 编码
+}
+
+// ParseExportFormat 将格式名称 binary 或 jsonl 转换为导出格式
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch name {
+	case "binary":
+		return ExportBinary, nil
+	case "jsonl":
+		return ExportJSONL, nil
+	}
+	return 0, ErrInvalidExportFormat
+}
+
+// Export 将所有数据以指定的格式导出, 导出的数据不包含存储引擎内部的信息
+func (db *DB) Export(w io.Writer, format ExportFormat) error {
+	return db.ExportWithOptions(w, ExportOptions{Format: format})
+}
+
+// ExportWithOptions 按照配置导出数据, 可以只导出指定前缀的 key
+func (db *DB) ExportWithOptions(w io.Writer, opts ExportOptions) error {
+	if opts.Format != ExportBinary && opts.Format != ExportJSONL {
+		return ErrInvalidExportFormat
+	}
+	bw := bufio.NewWriter(w)
+	if opts.Format == ExportBinary {
+		if _, err := bw.Write(exportMagic); err != nil {
+			return err
+		}
+	}
+
+	iter := db.NewIterator(IteratorOptions{Prefix: opts.Prefix})
+	defer iter.Close()
+	encoder := json.NewEncoder(bw)
+	var buf []byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		// 遍历过程中被删除的 key
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		key := iter.Key()
+		if opts.Format == ExportBinary {
+			buf = binary.AppendUvarint(buf[:0], uint64(len(key)))
+			buf = append(buf, key...)
+			buf = binary.AppendUvarint(buf, uint64(len(value)))
+			buf = append(buf, value...)
+			if _, err := bw.Write(buf); err != nil {
+				return err
+			}
+			continue
+		}
+		entry := exportEntry{Key: string(key), Value: string(value)}
+		if !utf8.Valid(key) || !utf8.Valid(value) {
+			entry.Key = base64.StdEncoding.EncodeToString(key)
+			entry.Value = base64.StdEncoding.EncodeToString(value)
+			entry.Encoding = exportEncodingBase64
+		}
+		if err := encoder.Encode(&entry); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Import 导入 Export 导出的数据, 自动识别数据的格式, 返回写入的数据条数
+// 数据中重复出现的 key 每次都计数, 不是导入之后新增的 key 数量
+func (db *DB) Import(r io.Reader) (int, error) {
+	return db.ImportWithOptions(r, DefaultImportOptions)
+}
+
+// ImportWithOptions 按照配置导入数据, 数据通过 WriteBatch 分批写入
+func (db *DB) ImportWithOptions(r io.Reader, opts ImportOptions) (int, error) {
+	if opts.BatchSize == 0 {
+		opts.BatchSize = DefaultImportOptions.BatchSize
+	}
+	br := bufio.NewReader(r)
+	var next func() ([]byte, []byte, error)
+	if header, _ := br.Peek(len(exportMagic)); bytes.Equal(header, exportMagic) {
+		_, _ = br.Discard(len(exportMagic))
+		next = func() ([]byte, []byte, error) {
+			return readBinaryEntry(br)
+		}
+	} else {
+		decoder := json.NewDecoder(br)
+		next = func() ([]byte, []byte, error) {
+			return readJSONEntry(decoder)
+		}
+	}
+
+	wb := db.NewWriteBatch(WriteBatchOptions{MaxBatchNum: opts.BatchSize, SyncWrites: false})
+	imported, pending := 0, uint(0)
+	for {
+		key, value, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, err
+		}
+		if !bytes.HasPrefix(key, opts.Prefix) {
+			continue
+		}
+		if err := wb.Put(key, value); err != nil {
+			return imported, err
+		}
+		if pending++; pending == opts.BatchSize {
+			if err := wb.Commit(); err != nil {
+				return imported, err
+			}
+			imported += int(pending)
+			pending = 0
+		}
+	}
+	if err := wb.Commit(); err != nil {
+		return imported, err
+	}
+	imported += int(pending)
+	return imported, db.Sync()
+}
+
+func readBinaryEntry(br *bufio.Reader) ([]byte, []byte, error) {
+	readBytes := func(limit int) ([]byte, error) {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil || size > uint64(limit) {
+			return nil, ErrInvalidExportData
+		}
+		// 随着读到的数据分配内存, 不会按照声明的长度预先分配
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, br, int64(size)); err != nil {
+			return nil, ErrInvalidExportData
+		}
+		return buf.Bytes(), nil
+	}
+	key, err := readBytes(maxImportEntrySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := readBytes(maxImportEntrySize - len(key))
+	if err == io.EOF {
+		return nil, nil, ErrInvalidExportData
+	}
+	return key, value, err
+}
+
+func readJSONEntry(decoder *json.Decoder) ([]byte, []byte, error) {
+	var entry exportEntry
+	if err := decoder.Decode(&entry); err != nil {
+		if err == io.EOF {
+			return nil, nil, err
+		}
+		return nil, nil, ErrInvalidExportData
+	}
+	if entry.Encoding != exportEncodingBase64 {
+		return []byte(entry.Key), []byte(entry.Value), nil
+	}
+	key, err := base64.StdEncoding.DecodeString(entry.Key)
+	if err != nil {
+		return nil, nil, ErrInvalidExportData
+	}
+	value, err := base64.StdEncoding.DecodeString(entry.Value)
+	if err != nil {
+		return nil, nil, ErrInvalidExportData
+	}
+	return key, value, nil
+}
@@ -0,0 +1,113 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDB_ExportImport(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-export", DefaultOptions)
+	defer destroyDB(db)
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	// 二进制的数据
+	assert.Nil(t, db.Put([]byte{0xff, 0x00, 0x01}, []byte{0x80, 0x81}))
+	assert.Nil(t, db.Put([]byte("empty-value"), nil))
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	for _, format := range []ExportFormat{ExportBinary, ExportJSONL} {
+		var buf bytes.Buffer
+		assert.Nil(t, db.Export(&buf, format))
+
+		db2 := openTestDB(t, "bitcask-go-import", DefaultOptions)
+		n, err := db2.Import(&buf)
+		assert.Nil(t, err)
+		assert.Equal(t, 3001, n)
+		assert.Equal(t, db.ListKeys(), db2.ListKeys())
+		for _, key := range db.ListKeys() {
+			expected, _ := db.Get(key)
+			value, err := db2.Get(key)
+			assert.Nil(t, err)
+			assert.Equal(t, len(expected), len(value))
+			assert.Equal(t, string(expected), string(value))
+		}
+		destroyDB(db2)
+	}
+
+	// 重复出现的 key 每次都计数, 以最后一次为准
+	db3 := openTestDB(t, "bitcask-go-import-duplicate", DefaultOptions)
+	defer destroyDB(db3)
+	n, err := db3.Import(strings.NewReader("{\"key\":\"a\",\"value\":\"1\"}\n{\"key\":\"a\",\"value\":\"2\"}\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, len(db3.ListKeys()))
+	value, err := db3.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	assert.Equal(t, ErrInvalidExportFormat, db.Export(&bytes.Buffer{}, 0))
+	_, err = ParseExportFormat("csv")
+	assert.Equal(t, ErrInvalidExportFormat, err)
+}
+
+func TestDB_ExportImportPrefix(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-export-prefix", DefaultOptions)
+	defer destroyDB(db)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte("user:"+string(rune('a'+i))), []byte("v")))
+		assert.Nil(t, db.Put([]byte("order:"+string(rune('a'+i))), []byte("v")))
+	}
+
+	var buf bytes.Buffer
+	assert.Nil(t, db.ExportWithOptions(&buf, ExportOptions{Format: ExportJSONL, Prefix: []byte("user:")}))
+	assert.Equal(t, 10, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `{"key":"user:a","value":"v"}`)
+
+	// 导出全部数据, 导入时只导入指定前缀的 key
+	buf.Reset()
+	assert.Nil(t, db.Export(&buf, ExportBinary))
+	db2 := openTestDB(t, "bitcask-go-import-prefix", DefaultOptions)
+	defer destroyDB(db2)
+	n, err := db2.ImportWithOptions(&buf, ImportOptions{Prefix: []byte("order:"), BatchSize: 3})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, 10, len(db2.ListKeys()))
+	_, err = db2.Get([]byte("user:a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_ImportInvalid(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-import-invalid", DefaultOptions)
+	defer destroyDB(db)
+
+	_, err := db.Import(strings.NewReader("not json"))
+	assert.Equal(t, ErrInvalidExportData, err)
+	_, err = db.Import(strings.NewReader(`{"key":"a","value":"%%","encoding":"base64"}`))
+	assert.Equal(t, ErrInvalidExportData, err)
+
+	// 二进制数据被截断
+	var buf bytes.Buffer
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Export(&buf, ExportBinary))
+	db2 := openTestDB(t, "bitcask-go-import-truncated", DefaultOptions)
+	defer destroyDB(db2)
+	_, err = db2.Import(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+	assert.Equal(t, ErrInvalidExportData, err)
+
+	// 声明的长度超过上限, 或者数据比声明的长度短
+	_, err = db2.Import(bytes.NewReader(append(append([]byte{}, exportMagic...), 0xff, 0xff, 0xff, 0xff, 0x0f)))
+	assert.Equal(t, ErrInvalidExportData, err)
+	_, err = db2.Import(bytes.NewReader(append(append([]byte{}, exportMagic...), 0x01, 'k', 0xff, 0xff, 0xff, 0xff, 0x07)))
+	assert.Equal(t, ErrInvalidExportData, err)
+	_, err = db2.Import(bytes.NewReader(append(append([]byte{}, exportMagic...), 0x01, 'k', 0x80, 0x80, 0x80, 0x80, 0x04, 'v')))
+	assert.Equal(t, ErrInvalidExportData, err)
+
+	// 空的输入
+	n, err := db2.Import(strings.NewReader(""))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
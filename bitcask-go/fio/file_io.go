@@ -3,7 +3,8 @@ package fio
 import "os"
 
 type FileIO struct {
-	fd *os.File
+	fd       *os.File
+	readOnly bool
 }
 
 func NewFileIOManager(filename string) (*FileIO, error) {
@@ -18,15 +19,30 @@ func NewFileIOManager(filename string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// NewReadOnlyFileIOManager 只读打开已经存在的文件, 文件不存在时返回错误
+func NewReadOnlyFileIOManager(filename string) (*FileIO, error) {
+	fd, err := os.OpenFile(filename, os.O_RDONLY, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd, readOnly: true}, nil
+}
+
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
 
 func (fio *FileIO) Write(b []byte) (int, error) {
+	if fio.readOnly {
+		return 0, ErrReadOnlyIO
+	}
 	return fio.fd.Write(b)
 }
 
 func (fio *FileIO) Sync() error {
+	if fio.readOnly {
+		return nil
+	}
 	return fio.fd.Sync()
 }
 
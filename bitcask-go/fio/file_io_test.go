@@ -96,3 +96,33 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestReadOnlyFileIO(t *testing.T) {
+	path := filepath.Join("/tmp", "readonly.data")
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.NotNil(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	fio, err := NewFileIOManager(path)
+	defer destroyFile(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("key-a"))
+	assert.Nil(t, err)
+
+	ro, err := NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	_, err = ro.Write([]byte("key-b"))
+	assert.Equal(t, ErrReadOnlyIO, err)
+	assert.Nil(t, ro.Sync())
+
+	// 可以读到其他文件句柄追加的数据
+	_, err = fio.Write([]byte("key-b"))
+	assert.Nil(t, err)
+	b := make([]byte, 10)
+	_, err = ro.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("key-akey-b"), b)
+	assert.Nil(t, ro.Close())
+	assert.Nil(t, fio.Close())
+}
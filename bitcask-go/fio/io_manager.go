@@ -1,12 +1,23 @@
 package fio
 
+import "errors"
+
 const DataFilePerm = 0644
 
+var (
+	ErrUnsupportedIOType = errors.New("unsupported io type")
+	ErrReadOnlyIO        = errors.New("io manager is read only")
+)
+
 type FileIOType byte
 
 const (
 	StandardFileIO FileIOType = iota
 	MemoryMap
+	// ReadOnlyFileIO 只读打开已经存在的文件, 可以读到其他进程追加写入的数据
+	ReadOnlyFileIO
+	// ReadOnlyMemoryMap 只读映射已经存在的文件, 只能读到映射时的数据
+	ReadOnlyMemoryMap
 )
 
 // IOManager 抽象IO接口，接入不同的IO类型，目前支持标准文件IO
@@ -34,7 +45,11 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMap(fileName)
+	case ReadOnlyFileIO:
+		return NewReadOnlyFileIOManager(fileName)
+	case ReadOnlyMemoryMap:
+		return NewReadOnlyMMap(fileName)
 	default:
-		panic("unsupported io type")
+		return nil, ErrUnsupportedIOType
 	}
 }
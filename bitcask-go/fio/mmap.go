@@ -22,16 +22,27 @@ func NewMMap(fileName string) (*MMap, error) {
 	return &MMap{readerAt: readerAt}, nil
 }
 
+// NewReadOnlyMMap 映射已经存在的文件, 文件不存在时返回错误
+func NewReadOnlyMMap(fileName string) (*MMap, error) {
+	readerAt, err := mmap.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &MMap{readerAt: readerAt}, nil
+}
+
 func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
 	return mmap.readerAt.ReadAt(b, offset)
 }
 
+// Write 内存映射只用于启动时加速读取, 不支持写入
 func (mmap *MMap) Write(b []byte) (int, error) {
-	panic("not implemented")
+	return 0, ErrReadOnlyIO
 }
 
+// Sync 没有写入的数据, 不需要持久化
 func (mmap *MMap) Sync() error {
-	panic("not implemented")
+	return nil
 }
 
 func (mmap *MMap) Close() error {
@@ -2,106 +2,68 @@ package main
 
 import (
 	bitcask "bitcask-go"
-	"encoding/json"
-	"errors"
-	"fmt"
+	"bitcask-go/auth"
+	"bitcask-go/httpserver"
+	"context"
+	"flag"
 	"log"
-	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-var db *bitcask.DB
+func main() {
+	dirPath := flag.String("dir", "", "data directory of the database, default a temp directory")
+	addr := flag.String("addr", httpserver.DefaultOptions.Addr, "address to listen on")
+	authConfig := flag.String("auth", "", "json config file of tls certificates and users, default no tls and no auth")
+	flag.Parse()
 
-func init() {
-	var err error
 	options := bitcask.DefaultOptions
-	options.DirPath, _ = os.MkdirTemp("", "bitcask-go-http")
-	db, err = bitcask.Open(options)
-	if err != nil {
-		panic(fmt.Sprintf("failed to open bitcask db, %v", err))
-	}
-}
-
-func handlePut(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodPost {
-		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
-		return
+	options.DirPath = *dirPath
+	if options.DirPath == "" {
+		options.DirPath, _ = os.MkdirTemp("", "bitcask-go-http")
 	}
-	var data map[string]string
-	if err := json.NewDecoder(request.Body).Decode(&data); err != nil {
-		http.Error(writer, err.Error(), http.StatusBadRequest)
-		return
+	db, err := bitcask.Open(options)
+	if err != nil {
+		log.Fatalf("failed to open bitcask db, %v", err)
 	}
 
-	for key, value := range data {
-		if err := db.Put([]byte(key), []byte(value)); err != nil {
-			http.Error(writer, err.Error(), http.StatusInternalServerError)
-			log.Printf("failed to put key in db %s: %v", key, err)
-			return
+	serverOptions := httpserver.DefaultOptions
+	serverOptions.Addr = *addr
+	if *authConfig != "" {
+		config, err := auth.LoadConfig(*authConfig)
+		if err != nil {
+			log.Fatalf("failed to load auth config, %v", err)
+		}
+		if config.TLS != nil {
+			if serverOptions.TLSConfig, err = config.TLS.Load(); err != nil {
+				log.Fatalf("failed to load tls certificates, %v", err)
+			}
+		}
+		if len(config.Users) > 0 {
+			if serverOptions.Auth, err = auth.New(config.Users); err != nil {
+				log.Fatalf("invalid users in auth config, %v", err)
+			}
 		}
 	}
-}
-
-func handleGet(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodGet {
-		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	key := request.URL.Query().Get("key")
-	value, err := db.Get([]byte(key))
-	if err != nil && !errors.Is(err, bitcask.ErrKeyNotFound) {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		log.Printf("failed to get key in db %s: %v", key, err)
-		return
-	}
-	writer.Header().Set("content-type", "application/json")
-	_ = json.NewEncoder(writer).Encode(string(value))
-}
-
-func handleDelete(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodDelete {
-		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	key := request.URL.Query().Get("key")
-	if err := db.Delete([]byte(key)); err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		log.Printf("failed to delete key in db %s: %v", key, err)
-		return
-	}
-	writer.Header().Set("content-type", "application/json")
-	_ = json.NewEncoder(writer).Encode("OK")
-}
+	server := httpserver.New(db, serverOptions)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("failed to shutdown http server: %v", err)
+		}
+	}()
 
-func handleListKeys(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodGet {
-		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
-		return
+	log.Printf("bitcask http server listening on %s, data dir %s", *addr, options.DirPath)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("http server stopped: %v", err)
 	}
-	keys := db.ListKeys()
-	writer.Header().Set("content-type", "application/json")
-	var result []string
-	for _, key := range keys {
-		result = append(result, string(key))
+	if err := db.Close(); err != nil {
+		log.Fatalf("failed to close bitcask db, %v", err)
 	}
-	_ = json.NewEncoder(writer).Encode(result)
-}
-
-func handleStat(writer http.ResponseWriter, request *http.Request) {
-	if request.Method != http.MethodGet {
-		http.Error(writer, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	stat := db.Stat()
-	writer.Header().Set("content-type", "application/json")
-	_ = json.NewEncoder(writer).Encode(stat)
-}
-
-func main() {
-	http.HandleFunc("/bitcask/put", handlePut)
-	http.HandleFunc("/bitcask/get", handleGet)
-	http.HandleFunc("/bitcask/delete", handleDelete)
-	http.HandleFunc("/bitcask/listkeys", handleListKeys)
-	http.HandleFunc("/bitcask/stat", handleStat)
-	_ = http.ListenAndServe(":8080", nil)
 }
@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/auth"
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// 按照 Prometheus 的文本格式输出存储引擎的统计信息
+func (s *Server) handleMetrics(writer http.ResponseWriter, request *http.Request) {
+	if !s.allow(writer, request, auth.PermRead, nil) {
+		return
+	}
+	writer.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(writer, s.db.Metrics())
+}
+
+func writeMetrics(w io.Writer, m *bitcask.Metrics) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	operations := []bitcask.Operation{bitcask.OpPut, bitcask.OpGet, bitcask.OpDelete,
+		bitcask.OpBatchCommit, bitcask.OpSync, bitcask.OpMerge}
+	writeHeader(bw, "bitcask_operation_duration_seconds", "histogram", "Latency of engine operations.")
+	for _, op := range operations {
+		h := m.Latencies[op]
+		for i, bound := range bitcask.LatencyBuckets {
+			le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+			fmt.Fprintf(bw, "bitcask_operation_duration_seconds_bucket{op=%q,le=%q} %d\n", op, le, h.Counts[i])
+		}
+		fmt.Fprintf(bw, "bitcask_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.Count)
+		fmt.Fprintf(bw, "bitcask_operation_duration_seconds_sum{op=%q} %g\n", op, h.Sum.Seconds())
+		fmt.Fprintf(bw, "bitcask_operation_duration_seconds_count{op=%q} %d\n", op, h.Count)
+	}
+	writeHeader(bw, "bitcask_operation_errors_total", "counter", "Number of failed engine operations.")
+	for _, op := range operations {
+		fmt.Fprintf(bw, "bitcask_operation_errors_total{op=%q} %d\n", op, m.Errors[op])
+	}
+
+	writeMetric(bw, "bitcask_written_bytes_total", "counter", "Bytes appended to data files.", m.BytesWritten)
+	writeMetric(bw, "bitcask_merge_reclaimed_bytes_total", "counter", "Bytes of data files reclaimed by merge.", m.MergeReclaimedBytes)
+	writeMetric(bw, "bitcask_cache_hits_total", "counter", "Value cache hits.", m.CacheHits)
+	writeMetric(bw, "bitcask_cache_misses_total", "counter", "Value cache misses.", m.CacheMisses)
+	writeMetric(bw, "bitcask_dropped_events_total", "counter", "Watch events dropped because of full buffers.", m.DroppedEvents)
+	writeMetric(bw, "bitcask_dropped_listener_events_total", "counter", "Lifecycle events dropped because of a full listener queue.", m.DroppedListenerEvents)
+	writeMetric(bw, "bitcask_keys", "gauge", "Number of keys in the index.", m.KeyNum)
+	writeMetric(bw, "bitcask_data_files", "gauge", "Number of data files.", m.DataFileNum)
+	writeMetric(bw, "bitcask_data_file_bytes", "gauge", "Total size of data files.", m.DataFileSize)
+	writeMetric(bw, "bitcask_reclaimable_bytes", "gauge", "Bytes of stale data that merge can reclaim.", m.ReclaimableSize)
+	var diskFull uint
+	if m.DiskFull {
+		diskFull = 1
+	}
+	writeMetric(bw, "bitcask_disk_full", "gauge", "Whether writes are rejected because of low disk space.", diskFull)
+}
+
+func writeHeader(w io.Writer, name string, typ string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeMetric[T uint | uint64 | int64](w io.Writer, name string, typ string, help string, value T) {
+	writeHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
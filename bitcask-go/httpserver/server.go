@@ -0,0 +1,412 @@
+package httpserver
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/auth"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Options HTTP 服务的配置项
+type Options struct {
+	// 监听的地址
+	Addr string
+	// 范围查询默认返回的数量
+	ScanLimit int
+	// 范围查询一次最多返回的数量
+	MaxScanLimit int
+	// 不为空时请求需要通过 Authorization 头认证, 并按照用户的权限检查
+	Auth *auth.Authenticator
+	// 不为空时使用 HTTPS
+	TLSConfig *tls.Config
+}
+
+var DefaultOptions = Options{
+	Addr:         ":8080",
+	ScanLimit:    100,
+	MaxScanLimit: 1000,
+}
+
+// Server 提供 RESTful 接口的 HTTP 服务
+//
+//	PUT    /keys/{key}    写入, 请求体为 value
+//	GET    /keys/{key}    读取, 响应体为 value, 不存在时返回 404
+//	DELETE /keys/{key}    删除
+//	GET    /keys          范围查询, 参数 prefix, start, end, reverse, limit, cursor
+//	POST   /batch         通过 WriteBatch 原子地执行一组写入和删除
+//	GET    /stat          数据库的统计信息
+//	GET    /metrics       Prometheus 格式的操作计数和延迟等统计信息
+//	GET    /export        导出数据, 参数 format, prefix
+//	POST   /import        导入数据, 参数 prefix
+//	POST   /admin/merge   执行 merge
+//	POST   /admin/backup  备份到参数 dir 指定的目录, 指定 parent 时为增量备份
+//
+// 开启认证之后, 读写 key 需要 read/write 权限且 key 在用户可以访问的前缀中,
+// 范围查询只返回用户可以访问的 key, 导入导出和 /admin 接口需要 admin 权限
+type Server struct {
+	db      *bitcask.DB
+	options Options
+	server  *http.Server
+}
+
+// New 创建 HTTP 服务, 数据库由调用方打开和关闭
+func New(db *bitcask.DB, options Options) *Server {
+	if options.ScanLimit <= 0 {
+		options.ScanLimit = DefaultOptions.ScanLimit
+	}
+	if options.MaxScanLimit <= 0 {
+		options.MaxScanLimit = DefaultOptions.MaxScanLimit
+	}
+	s := &Server{db: db, options: options}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /keys/{key...}", s.handlePut)
+	mux.HandleFunc("GET /keys/{key...}", s.handleGet)
+	mux.HandleFunc("DELETE /keys/{key...}", s.handleDelete)
+	mux.HandleFunc("GET /keys", s.handleScan)
+	mux.HandleFunc("POST /batch", s.handleBatch)
+	mux.HandleFunc("GET /stat", s.handleStat)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /export", s.handleExport)
+	mux.HandleFunc("POST /import", s.handleImport)
+	mux.HandleFunc("POST /admin/merge", s.handleMerge)
+	mux.HandleFunc("POST /admin/backup", s.handleBackup)
+	var handler http.Handler = mux
+	if options.Auth != nil {
+		handler = s.authenticate(mux)
+	}
+	s.server = &http.Server{Addr: options.Addr, Handler: handler, TLSConfig: options.TLSConfig}
+	return s
+}
+
+// Handler 返回处理请求的 http.Handler
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// ListenAndServe 监听配置的地址, 直到 Shutdown 被调用
+func (s *Server) ListenAndServe() error {
+	var err error
+	if s.options.TLSConfig != nil {
+		// 证书已经在 TLSConfig 中
+		err = s.server.ListenAndServeTLS("", "")
+	} else {
+		err = s.server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown 停止接收新的请求, 并等待处理中的请求完成
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// 认证通过的用户保存在请求的 context 中
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		principal, err := s.options.Auth.Authenticate(request.Header.Get("Authorization"))
+		if err != nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="bitcask"`)
+			http.Error(writer, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(writer, request.WithContext(auth.NewContext(request.Context(), principal)))
+	})
+}
+
+// 检查用户是否可以对 key 执行 perm 操作, 没有开启认证时不检查
+func (s *Server) allow(writer http.ResponseWriter, request *http.Request, perm auth.Permission, key []byte) bool {
+	if s.options.Auth == nil {
+		return true
+	}
+	if err := auth.FromContext(request.Context()).Allow(perm, key); err != nil {
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handlePut(writer http.ResponseWriter, request *http.Request) {
+	key := []byte(request.PathValue("key"))
+	if !s.allow(writer, request, auth.PermWrite, key) {
+		return
+	}
+	value, err := io.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.db.PutContext(request.Context(), key, value); err != nil {
+		writeError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGet(writer http.ResponseWriter, request *http.Request) {
+	key := []byte(request.PathValue("key"))
+	if !s.allow(writer, request, auth.PermRead, key) {
+		return
+	}
+	value, err := s.db.GetContext(request.Context(), key)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	writer.Header().Set("content-type", "application/octet-stream")
+	_, _ = writer.Write(value)
+}
+
+func (s *Server) handleDelete(writer http.ResponseWriter, request *http.Request) {
+	key := []byte(request.PathValue("key"))
+	if !s.allow(writer, request, auth.PermWrite, key) {
+		return
+	}
+	if err := s.db.DeleteContext(request.Context(), key); err != nil {
+		writeError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// 范围查询中的一条数据, key 和 value 在 json 中使用 base64 编码
+type scanItem struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+type scanResult struct {
+	Items []scanItem `json:"items"`
+	// 下一页的游标, 为空表示没有更多的数据
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// 遍历 [start, end) 范围内以 prefix 为前缀的 key, 反向遍历时从 end 之前开始
+// 游标为上一页最后一个 key 的 base64url 编码, 下一页从它之后开始
+func (s *Server) handleScan(writer http.ResponseWriter, request *http.Request) {
+	if !s.allow(writer, request, auth.PermRead, nil) {
+		return
+	}
+	query := request.URL.Query()
+	prefix := []byte(query.Get("prefix"))
+	start, end := []byte(query.Get("start")), []byte(query.Get("end"))
+	reverse := query.Get("reverse") == "true"
+	limit := s.options.ScanLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			http.Error(writer, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit > s.options.MaxScanLimit {
+		limit = s.options.MaxScanLimit
+	}
+	var cursor []byte
+	if value := query.Get("cursor"); value != "" {
+		var err error
+		if cursor, err = base64.RawURLEncoding.DecodeString(value); err != nil {
+			http.Error(writer, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result := scanResult{Items: []scanItem{}}
+	prefixes := auth.FromContext(request.Context()).ScanPrefixes(prefix)
+	if len(prefixes) == 0 {
+		writeJSON(writer, result)
+		return
+	}
+	opts := bitcask.ScanOptions{Prefixes: prefixes, Start: start, End: end, After: cursor, Reverse: reverse}
+	err := s.db.Scan(request.Context(), opts, func(key []byte, value []byte) bool {
+		if len(result.Items) == limit {
+			last := result.Items[len(result.Items)-1].Key
+			result.Cursor = base64.RawURLEncoding.EncodeToString(last)
+			return false
+		}
+		result.Items = append(result.Items, scanItem{Key: key, Value: value})
+		return true
+	})
+	// 客户端断开之后不需要返回结果
+	if request.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	writeJSON(writer, result)
+}
+
+const (
+	batchOpPut    = "put"
+	batchOpDelete = "delete"
+)
+
+// 批量操作中的一条操作, key 和 value 在 json 中使用 base64 编码
+type batchOp struct {
+	Op    string `json:"op"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type batchRequest struct {
+	Ops  []batchOp `json:"ops"`
+	Sync bool      `json:"sync"`
+}
+
+func (s *Server) handleBatch(writer http.ResponseWriter, request *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, op := range req.Ops {
+		if !s.allow(writer, request, auth.PermWrite, op.Key) {
+			return
+		}
+	}
+	opts := bitcask.DefaultWriteBatchOptions
+	opts.SyncWrites = req.Sync
+	wb := s.db.NewWriteBatch(opts)
+	for _, op := range req.Ops {
+		var err error
+		switch op.Op {
+		case batchOpPut:
+			err = wb.Put(op.Key, op.Value)
+		case batchOpDelete:
+			err = wb.Delete(op.Key)
+		default:
+			err = errInvalidBatchOp
+		}
+		if err != nil {
+			writeError(writer, err)
+			return
+		}
+	}
+	if err := wb.CommitContext(request.Context()); err != nil {
+		writeError(writer, err)
+		return
+	}
+	writeJSON(writer, map[string]int{"applied": len(req.Ops)})
+}
+
+func (s *Server) handleStat(writer http.ResponseWriter, request *http.Request) {
+	if !s.allow(writer, request, auth.PermRead, nil) {
+		return
+	}
+	writeJSON(writer, s.db.Stat())
+}
+
+func (s *Server) handleExport(writer http.ResponseWriter, request *http.Request) {
+	if !s.allow(writer, request, auth.PermAdmin, nil) {
+		return
+	}
+	formatName := request.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "jsonl"
+	}
+	format, err := bitcask.ParseExportFormat(formatName)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if format == bitcask.ExportBinary {
+		writer.Header().Set("content-type", "application/octet-stream")
+	} else {
+		writer.Header().Set("content-type", "application/x-ndjson")
+	}
+	prefix := []byte(request.URL.Query().Get("prefix"))
+	if err := s.db.ExportWithOptions(writer, bitcask.ExportOptions{Format: format, Prefix: prefix}); err != nil {
+		log.Printf("failed to export db: %v", err)
+	}
+}
+
+func (s *Server) handleImport(writer http.ResponseWriter, request *http.Request) {
+	if !s.allow(writer, request, auth.PermAdmin, nil) {
+		return
+	}
+	opts := bitcask.DefaultImportOptions
+	opts.Prefix = []byte(request.URL.Query().Get("prefix"))
+	n, err := s.db.ImportWithOptions(request.Body, opts)
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	writeJSON(writer, map[string]int{"imported": n})
+}
+
+func (s *Server) handleMerge(writer http.ResponseWriter, request *http.Request) {
+	if !s.allow(writer, request, auth.PermAdmin, nil) {
+		return
+	}
+	if err := s.db.MergeContext(request.Context()); err != nil {
+		writeError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleBackup(writer http.ResponseWriter, request *http.Request) {
+	if !s.allow(writer, request, auth.PermAdmin, nil) {
+		return
+	}
+	dir, parent := request.URL.Query().Get("dir"), request.URL.Query().Get("parent")
+	if dir == "" {
+		http.Error(writer, "backup dir is empty", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if parent == "" {
+		err = s.db.BackupContext(request.Context(), dir)
+	} else {
+		err = s.db.IncrementalBackupContext(request.Context(), dir, parent)
+	}
+	if err != nil {
+		writeError(writer, err)
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+var errInvalidBatchOp = errors.New("invalid batch op, must be put or delete")
+
+// 将存储引擎的错误转换为对应的状态码
+func writeError(writer http.ResponseWriter, err error) {
+	var status int
+	switch {
+	case errors.Is(err, bitcask.ErrKeyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, bitcask.ErrKeyIsEmpty), errors.Is(err, bitcask.ErrExceedMaxBatchNum),
+		errors.Is(err, bitcask.ErrInvalidExportData), errors.Is(err, errInvalidBatchOp):
+		status = http.StatusBadRequest
+	case errors.Is(err, bitcask.ErrWriteOnReplica), errors.Is(err, bitcask.ErrReadOnly):
+		status = http.StatusForbidden
+	case errors.Is(err, bitcask.ErrMergeIsProgress), errors.Is(err, bitcask.ErrMergeRatioUnReached),
+		errors.Is(err, bitcask.ErrDirectoryNotEmpty):
+		status = http.StatusConflict
+	case errors.Is(err, bitcask.ErrNoEnoughSpaceForMerge), errors.Is(err, bitcask.ErrDiskFull):
+		status = http.StatusInsufficientStorage
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusRequestTimeout
+	default:
+		status = http.StatusInternalServerError
+		log.Printf("failed to handle request: %v", err)
+	}
+	http.Error(writer, err.Error(), status)
+}
+
+func writeJSON(writer http.ResponseWriter, v any) {
+	writer.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(writer).Encode(v)
+}
@@ -0,0 +1,209 @@
+package httpserver
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/auth"
+	"bitcask-go/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestServer(t *testing.T) (*bitcask.DB, *httptest.Server) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-httpserver")
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	server := httptest.NewServer(New(db, DefaultOptions).Handler())
+	t.Cleanup(func() {
+		server.Close()
+		_ = db.Close()
+		_ = os.RemoveAll(opts.DirPath)
+	})
+	return db, server
+}
+
+func doRequest(t *testing.T, method string, url string, body []byte) (int, []byte) {
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	assert.Nil(t, err)
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	assert.Nil(t, err)
+	return response.StatusCode, content
+}
+
+func TestServer_Keys(t *testing.T) {
+	_, server := newTestServer(t)
+
+	// key 和 value 都可以是任意的字节
+	key := url.PathEscape("a/b\x00c")
+	value := []byte{0, 1, 2, 0xff}
+	status, _ := doRequest(t, http.MethodPut, server.URL+"/keys/"+key, value)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, body := doRequest(t, http.MethodGet, server.URL+"/keys/"+key, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, value, body)
+
+	status, _ = doRequest(t, http.MethodDelete, server.URL+"/keys/"+key, nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = doRequest(t, http.MethodGet, server.URL+"/keys/"+key, nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = doRequest(t, http.MethodPost, server.URL+"/keys/"+key, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+func TestServer_Scan(t *testing.T) {
+	db, server := newTestServer(t)
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("user:%03d", i)), utils.RandomValue(8)))
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("order:%03d", i)), utils.RandomValue(8)))
+	}
+
+	// 分页遍历所有的 user
+	var keys []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		status, body := doRequest(t, http.MethodGet, server.URL+"/keys?prefix=user:&limit=10&cursor="+cursor, nil)
+		assert.Equal(t, http.StatusOK, status)
+		var result scanResult
+		assert.Nil(t, json.Unmarshal(body, &result))
+		for _, item := range result.Items {
+			keys = append(keys, string(item.Key))
+		}
+		if result.Cursor == "" {
+			assert.Equal(t, 2, pages)
+			break
+		}
+		cursor = result.Cursor
+	}
+	assert.Equal(t, 25, len(keys))
+	assert.Equal(t, "user:000", keys[0])
+	assert.Equal(t, "user:024", keys[24])
+
+	// 反向遍历 [order:010, order:020)
+	status, body := doRequest(t, http.MethodGet, server.URL+"/keys?start=order:010&end=order:020&reverse=true", nil)
+	assert.Equal(t, http.StatusOK, status)
+	var result scanResult
+	assert.Nil(t, json.Unmarshal(body, &result))
+	assert.Equal(t, 10, len(result.Items))
+	assert.Equal(t, "order:019", string(result.Items[0].Key))
+	assert.Equal(t, "order:010", string(result.Items[9].Key))
+}
+
+func TestServer_BatchAndAdmin(t *testing.T) {
+	db, server := newTestServer(t)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+
+	batch, _ := json.Marshal(batchRequest{Ops: []batchOp{
+		{Op: batchOpPut, Key: []byte("k2"), Value: []byte("v2")},
+		{Op: batchOpDelete, Key: []byte("k1")},
+	}})
+	status, _ := doRequest(t, http.MethodPost, server.URL+"/batch", batch)
+	assert.Equal(t, http.StatusOK, status)
+	_, err := db.Get([]byte("k1"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	val, err := db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	invalid, _ := json.Marshal(batchRequest{Ops: []batchOp{{Op: "get", Key: []byte("k2")}}})
+	status, _ = doRequest(t, http.MethodPost, server.URL+"/batch", invalid)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	// 默认的 merge 阈值没有达到
+	status, _ = doRequest(t, http.MethodPost, server.URL+"/admin/merge", nil)
+	assert.Equal(t, http.StatusConflict, status)
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-httpserver-backup")
+	defer os.RemoveAll(backupDir)
+	dir := filepath.Join(backupDir, "full")
+	status, _ = doRequest(t, http.MethodPost, server.URL+"/admin/backup?dir="+url.QueryEscape(dir), nil)
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = doRequest(t, http.MethodPost, server.URL+"/admin/backup?dir="+url.QueryEscape(dir), nil)
+	assert.Equal(t, http.StatusConflict, status)
+
+	status, body := doRequest(t, http.MethodGet, server.URL+"/metrics", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, string(body), "# TYPE bitcask_operation_duration_seconds histogram\n")
+	assert.Contains(t, string(body), "bitcask_operation_duration_seconds_count{op=\"batch_commit\"} 1\n")
+	assert.Contains(t, string(body), "bitcask_operation_duration_seconds_bucket{op=\"put\",le=\"+Inf\"} 1\n")
+	assert.Contains(t, string(body), "bitcask_keys 1\n")
+}
+
+func TestServer_Auth(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-httpserver")
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db.Close()
+		_ = os.RemoveAll(opts.DirPath)
+	}()
+	authenticator, err := auth.New([]auth.User{
+		{Name: "admin", Password: "secret", Permissions: []string{"read", "write", "admin"}},
+		{Name: "user", Tokens: []string{"t1"}, Prefixes: []string{"user:"}, Permissions: []string{"read", "write"}},
+	})
+	assert.Nil(t, err)
+	serverOptions := DefaultOptions
+	serverOptions.Auth = authenticator
+	server := httptest.NewServer(New(db, serverOptions).Handler())
+	defer server.Close()
+
+	call := func(method string, path string, body []byte, setAuth func(*http.Request)) int {
+		request, err := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+		assert.Nil(t, err)
+		if setAuth != nil {
+			setAuth(request)
+		}
+		response, err := http.DefaultClient.Do(request)
+		assert.Nil(t, err)
+		_ = response.Body.Close()
+		return response.StatusCode
+	}
+	token := func(request *http.Request) {
+		request.Header.Set("Authorization", "Bearer t1")
+	}
+	admin := func(request *http.Request) {
+		request.SetBasicAuth("admin", "secret")
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/keys/user:1", nil, nil))
+	assert.Equal(t, http.StatusUnauthorized, call(http.MethodGet, "/stat", nil, func(request *http.Request) {
+		request.SetBasicAuth("admin", "wrong")
+	}))
+	assert.Equal(t, http.StatusNoContent, call(http.MethodPut, "/keys/user:1", []byte("v"), token))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPut, "/keys/order:1", []byte("v"), token))
+	assert.Equal(t, http.StatusNoContent, call(http.MethodPut, "/keys/order:1", []byte("v"), admin))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/admin/merge", nil, token))
+	assert.Equal(t, http.StatusForbidden, call(http.MethodGet, "/export", nil, token))
+
+	// 批量操作中有一个 key 没有权限时全部不执行
+	batch, _ := json.Marshal(batchRequest{Ops: []batchOp{
+		{Op: batchOpPut, Key: []byte("user:2"), Value: []byte("v")},
+		{Op: batchOpDelete, Key: []byte("order:1")},
+	}})
+	assert.Equal(t, http.StatusForbidden, call(http.MethodPost, "/batch", batch, token))
+	_, err = db.Get([]byte("user:2"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	// 范围查询只返回可以访问的 key
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/keys", nil)
+	token(request)
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	var result scanResult
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&result))
+	assert.Equal(t, 1, len(result.Items))
+	assert.Equal(t, []byte("user:1"), result.Items[0].Key)
+}
@@ -26,14 +26,14 @@ func NewART() *AdaptiveRadixTree {
 	}
 }
 
-func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) (*data.LogRecordPos, error) {
 	art.lock.Lock()
 	defer art.lock.Unlock()
 	oldValue, _ := art.tree.Insert(key, pos)
 	if oldValue == nil {
-		return nil
+		return nil, nil
 	}
-	return oldValue.(*data.LogRecordPos)
+	return oldValue.(*data.LogRecordPos), nil
 }
 
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
@@ -46,14 +46,14 @@ func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 	return value.(*data.LogRecordPos)
 }
 
-func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool, error) {
 	art.lock.Lock()
 	defer art.lock.Unlock()
 	oldValue, deleted := art.tree.Delete(key)
 	if oldValue == nil {
-		return nil, false
+		return nil, false, nil
 	}
-	return oldValue.(*data.LogRecordPos), deleted
+	return oldValue.(*data.LogRecordPos), deleted, nil
 }
 
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
@@ -62,6 +62,41 @@ func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	return newARTIterator(art.tree, reverse)
 }
 
+// AscendRange 只遍历 start 和 end 公共前缀下的节点, 跳过小于 start 的 key
+func (art *AdaptiveRadixTree) AscendRange(start []byte, end []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+	// 范围内的 key 都以 start 和 end 的公共前缀开头, end 为空时没有公共前缀
+	var prefix []byte
+	if len(end) > 0 {
+		n := 0
+		for n < len(start) && n < len(end) && start[n] == end[n] {
+			n++
+		}
+		prefix = start[:n]
+	}
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	callback := func(node goart.Node) bool {
+		// ForEachPrefix 也会访问内部节点, 只处理叶子节点
+		if node.Kind() != goart.Leaf {
+			return true
+		}
+		key := node.Key()
+		if bytes.Compare(key, start) < 0 {
+			return true
+		}
+		if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+			return false
+		}
+		return fn(key, node.Value().(*data.LogRecordPos))
+	}
+	// 前缀为空时 ForEachPrefix 不会匹配任何 key
+	if len(prefix) == 0 {
+		art.tree.ForEach(callback)
+		return
+	}
+	art.tree.ForEachPrefix(prefix, callback)
+}
+
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
@@ -8,13 +8,13 @@ import (
 
 func TestAdaptiveRadixTree_Put(t *testing.T) {
 	art := NewART()
-	res := art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	res, _ := art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 1})
 	assert.Nil(t, res)
-	res = art.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	res, _ = art.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 2})
 	assert.Nil(t, res)
-	res = art.Put([]byte("key-3"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	res, _ = art.Put([]byte("key-3"), &data.LogRecordPos{Fid: 1, Offset: 3})
 	assert.Nil(t, res)
-	res = art.Put([]byte("key-3"), &data.LogRecordPos{Fid: 1, Offset: 4})
+	res, _ = art.Put([]byte("key-3"), &data.LogRecordPos{Fid: 1, Offset: 4})
 	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 3}, res)
 }
 
@@ -37,10 +37,10 @@ func TestAdaptiveRadixTree_Get(t *testing.T) {
 func TestAdaptiveRadixTree_Delete(t *testing.T) {
 	art := NewART()
 	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 1})
-	res, ok := art.Delete([]byte("key"))
+	res, ok, _ := art.Delete([]byte("key"))
 	assert.Equal(t, false, ok)
 	assert.Nil(t, res)
-	res, ok = art.Delete([]byte("key-1"))
+	res, ok, _ = art.Delete([]byte("key-1"))
 	assert.Equal(t, true, ok)
 	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 1}, res)
 	pos := art.Get([]byte("key-1"))
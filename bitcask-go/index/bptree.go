@@ -2,54 +2,71 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"fmt"
 	"go.etcd.io/bbolt"
+	"log/slog"
 	"os"
 	"path/filepath"
 )
 
 const bptreeIndexFileName = "bptree-index"
 
-var indexBucketName = []byte("bitcask-bucket")
+var (
+	indexBucketName = []byte("bitcask-bucket")
+	metaBucketName  = []byte("bitcask-meta")
+	checkpointKey   = []byte("checkpoint")
+)
 
 // BPlusTree B+ 树索引
-// 封装 go.etcd.io/bbolt 这个库
+// 封装 go.etcd.io/bbolt 这个库, 写入失败时返回错误, 读取失败时记录日志并当作 key 不存在
 type BPlusTree struct {
-	tree *bbolt.DB
+	tree   *bbolt.DB
+	logger *slog.Logger
 }
 
 func (bpt *BPlusTree) Close() error {
 	return bpt.tree.Close()
 }
 
-func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
+// SetLogger 设置记录读取错误的日志, 默认使用 slog.Default()
+func (bpt *BPlusTree) SetLogger(logger *slog.Logger) {
+	bpt.logger = logger
+}
+
+func openBPlusTree(dirPath string, syncWrites bool) (*BPlusTree, error) {
 	ops := bbolt.DefaultOptions
 	ops.NoSync = !syncWrites
 	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), os.ModePerm, ops)
 	if err != nil {
-		panic("failed to open bptree: " + err.Error())
+		return nil, fmt.Errorf("failed to open bptree: %w", err)
 	}
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
+		if _, err := tx.CreateBucketIfNotExists(indexBucketName); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucketName)
 		return err
 	}); err != nil {
-		panic("failed to create bptree bucket: " + err.Error())
+		_ = bptree.Close()
+		return nil, fmt.Errorf("failed to create bptree bucket: %w", err)
 	}
-	return &BPlusTree{tree: bptree}
+	return &BPlusTree{tree: bptree, logger: slog.Default()}, nil
 }
 
-func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
-	var oldValue []byte
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) (*data.LogRecordPos, error) {
+	var oldPos *data.LogRecordPos
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		oldValue = bucket.Get(key)
+		// 旧的值只在事务内有效, 在事务内解码
+		if oldValue := bucket.Get(key); len(oldValue) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldValue)
+		}
 		return bucket.Put(key, data.EncodeLogRecordPos(pos))
 	}); err != nil {
-		panic("failed to put value to bucket: " + err.Error())
+		return nil, fmt.Errorf("failed to put value to bucket: %w", err)
 	}
-	if len(oldValue) == 0 {
-		return nil
-	}
-	return data.DecodeLogRecordPos(oldValue)
+	return oldPos, nil
 }
 
 func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
@@ -62,26 +79,25 @@ func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
 		}
 		return nil
 	}); err != nil {
-		panic("failed to get value from bucket: " + err.Error())
+		bpt.logger.Error("failed to get value from bucket", "error", err)
+		return nil
 	}
 	return pos
 }
 
-func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
-	var oldValue []byte
+func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool, error) {
+	var oldPos *data.LogRecordPos
 	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket(indexBucketName)
-		if oldValue = bucket.Get(key); len(oldValue) != 0 {
+		if oldValue := bucket.Get(key); len(oldValue) != 0 {
+			oldPos = data.DecodeLogRecordPos(oldValue)
 			return bucket.Delete(key)
 		}
 		return nil
 	}); err != nil {
-		panic("failed to delete value from bucket: " + err.Error())
-	}
-	if len(oldValue) == 0 {
-		return nil, false
+		return nil, false, fmt.Errorf("failed to delete value from bucket: %w", err)
 	}
-	return data.DecodeLogRecordPos(oldValue), true
+	return oldPos, oldPos != nil, nil
 }
 
 func (bpt *BPlusTree) Size() int {
@@ -91,29 +107,92 @@ func (bpt *BPlusTree) Size() int {
 		size = bucket.Stats().KeyN
 		return nil
 	}); err != nil {
-		panic("failed to get size in bucket: " + err.Error())
+		bpt.logger.Error("failed to get size in bucket", "error", err)
+		return 0
 	}
 	return size
 }
 
+// AscendRange 在一个读事务中从 start 开始遍历, 读取失败时记录日志并当作范围内没有数据
+func (bpt *BPlusTree) AscendRange(start []byte, end []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(indexBucketName).Cursor()
+		for key, value := cursor.Seek(start); key != nil; key, value = cursor.Next() {
+			if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+				return nil
+			}
+			if !fn(key, data.DecodeLogRecordPos(value)) {
+				return nil
+			}
+		}
+		return nil
+	}); err != nil {
+		bpt.logger.Error("failed to iterate bucket", "error", err)
+	}
+}
+
+// ApplyBatch 在一个 bbolt 事务中批量更新索引并记录检查点
+func (bpt *BPlusTree) ApplyBatch(entries []*BatchEntry, checkpoint *Checkpoint) ([]*data.LogRecordPos, error) {
+	oldPositions := make([]*data.LogRecordPos, len(entries))
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for i, entry := range entries {
+			if oldValue := bucket.Get(entry.Key); len(oldValue) != 0 {
+				oldPositions[i] = data.DecodeLogRecordPos(oldValue)
+			}
+			var err error
+			if entry.Pos == nil {
+				err = bucket.Delete(entry.Key)
+			} else {
+				err = bucket.Put(entry.Key, data.EncodeLogRecordPos(entry.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if checkpoint == nil {
+			return nil
+		}
+		return tx.Bucket(metaBucketName).Put(checkpointKey, EncodeCheckpoint(checkpoint))
+	}); err != nil {
+		return nil, err
+	}
+	return oldPositions, nil
+}
+
+// Checkpoint 获取最近一次记录的检查点
+func (bpt *BPlusTree) Checkpoint() (*Checkpoint, error) {
+	var checkpoint *Checkpoint
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		if value := tx.Bucket(metaBucketName).Get(checkpointKey); len(value) != 0 {
+			checkpoint = DecodeCheckpoint(value)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		// 无法开启事务时返回空的迭代器
+		bpt.logger.Error("failed to begin transaction", "error", err)
+		return &bptreeIterator{}
+	}
+	return newBptreeIterator(tx, reverse)
 }
 
 type bptreeIterator struct {
 	tx       *bbolt.Tx
-	cursor   *bbolt.Cursor
+	cursor   *bbolt.Cursor // 为 nil 时是空的迭代器
 	reverse  bool
 	curKey   []byte
 	curValue []byte
 }
 
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
-	tx, err := tree.Begin(false)
-	if err != nil {
-		panic("failed to begin transaction: " + err.Error())
-	}
-
+func newBptreeIterator(tx *bbolt.Tx, reverse bool) *bptreeIterator {
 	bpi := &bptreeIterator{
 		tx:      tx,
 		cursor:  tx.Bucket(indexBucketName).Cursor(),
@@ -124,6 +203,9 @@ func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
 }
 
 func (bpi *bptreeIterator) Rewind() {
+	if bpi.cursor == nil {
+		return
+	}
 	if bpi.reverse {
 		bpi.curKey, bpi.curValue = bpi.cursor.Last()
 	} else {
@@ -132,10 +214,25 @@ func (bpi *bptreeIterator) Rewind() {
 }
 
 func (bpi *bptreeIterator) Seek(key []byte) {
+	if bpi.cursor == nil {
+		return
+	}
 	bpi.curKey, bpi.curValue = bpi.cursor.Seek(key)
+	if !bpi.reverse {
+		return
+	}
+	// 反向遍历时找到第一个小于等于 key 的位置
+	if bpi.curKey == nil {
+		bpi.curKey, bpi.curValue = bpi.cursor.Last()
+	} else if bytes.Compare(bpi.curKey, key) > 0 {
+		bpi.curKey, bpi.curValue = bpi.cursor.Prev()
+	}
 }
 
 func (bpi *bptreeIterator) Next() {
+	if bpi.cursor == nil {
+		return
+	}
 	if bpi.reverse {
 		bpi.curKey, bpi.curValue = bpi.cursor.Prev()
 	} else {
@@ -156,5 +253,8 @@ func (bpi *bptreeIterator) Value() *data.LogRecordPos {
 }
 
 func (bpi *bptreeIterator) Close() {
+	if bpi.tx == nil {
+		return
+	}
 	_ = bpi.tx.Rollback()
 }
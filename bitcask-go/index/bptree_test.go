@@ -14,15 +14,20 @@ func TestBPlusTree_Put(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
-	res := tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	tree, err := openBPlusTree(path, false)
+	assert.Nil(t, err)
+	res, err := tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.Nil(t, err)
 	assert.Nil(t, res)
-	res = tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	res, err = tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.Nil(t, err)
 	assert.Nil(t, res)
-	res = tree.Put([]byte("acd"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	res, err = tree.Put([]byte("acd"), &data.LogRecordPos{Fid: 1, Offset: 3})
+	assert.Nil(t, err)
 	assert.Nil(t, res)
 
-	res = tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 4})
+	res, err = tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 4})
+	assert.Nil(t, err)
 	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 1}, res)
 
 }
@@ -33,7 +38,8 @@ func TestBPlusTree_Get(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := openBPlusTree(path, false)
+	assert.Nil(t, err)
 	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
 	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 2})
 	tree.Put([]byte("acd"), &data.LogRecordPos{Fid: 1, Offset: 3})
@@ -50,14 +56,17 @@ func TestBPlusTree_Delete(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := openBPlusTree(path, false)
+	assert.Nil(t, err)
 	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
 	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 2})
 	tree.Put([]byte("acd"), &data.LogRecordPos{Fid: 1, Offset: 3})
-	res, ok := tree.Delete([]byte("not-exist"))
+	res, ok, err := tree.Delete([]byte("not-exist"))
+	assert.Nil(t, err)
 	assert.False(t, ok)
 	assert.Nil(t, res)
-	res, ok = tree.Delete([]byte("abc"))
+	res, ok, err = tree.Delete([]byte("abc"))
+	assert.Nil(t, err)
 	assert.True(t, ok)
 	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 1}, res)
 }
@@ -68,7 +77,8 @@ func TestBPlusTree_Size(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := openBPlusTree(path, false)
+	assert.Nil(t, err)
 	assert.Equal(t, 0, tree.Size())
 	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
 	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 2})
@@ -87,7 +97,8 @@ func TestBPlusTree_Iterator(t *testing.T) {
 	defer func() {
 		_ = os.RemoveAll(path)
 	}()
-	tree := NewBPlusTree(path, false)
+	tree, err := openBPlusTree(path, false)
+	assert.Nil(t, err)
 	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
 	tree.Put([]byte("ade"), &data.LogRecordPos{Fid: 1, Offset: 2})
 	tree.Put([]byte("acd"), &data.LogRecordPos{Fid: 1, Offset: 3})
@@ -98,3 +109,75 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		t.Log(string(iter.Key()))
 	}
 }
+
+func TestBPlusTree_Closed(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-closed")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := openBPlusTree(path, false)
+	assert.Nil(t, err)
+	_, err = tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, tree.Close())
+
+	// 写入失败时返回错误, 不会 panic
+	_, err = tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 2})
+	assert.NotNil(t, err)
+	_, ok, err := tree.Delete([]byte("abc"))
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+
+	// 读取失败时当作 key 不存在
+	assert.Nil(t, tree.Get([]byte("abc")))
+	assert.Equal(t, 0, tree.Size())
+	iter := tree.Iterator(false)
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+	iter.Seek([]byte("abc"))
+	iter.Next()
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestBPlusTree_ApplyBatch(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-batch")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree, err := openBPlusTree(path, false)
+	assert.Nil(t, err)
+	cp, err := tree.Checkpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, cp)
+
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	entries := []*BatchEntry{
+		{Key: []byte("abc"), Pos: &data.LogRecordPos{Fid: 1, Offset: 10}},
+		{Key: []byte("acd"), Pos: &data.LogRecordPos{Fid: 1, Offset: 20}},
+		{Key: []byte("abc")},
+	}
+	oldPositions, err := tree.ApplyBatch(entries, &Checkpoint{Fid: 1, Offset: 30, SeqNo: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(oldPositions))
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 1}, oldPositions[0])
+	assert.Nil(t, oldPositions[1])
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 10}, oldPositions[2])
+
+	assert.Nil(t, tree.Get([]byte("abc")))
+	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 20}, tree.Get([]byte("acd")))
+
+	cp, err = tree.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, &Checkpoint{Fid: 1, Offset: 30, SeqNo: 2}, cp)
+
+	// checkpoint 为 nil 时不更新检查点
+	_, err = tree.ApplyBatch([]*BatchEntry{{Key: []byte("add"), Pos: &data.LogRecordPos{Fid: 2}}}, nil)
+	assert.Nil(t, err)
+	cp, err = tree.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(30), cp.Offset)
+	assert.Equal(t, 2, tree.Size())
+}
@@ -35,19 +35,21 @@ func NewBTree() *BTree {
 	}
 }
 
-func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) (*data.LogRecordPos, error) {
 	it := &Item{key: key, pos: pos}
 	bt.lock.Lock()
 	defer bt.lock.Unlock()
 	oldItem := bt.tree.ReplaceOrInsert(it)
 	if oldItem == nil {
-		return nil
+		return nil, nil
 	}
-	return oldItem.(*Item).pos
+	return oldItem.(*Item).pos, nil
 }
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	btreeItem := bt.tree.Get(it)
 	if btreeItem == nil {
 		return nil
@@ -55,18 +57,34 @@ func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	return btreeItem.(*Item).pos
 }
 
-func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
+func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool, error) {
 	it := &Item{key: key}
 	bt.lock.Lock()
 	defer bt.lock.Unlock()
 	oldItem := bt.tree.Delete(it)
 	if oldItem == nil {
-		return nil, false
+		return nil, false, nil
 	}
-	return oldItem.(*Item).pos, true
+	return oldItem.(*Item).pos, true, nil
+}
+
+func (bt *BTree) AscendRange(start []byte, end []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+	iter := func(it btree.Item) bool {
+		item := it.(*Item)
+		return fn(item.key, item.pos)
+	}
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	if len(end) == 0 {
+		bt.tree.AscendGreaterOrEqual(&Item{key: start}, iter)
+		return
+	}
+	bt.tree.AscendRange(&Item{key: start}, &Item{key: end}, iter)
 }
 
 func (bt *BTree) Size() int {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
 	return bt.tree.Len()
 }
 
@@ -9,28 +9,28 @@ import (
 func TestBtree_Put(t *testing.T) {
 	bt := NewBTree()
 
-	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 0})
+	res1, _ := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 0})
 	assert.Nil(t, res1)
 
-	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 100})
+	res2, _ := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 100})
 	assert.Nil(t, res2)
 
-	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 11, Offset: 200})
+	res3, _ := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 11, Offset: 200})
 	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 100}, res3)
 }
 
 func TestBTree_Get(t *testing.T) {
 	bt := NewBTree()
 
-	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 0})
+	res1, _ := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 0})
 	assert.Nil(t, res1)
 	pos1 := bt.Get(nil)
 	assert.Equal(t, uint32(1), pos1.Fid)
 	assert.Equal(t, int64(0), pos1.Offset)
 
-	res2 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 100})
+	res2, _ := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 100})
 	assert.Nil(t, res2)
-	res3 := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 101})
+	res3, _ := bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 101})
 	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 100}, res3)
 	pos3 := bt.Get([]byte("a"))
 	assert.Equal(t, uint32(2), pos3.Fid)
@@ -39,16 +39,16 @@ func TestBTree_Get(t *testing.T) {
 
 func TestBTree_Delete(t *testing.T) {
 	bt := NewBTree()
-	res1 := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 1})
+	res1, _ := bt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 1})
 	assert.Nil(t, res1)
 
-	pos, res2 := bt.Delete(nil)
+	pos, res2, _ := bt.Delete(nil)
 	assert.True(t, res2)
 	assert.Equal(t, &data.LogRecordPos{Fid: 1, Offset: 1}, pos)
 
-	res3 := bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 2, Offset: 3})
+	res3, _ := bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 2, Offset: 3})
 	assert.Nil(t, res3)
-	pos, res4 := bt.Delete([]byte("aaa"))
+	pos, res4, _ := bt.Delete([]byte("aaa"))
 	assert.True(t, res4)
 	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 3}, pos)
 }
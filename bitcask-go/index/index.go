@@ -3,23 +3,29 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
+	"encoding/binary"
 	"github.com/google/btree"
 )
 
 // Indexer 定义抽象索引接口，方便后续接入其他索引数据结构
 type Indexer interface {
-	// Put 向索引中存储数据对应的位置信息
-	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
+	// Put 向索引中存储数据对应的位置信息, 返回更新之前的位置信息
+	// 持久化的索引写入失败时返回错误, 索引的内容保持不变
+	Put(key []byte, pos *data.LogRecordPos) (*data.LogRecordPos, error)
 
 	// Get 获取索引中数据对应的位置信息
 	Get(key []byte) *data.LogRecordPos
 
-	// Delete 根据 key 删除对应的位置信息
-	Delete(key []byte) (*data.LogRecordPos, bool)
+	// Delete 根据 key 删除对应的位置信息, 写入失败时返回错误
+	Delete(key []byte) (*data.LogRecordPos, bool, error)
 
 	// Iterator 返回迭代器
 	Iterator(reverse bool) Iterator
 
+	// AscendRange 按照 key 的顺序遍历 [start, end) 范围内的数据, end 为空时没有上界, fn 返回 false 时停止
+	// 只访问范围内的数据, 不需要像 Iterator 一样拷贝整个索引; key 只在 fn 中有效, fn 中不能修改索引
+	AscendRange(start []byte, end []byte, fn func(key []byte, pos *data.LogRecordPos) bool)
+
 	// Size 返回索引中有多少条数据
 	Size() int
 
@@ -27,6 +33,62 @@ type Indexer interface {
 	Close() error
 }
 
+// PersistentIndexer 持久化的索引
+// 索引和数据文件分开存储, 需要和数据写入一起记录检查点, 启动时只需要重放检查点之后的数据
+type PersistentIndexer interface {
+	Indexer
+
+	// ApplyBatch 在一个事务中批量更新索引, 同时记录检查点, checkpoint 为 nil 时不更新检查点
+	// 按照 entries 的顺序返回每个 key 更新之前的位置信息
+	ApplyBatch(entries []*BatchEntry, checkpoint *Checkpoint) ([]*data.LogRecordPos, error)
+
+	// Checkpoint 获取最近一次记录的检查点, 不存在时返回 nil
+	Checkpoint() (*Checkpoint, error)
+}
+
+// BatchEntry 批量更新索引的一条数据, Pos 为 nil 表示删除
+type BatchEntry struct {
+	Key []byte
+	Pos *data.LogRecordPos
+}
+
+// Checkpoint 已经应用到索引中的数据位置
+type Checkpoint struct {
+	Fid       uint32 // 最后应用的数据所在的文件 id
+	Offset    int64  // 最后应用的数据在文件中的结束位置
+	SeqNo     uint64 // 事务序列号
+	MergedFid uint32 // 已应用到索引中的 merge 对应的 nonMergeFileId
+}
+
+// EncodeCheckpoint 对检查点进行编码
+func EncodeCheckpoint(cp *Checkpoint) []byte {
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(cp.Fid))
+	index += binary.PutVarint(buf[index:], cp.Offset)
+	index += binary.PutUvarint(buf[index:], cp.SeqNo)
+	index += binary.PutUvarint(buf[index:], uint64(cp.MergedFid))
+	return buf[:index]
+}
+
+// DecodeCheckpoint 对检查点进行解码
+func DecodeCheckpoint(buf []byte) *Checkpoint {
+	var index = 0
+	fid, n := binary.Uvarint(buf[index:])
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	index += n
+	mergedFid, _ := binary.Uvarint(buf[index:])
+	return &Checkpoint{
+		Fid:       uint32(fid),
+		Offset:    offset,
+		SeqNo:     seqNo,
+		MergedFid: uint32(mergedFid),
+	}
+}
+
 type IndexType = int8
 
 const (
@@ -38,19 +100,18 @@ const (
 
 	// BPTree B+ 树索引
 	BPTree
+
+	// SkipList 并发跳表索引
+	SkipList
 )
 
-func NewIndexer(typ IndexType, dirPath string, syncWrites bool) Indexer {
-	switch typ {
-	case Btree:
-		return NewBTree()
-	case ART:
-		return NewART()
-	case BPTree:
-		return NewBPlusTree(dirPath, syncWrites)
-	default:
-		panic("unsupported index type")
+// NewIndexer 根据索引类型创建索引
+func NewIndexer(typ IndexType, dirPath string, syncWrites bool) (Indexer, error) {
+	name, ok := indexTypeNames[typ]
+	if !ok {
+		return nil, ErrUnsupportedIndexType
 	}
+	return NewCustomIndexer(name, dirPath, syncWrites)
 }
 
 type Item struct {
@@ -0,0 +1,43 @@
+package index_test
+
+import (
+	"bitcask-go/index"
+	"bitcask-go/index/indextest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// 所有已注册的索引都需要通过一致性测试
+func TestIndexer_Conformance(t *testing.T) {
+	for _, name := range index.Registered() {
+		t.Run(name, func(t *testing.T) {
+			indextest.TestIndexer(t, func(t *testing.T) index.Indexer {
+				idx, err := index.NewCustomIndexer(name, t.TempDir(), false)
+				assert.Nil(t, err)
+				return idx
+			})
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	assert.Contains(t, index.Registered(), "btree")
+	assert.Contains(t, index.Registered(), "art")
+	assert.Contains(t, index.Registered(), "bptree")
+
+	err := index.Register("btree", func(dirPath string, syncWrites bool) (index.Indexer, error) {
+		return index.NewBTree(), nil
+	})
+	assert.Equal(t, index.ErrIndexerRegistered, err)
+	err = index.Register("", nil)
+	assert.Equal(t, index.ErrInvalidIndexer, err)
+
+	_, err = index.NewCustomIndexer("unknown", t.TempDir(), false)
+	assert.Equal(t, index.ErrUnsupportedIndexType, err)
+	_, err = index.NewIndexer(index.IndexType(100), t.TempDir(), false)
+	assert.Equal(t, index.ErrUnsupportedIndexType, err)
+
+	idx, err := index.NewIndexer(index.ART, t.TempDir(), false)
+	assert.Nil(t, err)
+	assert.IsType(t, &index.AdaptiveRadixTree{}, idx)
+}
@@ -0,0 +1,316 @@
+// Package indextest 提供索引实现的一致性测试, 所有 index.Indexer 的实现都需要通过
+package indextest
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// NewIndexerFunc 为每个测试用例创建一个新的空索引
+type NewIndexerFunc func(t *testing.T) index.Indexer
+
+// TestIndexer 运行索引的一致性测试
+func TestIndexer(t *testing.T, newIndexer NewIndexerFunc) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, idx index.Indexer)
+	}{
+		{"Put", testPut},
+		{"Get", testGet},
+		{"Delete", testDelete},
+		{"Size", testSize},
+		{"Iterator", testIterator},
+		{"IteratorReverse", testIteratorReverse},
+		{"IteratorSeek", testIteratorSeek},
+		{"IteratorSnapshot", testIteratorSnapshot},
+		{"AscendRange", testAscendRange},
+		{"Concurrent", testConcurrent},
+		{"PersistentIndexer", testPersistentIndexer},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			idx := newIndexer(t)
+			defer func() {
+				assert.Nil(t, idx.Close())
+			}()
+			c.fn(t, idx)
+		})
+	}
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("index-test-key-%06d", i))
+}
+
+func pos(i int) *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: uint32(i % 7), Offset: int64(i) * 100, Size: uint32(i)}
+}
+
+func testPut(t *testing.T, idx index.Indexer) {
+	oldPos, err := idx.Put(key(1), pos(1))
+	assert.Nil(t, err)
+	assert.Nil(t, oldPos)
+	oldPos, err = idx.Put(key(2), pos(2))
+	assert.Nil(t, err)
+	assert.Nil(t, oldPos)
+
+	// 重复 Put 返回旧的位置
+	oldPos, err = idx.Put(key(1), pos(10))
+	assert.Nil(t, err)
+	assert.Equal(t, pos(1), oldPos)
+	assert.Equal(t, pos(10), idx.Get(key(1)))
+}
+
+func testGet(t *testing.T, idx index.Indexer) {
+	assert.Nil(t, idx.Get(key(1)))
+
+	idx.Put(key(1), pos(1))
+	assert.Equal(t, pos(1), idx.Get(key(1)))
+	assert.Nil(t, idx.Get(key(2)))
+}
+
+func testDelete(t *testing.T, idx index.Indexer) {
+	oldPos, ok, err := idx.Delete(key(1))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, oldPos)
+
+	idx.Put(key(1), pos(1))
+	oldPos, ok, err = idx.Delete(key(1))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, pos(1), oldPos)
+	assert.Nil(t, idx.Get(key(1)))
+
+	oldPos, ok, err = idx.Delete(key(1))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, oldPos)
+}
+
+func testSize(t *testing.T, idx index.Indexer) {
+	assert.Equal(t, 0, idx.Size())
+	for i := 0; i < 100; i++ {
+		idx.Put(key(i), pos(i))
+	}
+	assert.Equal(t, 100, idx.Size())
+	idx.Put(key(0), pos(1000))
+	assert.Equal(t, 100, idx.Size())
+	for i := 0; i < 50; i++ {
+		idx.Delete(key(i))
+	}
+	assert.Equal(t, 50, idx.Size())
+}
+
+// 乱序写入, 检查迭代器按照 key 的顺序遍历
+func putShuffled(idx index.Indexer, n int) [][]byte {
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		j := (i * 37) % n
+		idx.Put(key(j), pos(j))
+		keys = append(keys, key(j))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return string(keys[i]) < string(keys[j])
+	})
+	return keys
+}
+
+func collect(iter index.Iterator) [][]byte {
+	var keys [][]byte
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, append([]byte{}, iter.Key()...))
+	}
+	return keys
+}
+
+func testIterator(t *testing.T, idx index.Indexer) {
+	iter := idx.Iterator(false)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	keys := putShuffled(idx, 100)
+	iter = idx.Iterator(false)
+	defer iter.Close()
+	iter.Rewind()
+	assert.Equal(t, keys, collect(iter))
+
+	// Rewind 之后可以重新遍历, 且 value 与 key 对应
+	iter.Rewind()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, key(0), iter.Key())
+	assert.Equal(t, pos(0), iter.Value())
+}
+
+func testIteratorReverse(t *testing.T, idx index.Indexer) {
+	iter := idx.Iterator(true)
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	keys := putShuffled(idx, 100)
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	iter = idx.Iterator(true)
+	defer iter.Close()
+	iter.Rewind()
+	assert.Equal(t, keys, collect(iter))
+}
+
+func testIteratorSeek(t *testing.T, idx index.Indexer) {
+	for i := 0; i < 100; i += 10 {
+		idx.Put(key(i), pos(i))
+	}
+
+	iter := idx.Iterator(false)
+	// 正向: 第一个大于等于目标的 key
+	iter.Seek(key(20))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, key(20), iter.Key())
+	iter.Seek(key(25))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, key(30), iter.Key())
+	iter.Next()
+	assert.Equal(t, key(40), iter.Key())
+	iter.Seek(key(95))
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	iter = idx.Iterator(true)
+	defer iter.Close()
+	// 反向: 第一个小于等于目标的 key
+	iter.Seek(key(20))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, key(20), iter.Key())
+	iter.Seek(key(25))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, key(20), iter.Key())
+	iter.Next()
+	assert.Equal(t, key(10), iter.Key())
+	iter.Seek(key(95))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, key(90), iter.Key())
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+}
+
+func ascendRange(idx index.Indexer, start []byte, end []byte, limit int) [][]byte {
+	var keys [][]byte
+	idx.AscendRange(start, end, func(key []byte, p *data.LogRecordPos) bool {
+		keys = append(keys, append([]byte{}, key...))
+		return len(keys) < limit
+	})
+	return keys
+}
+
+func testAscendRange(t *testing.T, idx index.Indexer) {
+	assert.Nil(t, ascendRange(idx, nil, nil, 100))
+
+	for i := 0; i < 100; i += 10 {
+		idx.Put(key(i), pos(i))
+	}
+	idx.Put([]byte("other"), pos(1))
+
+	// [start, end) 范围, start 不需要存在
+	assert.Equal(t, [][]byte{key(20), key(30), key(40)}, ascendRange(idx, key(15), key(50), 100))
+	assert.Equal(t, [][]byte{key(20), key(30)}, ascendRange(idx, key(20), key(40), 100))
+	assert.Nil(t, ascendRange(idx, key(21), key(29), 100))
+
+	// end 为空时没有上界
+	assert.Equal(t, [][]byte{key(90), []byte("other")}, ascendRange(idx, key(85), nil, 100))
+	assert.Equal(t, 11, len(ascendRange(idx, nil, nil, 100)))
+
+	// fn 返回 false 时停止
+	assert.Equal(t, [][]byte{key(0), key(10)}, ascendRange(idx, nil, nil, 2))
+
+	// 传入的位置和 key 对应
+	idx.AscendRange(key(30), key(31), func(k []byte, p *data.LogRecordPos) bool {
+		assert.Equal(t, key(30), k)
+		assert.Equal(t, pos(30), p)
+		return true
+	})
+}
+
+// 迭代器创建之后索引被修改, 迭代器不能出错
+func testIteratorSnapshot(t *testing.T, idx index.Indexer) {
+	for i := 0; i < 10; i++ {
+		idx.Put(key(i), pos(i))
+	}
+	iter := idx.Iterator(false)
+
+	// 持久化的索引在迭代器打开期间可能不允许写入, 在另一个协程中修改
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 10; i < 20; i++ {
+			idx.Put(key(i), pos(i))
+		}
+	}()
+
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.NotNil(t, iter.Key())
+		assert.NotNil(t, iter.Value())
+		count++
+	}
+	assert.GreaterOrEqual(t, count, 10)
+	iter.Close()
+	<-done
+	assert.Equal(t, 20, idx.Size())
+}
+
+func testConcurrent(t *testing.T, idx index.Indexer) {
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * 100; i < (g+1)*100; i++ {
+				idx.Put(key(i), pos(i))
+				assert.Equal(t, pos(i), idx.Get(key(i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 400, idx.Size())
+}
+
+func testPersistentIndexer(t *testing.T, idx index.Indexer) {
+	pi, ok := idx.(index.PersistentIndexer)
+	if !ok {
+		t.Skip("not a persistent indexer")
+	}
+
+	checkpoint, err := pi.Checkpoint()
+	assert.Nil(t, err)
+	assert.Nil(t, checkpoint)
+
+	idx.Put(key(1), pos(1))
+	entries := []*index.BatchEntry{
+		{Key: key(1), Pos: pos(11)},
+		{Key: key(2), Pos: pos(2)},
+		{Key: key(1)},
+	}
+	cp := &index.Checkpoint{Fid: 3, Offset: 300, SeqNo: 2, MergedFid: 1}
+	oldPositions, err := pi.ApplyBatch(entries, cp)
+	assert.Nil(t, err)
+	assert.Equal(t, []*data.LogRecordPos{pos(1), nil, pos(11)}, oldPositions)
+	assert.Nil(t, idx.Get(key(1)))
+	assert.Equal(t, pos(2), idx.Get(key(2)))
+
+	checkpoint, err = pi.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, cp, checkpoint)
+
+	// checkpoint 为 nil 时保留原来的检查点
+	_, err = pi.ApplyBatch([]*index.BatchEntry{{Key: key(3), Pos: pos(3)}}, nil)
+	assert.Nil(t, err)
+	checkpoint, err = pi.Checkpoint()
+	assert.Nil(t, err)
+	assert.Equal(t, cp, checkpoint)
+}
@@ -0,0 +1,82 @@
+package index
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnsupportedIndexType = errors.New("unsupported index type")
+	ErrIndexerRegistered    = errors.New("indexer is already registered")
+	ErrInvalidIndexer       = errors.New("indexer name or factory is empty")
+)
+
+// Factory 创建索引的工厂函数
+// dirPath 为数据目录, 持久化的索引可以将索引文件保存在其中
+type Factory func(dirPath string, syncWrites bool) (Indexer, error)
+
+var (
+	factoriesLock = &sync.RWMutex{}
+	factories     = make(map[string]Factory)
+)
+
+// 内置索引类型对应的注册名称
+var indexTypeNames = map[IndexType]string{
+	Btree:    "btree",
+	ART:      "art",
+	BPTree:   "bptree",
+	SkipList: "skiplist",
+}
+
+func init() {
+	_ = Register(indexTypeNames[Btree], func(dirPath string, syncWrites bool) (Indexer, error) {
+		return NewBTree(), nil
+	})
+	_ = Register(indexTypeNames[ART], func(dirPath string, syncWrites bool) (Indexer, error) {
+		return NewART(), nil
+	})
+	_ = Register(indexTypeNames[BPTree], func(dirPath string, syncWrites bool) (Indexer, error) {
+		return openBPlusTree(dirPath, syncWrites)
+	})
+	_ = Register(indexTypeNames[SkipList], func(dirPath string, syncWrites bool) (Indexer, error) {
+		return NewSkipList(), nil
+	})
+}
+
+// Register 注册自定义的索引实现, 注册之后可以通过 Options.CustomIndexer 使用
+func Register(name string, factory Factory) error {
+	if name == "" || factory == nil {
+		return ErrInvalidIndexer
+	}
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+	if _, ok := factories[name]; ok {
+		return ErrIndexerRegistered
+	}
+	factories[name] = factory
+	return nil
+}
+
+// Registered 返回所有已注册的索引名称
+func Registered() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCustomIndexer 根据注册名称创建索引
+func NewCustomIndexer(name string, dirPath string, syncWrites bool) (Indexer, error) {
+	factoriesLock.RLock()
+	factory, ok := factories[name]
+	factoriesLock.RUnlock()
+	if !ok {
+		return nil, ErrUnsupportedIndexType
+	}
+	return factory(dirPath, syncWrites)
+}
@@ -0,0 +1,260 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	skipListMaxLevel = 24
+	// 节点层数每增加一层的概率
+	skipListProbability = 0.25
+)
+
+// ConcurrentSkipList 并发跳表索引
+// 写入之间通过互斥锁串行执行, 读取和迭代不加锁, 通过原子指针读取最新发布的节点
+type ConcurrentSkipList struct {
+	head  *skipListNode
+	level atomic.Int32 // 当前最高层数
+	size  atomic.Int64 // 数据条数
+	lock  *sync.Mutex  // 写锁
+	rand  *rand.Rand   // 随机层数, 只在持有写锁时使用
+}
+
+type skipListNode struct {
+	key     []byte
+	pos     atomic.Pointer[data.LogRecordPos]
+	deleted atomic.Bool // 节点已经从跳表中删除
+	next    []atomic.Pointer[skipListNode]
+}
+
+func newSkipListNode(key []byte, pos *data.LogRecordPos, level int) *skipListNode {
+	node := &skipListNode{
+		key:  key,
+		next: make([]atomic.Pointer[skipListNode], level),
+	}
+	if pos != nil {
+		node.pos.Store(pos)
+	}
+	return node
+}
+
+func NewSkipList() *ConcurrentSkipList {
+	sl := &ConcurrentSkipList{
+		head: newSkipListNode(nil, nil, skipListMaxLevel),
+		lock: &sync.Mutex{},
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	sl.level.Store(1)
+	return sl
+}
+
+func (sl *ConcurrentSkipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && sl.rand.Float64() < skipListProbability {
+		level++
+	}
+	return level
+}
+
+// 查找第一个大于等于 key 的节点, preds 不为空时记录每一层的前驱节点
+func (sl *ConcurrentSkipList) findGreaterOrEqual(key []byte, preds []*skipListNode) *skipListNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for {
+			next := x.next[i].Load()
+			if next == nil || bytes.Compare(next.key, key) >= 0 {
+				break
+			}
+			x = next
+		}
+		if preds != nil {
+			preds[i] = x
+		}
+	}
+	return x.next[0].Load()
+}
+
+// 查找最后一个小于 key 的节点, 不存在时返回 nil
+func (sl *ConcurrentSkipList) findLessThan(key []byte) *skipListNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for {
+			next := x.next[i].Load()
+			if next == nil || bytes.Compare(next.key, key) >= 0 {
+				break
+			}
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+// 查找最后一个节点, 不存在时返回 nil
+func (sl *ConcurrentSkipList) findLast() *skipListNode {
+	x := sl.head
+	for i := int(sl.level.Load()) - 1; i >= 0; i-- {
+		for next := x.next[i].Load(); next != nil; next = x.next[i].Load() {
+			x = next
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
+
+func (sl *ConcurrentSkipList) Put(key []byte, pos *data.LogRecordPos) (*data.LogRecordPos, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var preds [skipListMaxLevel]*skipListNode
+	node := sl.findGreaterOrEqual(key, preds[:])
+	if node != nil && bytes.Equal(node.key, key) {
+		return node.pos.Swap(pos), nil
+	}
+
+	level := sl.randomLevel()
+	if curLevel := int(sl.level.Load()); level > curLevel {
+		for i := curLevel; i < level; i++ {
+			preds[i] = sl.head
+		}
+		sl.level.Store(int32(level))
+	}
+
+	// 自底向上链接, 节点的后继在发布之前设置好, 读取时不会看到不完整的节点
+	node = newSkipListNode(key, pos, level)
+	for i := 0; i < level; i++ {
+		node.next[i].Store(preds[i].next[i].Load())
+		preds[i].next[i].Store(node)
+	}
+	sl.size.Add(1)
+	return nil, nil
+}
+
+func (sl *ConcurrentSkipList) Get(key []byte) *data.LogRecordPos {
+	node := sl.findGreaterOrEqual(key, nil)
+	if node == nil || node.deleted.Load() || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+func (sl *ConcurrentSkipList) Delete(key []byte) (*data.LogRecordPos, bool, error) {
+	sl.lock.Lock()
+	defer sl.lock.Unlock()
+
+	var preds [skipListMaxLevel]*skipListNode
+	node := sl.findGreaterOrEqual(key, preds[:])
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil, false, nil
+	}
+
+	// 先标记删除, 再自顶向下摘除, 正在访问该节点的读取仍然可以通过它的后继继续遍历
+	node.deleted.Store(true)
+	for i := len(node.next) - 1; i >= 0; i-- {
+		preds[i].next[i].Store(node.next[i].Load())
+	}
+	sl.size.Add(-1)
+	return node.pos.Load(), true, nil
+}
+
+func (sl *ConcurrentSkipList) AscendRange(start []byte, end []byte, fn func(key []byte, pos *data.LogRecordPos) bool) {
+	for node := sl.findGreaterOrEqual(start, nil); node != nil; node = node.next[0].Load() {
+		if len(end) > 0 && bytes.Compare(node.key, end) >= 0 {
+			return
+		}
+		if node.deleted.Load() {
+			continue
+		}
+		if !fn(node.key, node.pos.Load()) {
+			return
+		}
+	}
+}
+
+func (sl *ConcurrentSkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+func (sl *ConcurrentSkipList) Close() error {
+	return nil
+}
+
+func (sl *ConcurrentSkipList) Iterator(reverse bool) Iterator {
+	iter := &skipListIterator{sl: sl, reverse: reverse}
+	iter.Rewind()
+	return iter
+}
+
+// 跳表索引迭代器
+// 直接在跳表上流式遍历, 不需要拷贝全部数据, 可以看到迭代器创建之后写入的数据
+type skipListIterator struct {
+	sl      *ConcurrentSkipList
+	reverse bool          // 是否是反向遍历
+	current *skipListNode // 当前遍历的节点
+}
+
+func (sli *skipListIterator) Rewind() {
+	if sli.reverse {
+		sli.current = sli.sl.findLast()
+	} else {
+		sli.current = sli.sl.head.next[0].Load()
+	}
+	sli.skipDeleted()
+}
+
+func (sli *skipListIterator) Seek(key []byte) {
+	node := sli.sl.findGreaterOrEqual(key, nil)
+	if sli.reverse && (node == nil || !bytes.Equal(node.key, key)) {
+		node = sli.sl.findLessThan(key)
+	}
+	sli.current = node
+	sli.skipDeleted()
+}
+
+func (sli *skipListIterator) Next() {
+	if sli.current == nil {
+		return
+	}
+	if sli.reverse {
+		sli.current = sli.sl.findLessThan(sli.current.key)
+	} else {
+		sli.current = sli.current.next[0].Load()
+	}
+	sli.skipDeleted()
+}
+
+// 跳过并发删除的节点
+func (sli *skipListIterator) skipDeleted() {
+	for sli.current != nil && sli.current.deleted.Load() {
+		if sli.reverse {
+			sli.current = sli.sl.findLessThan(sli.current.key)
+		} else {
+			sli.current = sli.current.next[0].Load()
+		}
+	}
+}
+
+func (sli *skipListIterator) Valid() bool {
+	return sli.current != nil
+}
+
+func (sli *skipListIterator) Key() []byte {
+	return sli.current.key
+}
+
+func (sli *skipListIterator) Value() *data.LogRecordPos {
+	return sli.current.pos.Load()
+}
+
+func (sli *skipListIterator) Close() {
+	sli.current = nil
+}
@@ -0,0 +1,203 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestSkipList_Put(t *testing.T) {
+	sl := NewSkipList()
+
+	res1, _ := sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 0})
+	assert.Nil(t, res1)
+
+	res2, _ := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 100})
+	assert.Nil(t, res2)
+
+	res3, _ := sl.Put([]byte("a"), &data.LogRecordPos{Fid: 11, Offset: 200})
+	assert.Equal(t, &data.LogRecordPos{Fid: 2, Offset: 100}, res3)
+	assert.Equal(t, 2, sl.Size())
+}
+
+func TestSkipList_Get(t *testing.T) {
+	sl := NewSkipList()
+
+	sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 0})
+	pos1 := sl.Get(nil)
+	assert.Equal(t, uint32(1), pos1.Fid)
+
+	sl.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 100})
+	sl.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 101})
+	pos2 := sl.Get([]byte("a"))
+	assert.Equal(t, int64(101), pos2.Offset)
+
+	assert.Nil(t, sl.Get([]byte("not exist")))
+}
+
+func TestSkipList_Delete(t *testing.T) {
+	sl := NewSkipList()
+
+	sl.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 100})
+	sl.Put([]byte("b"), &data.LogRecordPos{Fid: 2, Offset: 200})
+	oldPos, ok, _ := sl.Delete([]byte("a"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), oldPos.Offset)
+	assert.Nil(t, sl.Get([]byte("a")))
+	assert.NotNil(t, sl.Get([]byte("b")))
+	assert.Equal(t, 1, sl.Size())
+
+	_, ok, _ = sl.Delete([]byte("a"))
+	assert.False(t, ok)
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := NewSkipList()
+	for _, k := range []string{"ccde", "acee", "eede", "bbcd"} {
+		sl.Put([]byte(k), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+
+	iter1 := sl.Iterator(false)
+	var keys []string
+	for iter1.Rewind(); iter1.Valid(); iter1.Next() {
+		keys = append(keys, string(iter1.Key()))
+	}
+	assert.Equal(t, []string{"acee", "bbcd", "ccde", "eede"}, keys)
+
+	iter2 := sl.Iterator(true)
+	keys = keys[:0]
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		keys = append(keys, string(iter2.Key()))
+	}
+	assert.Equal(t, []string{"eede", "ccde", "bbcd", "acee"}, keys)
+
+	// 迭代过程中删除后继节点, 迭代器跳过已删除的数据
+	iter3 := sl.Iterator(false)
+	iter3.Seek([]byte("bb"))
+	assert.Equal(t, []byte("bbcd"), iter3.Key())
+	sl.Delete([]byte("bbcd"))
+	sl.Delete([]byte("ccde"))
+	iter3.Next()
+	assert.True(t, iter3.Valid())
+	assert.Equal(t, []byte("eede"), iter3.Key())
+	iter3.Close()
+	assert.False(t, iter3.Valid())
+}
+
+func TestSkipList_ConcurrentReadWrite(t *testing.T) {
+	sl := NewSkipList()
+	wg := new(sync.WaitGroup)
+	stop := make(chan struct{})
+
+	// 读取协程在写入的同时遍历, 遍历结果必须始终有序
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			iter := sl.Iterator(false)
+			var prev []byte
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				if prev != nil {
+					assert.Less(t, bytes.Compare(prev, iter.Key()), 0)
+				}
+				prev = iter.Key()
+			}
+			iter.Close()
+		}
+	}()
+
+	writers := new(sync.WaitGroup)
+	for g := 0; g < 4; g++ {
+		writers.Add(1)
+		go func(g int) {
+			defer writers.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%04d", g, i))
+				sl.Put(key, &data.LogRecordPos{Fid: uint32(g), Offset: int64(i)})
+				if i%2 == 0 {
+					sl.Delete(key)
+				}
+			}
+		}(g)
+	}
+	writers.Wait()
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, 2000, sl.Size())
+}
+
+func benchmarkKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("bench-key-%09d", rand.Intn(n*10)))
+	}
+	return keys
+}
+
+func benchmarkPut(b *testing.B, idx Indexer) {
+	keys := benchmarkKeys(b.N)
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		idx.Put(keys[i], pos)
+	}
+}
+
+func benchmarkGet(b *testing.B, idx Indexer) {
+	keys := benchmarkKeys(100000)
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	for _, key := range keys {
+		idx.Put(key, pos)
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			idx.Get(keys[i%len(keys)])
+			i++
+		}
+	})
+}
+
+func BenchmarkSkipList_Put(b *testing.B) {
+	benchmarkPut(b, NewSkipList())
+}
+
+func BenchmarkBTree_Put(b *testing.B) {
+	benchmarkPut(b, NewBTree())
+}
+
+func BenchmarkSkipList_Get(b *testing.B) {
+	benchmarkGet(b, NewSkipList())
+}
+
+func BenchmarkBTree_Get(b *testing.B) {
+	benchmarkGet(b, NewBTree())
+}
+
+func BenchmarkSkipList_Iterator(b *testing.B) {
+	sl := NewSkipList()
+	pos := &data.LogRecordPos{Fid: 1, Offset: 100}
+	for _, key := range benchmarkKeys(10000) {
+		sl.Put(key, pos)
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		iter := sl.Iterator(false)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+		}
+		iter.Close()
+	}
+}
@@ -3,6 +3,9 @@ package bitcask_go
 import (
 	"bitcask-go/index"
 	"bytes"
+	"context"
+	"errors"
+	"slices"
 )
 
 type Iterator struct {
@@ -67,3 +70,101 @@ func (it *Iterator) skipToNext() {
 		}
 	}
 }
+
+// Scan 按顺序遍历 [Start, End) 范围内以 Prefixes 中任意一个为前缀的 key, fn 返回 false 时结束遍历
+// 迭代器直接定位到每个前缀的开始位置, 不会逐个检查前缀之外的 key, 遍历过程中被删除的 key 会被跳过
+// 读取 value 时不持有锁, fn 中可以执行耗时的操作, 每条数据之前检查 ctx, 取消时返回 ctx 的错误
+func (db *DB) Scan(ctx context.Context, opts ScanOptions, fn func(key []byte, value []byte) bool) error {
+	prefixes := coverPrefixes(opts.Prefixes)
+	if opts.Reverse {
+		slices.Reverse(prefixes)
+	}
+	iter := db.NewIterator(IteratorOptions{Reverse: opts.Reverse})
+	defer iter.Close()
+	for _, prefix := range prefixes {
+		for opts.seek(iter, prefix); iter.Valid(); iter.Next() {
+			key := iter.Key()
+			if !bytes.HasPrefix(key, prefix) || opts.outOfRange(key) {
+				break
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			value, err := iter.Value()
+			if errors.Is(err, ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !fn(key, value) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// 排序并去掉被其他前缀覆盖的前缀, 为空时返回匹配所有 key 的空前缀
+func coverPrefixes(prefixes [][]byte) [][]byte {
+	if len(prefixes) == 0 {
+		return [][]byte{nil}
+	}
+	sorted := slices.Clone(prefixes)
+	slices.SortFunc(sorted, bytes.Compare)
+	covered := sorted[:1]
+	for _, prefix := range sorted[1:] {
+		if !bytes.HasPrefix(prefix, covered[len(covered)-1]) {
+			covered = append(covered, prefix)
+		}
+	}
+	return covered
+}
+
+// 把迭代器定位到 prefix 范围内第一个需要遍历的 key
+func (opts *ScanOptions) seek(iter *Iterator, prefix []byte) {
+	if !opts.Reverse {
+		// 正向遍历从 prefix, Start 和 After 中最大的位置开始
+		from := prefix
+		if bytes.Compare(opts.Start, from) > 0 {
+			from = opts.Start
+		}
+		if opts.After != nil && bytes.Compare(opts.After, from) >= 0 {
+			iter.Seek(opts.After)
+			if iter.Valid() && bytes.Equal(iter.Key(), opts.After) {
+				iter.Next()
+			}
+			return
+		}
+		if len(from) == 0 {
+			iter.Rewind()
+		} else {
+			iter.Seek(from)
+		}
+		return
+	}
+
+	// 反向遍历从 prefix 的上界, End 和 After 中最小的位置之前开始, 它们都不包含在范围内
+	to := prefixEnd(prefix)
+	for _, bound := range [][]byte{opts.End, opts.After} {
+		if len(bound) > 0 && (to == nil || bytes.Compare(bound, to) < 0) {
+			to = bound
+		}
+	}
+	if to == nil {
+		iter.Rewind()
+		return
+	}
+	iter.Seek(to)
+	if iter.Valid() && bytes.Equal(iter.Key(), to) {
+		iter.Next()
+	}
+}
+
+// 超出 [Start, End) 范围的 key 不需要继续遍历
+func (opts *ScanOptions) outOfRange(key []byte) bool {
+	if opts.Reverse {
+		return len(opts.Start) > 0 && bytes.Compare(key, opts.Start) < 0
+	}
+	return len(opts.End) > 0 && bytes.Compare(key, opts.End) >= 0
+}
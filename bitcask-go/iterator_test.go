@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bitcask-go/utils"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -109,3 +110,49 @@ func TestDb_Iterator_Multi_Value(t *testing.T) {
 		t.Log("key = ", string(iter1.Key()))
 	}
 }
+
+func TestDB_Scan(t *testing.T) {
+	opts := DefaultOptions
+	db := openTestDB(t, "bitcask-go-scan", opts)
+	defer destroyDB(db)
+
+	for _, key := range []string{"a", "b1", "b2", "b3", "c1", "c2", "c\xff", "d"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("v-"+key)))
+	}
+	scan := func(opts ScanOptions) []string {
+		var keys []string
+		err := db.Scan(context.Background(), opts, func(key []byte, value []byte) bool {
+			assert.Equal(t, "v-"+string(key), string(value))
+			keys = append(keys, string(key))
+			return true
+		})
+		assert.Nil(t, err)
+		return keys
+	}
+
+	assert.Equal(t, []string{"a", "b1", "b2", "b3", "c1", "c2", "c\xff", "d"}, scan(ScanOptions{}))
+	// 多个前缀按顺序遍历, 被覆盖的前缀不会重复遍历
+	prefixes := [][]byte{[]byte("c"), []byte("b"), []byte("b2")}
+	assert.Equal(t, []string{"b1", "b2", "b3", "c1", "c2", "c\xff"}, scan(ScanOptions{Prefixes: prefixes}))
+	assert.Equal(t, []string{"c\xff", "c2", "c1", "b3", "b2", "b1"}, scan(ScanOptions{Prefixes: prefixes, Reverse: true}))
+	assert.Equal(t, []string{"b2", "b3", "c1"}, scan(ScanOptions{Prefixes: prefixes, Start: []byte("b2"), End: []byte("c2")}))
+	assert.Equal(t, []string{"c1", "b3", "b2"}, scan(ScanOptions{Prefixes: prefixes, Start: []byte("b2"), End: []byte("c2"), Reverse: true}))
+	// 从上一页的最后一个 key 之后继续
+	assert.Equal(t, []string{"c1", "c2", "c\xff"}, scan(ScanOptions{Prefixes: prefixes, After: []byte("b3")}))
+	assert.Equal(t, []string{"b2", "b1"}, scan(ScanOptions{Prefixes: prefixes, After: []byte("b3"), Reverse: true}))
+	assert.Equal(t, []string{"d", "c\xff", "c2"}, scan(ScanOptions{After: []byte("d\x00"), Start: []byte("c2"), Reverse: true}))
+
+	// fn 返回 false 时结束遍历
+	var n int
+	assert.Nil(t, db.Scan(context.Background(), ScanOptions{}, func([]byte, []byte) bool {
+		n++
+		return n < 3
+	}))
+	assert.Equal(t, 3, n)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.Scan(ctx, ScanOptions{}, func([]byte, []byte) bool {
+		return true
+	}))
+}
@@ -0,0 +1,129 @@
+package kvgrpc
+
+import (
+	"context"
+	"encoding/base64"
+	"google.golang.org/grpc"
+	"io"
+)
+
+// Client gRPC 服务的客户端, 服务端返回的存储引擎错误会还原为 bitcask 包中的错误
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Dial 创建连接到 target 的客户端, opts 用于配置 TLS 等
+func Dial(target string, opts ...grpc.DialOption) (*Client, error) {
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient 使用已有的连接创建客户端
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn}
+}
+
+// Close 关闭客户端的连接
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// WithToken 每个请求都使用 token 认证
+func WithToken(token string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(authorization("Bearer " + token))
+}
+
+// WithPassword 每个请求都使用用户名和密码认证
+func WithPassword(name string, password string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(authorization("Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+password))))
+}
+
+// 通过 authorization 元数据发送认证信息
+type authorization string
+
+func (a authorization) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": string(a)}, nil
+}
+
+// 是否使用 TLS 由调用方决定, 生产环境中应该和 TLS 一起使用
+func (a authorization) RequireTransportSecurity() bool {
+	return false
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req message, resp message) error {
+	err := c.conn.Invoke(ctx, "/"+serviceName+"/"+method, req, resp, grpc.ForceCodec(codec{}))
+	if err != nil {
+		return fromStatus(err)
+	}
+	return nil
+}
+
+func (c *Client) Put(ctx context.Context, key []byte, value []byte) error {
+	return c.invoke(ctx, "Put", &PutRequest{Key: key, Value: value}, &PutResponse{})
+}
+
+func (c *Client) Get(ctx context.Context, key []byte) ([]byte, error) {
+	resp := &GetResponse{}
+	if err := c.invoke(ctx, "Get", &GetRequest{Key: key}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (c *Client) Delete(ctx context.Context, key []byte) error {
+	return c.invoke(ctx, "Delete", &DeleteRequest{Key: key}, &DeleteResponse{})
+}
+
+// BatchWrite 在一个 WriteBatch 中原子地执行所有的操作
+func (c *Client) BatchWrite(ctx context.Context, ops []*BatchOp, sync bool) error {
+	return c.invoke(ctx, "BatchWrite", &BatchWriteRequest{Ops: ops, Sync: sync}, &BatchWriteResponse{})
+}
+
+// Scan 遍历数据, fn 返回 false 时结束遍历
+func (c *Client) Scan(ctx context.Context, req *ScanRequest, fn func(key []byte, value []byte) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Scan", grpc.ForceCodec(codec{}))
+	if err != nil {
+		return fromStatus(err)
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return fromStatus(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fromStatus(err)
+	}
+	for {
+		resp := &ScanResponse{}
+		err := stream.RecvMsg(resp)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fromStatus(err)
+		}
+		if !fn(resp.Key, resp.Value) {
+			return nil
+		}
+	}
+}
+
+func (c *Client) Stat(ctx context.Context) (*StatResponse, error) {
+	resp := &StatResponse{}
+	if err := c.invoke(ctx, "Stat", &StatRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Merge(ctx context.Context) error {
+	return c.invoke(ctx, "Merge", &MergeRequest{}, &MergeResponse{})
+}
+
+// Backup 在服务端备份到 dir, parentDir 不为空时为增量备份
+func (c *Client) Backup(ctx context.Context, dir string, parentDir string) error {
+	return c.invoke(ctx, "Backup", &BackupRequest{Dir: dir, ParentDir: parentDir}, &BackupResponse{})
+}
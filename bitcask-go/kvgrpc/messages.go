@@ -0,0 +1,422 @@
+package kvgrpc
+
+import (
+	"bytes"
+	"errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// 消息按照 kv.proto 中的定义使用 protobuf 的编码格式, 不依赖生成的代码
+type message interface {
+	marshal() []byte
+	unmarshal(b []byte) error
+}
+
+var errInvalidFieldType = errors.New("invalid protobuf field type")
+
+type PutRequest struct {
+	Key   []byte
+	Value []byte
+}
+
+func (m *PutRequest) marshal() []byte {
+	b := appendBytes(nil, 1, m.Key)
+	return appendBytes(b, 2, m.Value)
+}
+
+func (m *PutRequest) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		switch num {
+		case 1:
+			m.Key, err = decodeBytes(typ, value)
+		case 2:
+			m.Value, err = decodeBytes(typ, value)
+		}
+		return err
+	})
+}
+
+type PutResponse struct{}
+
+func (m *PutResponse) marshal() []byte {
+	return nil
+}
+
+func (m *PutResponse) unmarshal(b []byte) error {
+	return rangeFields(b, skipField)
+}
+
+type GetRequest struct {
+	Key []byte
+}
+
+func (m *GetRequest) marshal() []byte {
+	return appendBytes(nil, 1, m.Key)
+}
+
+func (m *GetRequest) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		if num == 1 {
+			m.Key, err = decodeBytes(typ, value)
+		}
+		return err
+	})
+}
+
+type GetResponse struct {
+	Value []byte
+}
+
+func (m *GetResponse) marshal() []byte {
+	return appendBytes(nil, 1, m.Value)
+}
+
+func (m *GetResponse) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		if num == 1 {
+			m.Value, err = decodeBytes(typ, value)
+		}
+		return err
+	})
+}
+
+type DeleteRequest struct {
+	Key []byte
+}
+
+func (m *DeleteRequest) marshal() []byte {
+	return appendBytes(nil, 1, m.Key)
+}
+
+func (m *DeleteRequest) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		if num == 1 {
+			m.Key, err = decodeBytes(typ, value)
+		}
+		return err
+	})
+}
+
+type DeleteResponse struct{}
+
+func (m *DeleteResponse) marshal() []byte {
+	return nil
+}
+
+func (m *DeleteResponse) unmarshal(b []byte) error {
+	return rangeFields(b, skipField)
+}
+
+// BatchOpType 批量写入中操作的类型
+type BatchOpType = uint64
+
+const (
+	BatchOpPut BatchOpType = iota
+	BatchOpDelete
+)
+
+type BatchOp struct {
+	Type  BatchOpType
+	Key   []byte
+	Value []byte
+}
+
+func (m *BatchOp) marshal() []byte {
+	b := appendVarint(nil, 1, m.Type)
+	b = appendBytes(b, 2, m.Key)
+	return appendBytes(b, 3, m.Value)
+}
+
+func (m *BatchOp) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		switch num {
+		case 1:
+			m.Type, err = decodeVarint(typ, value)
+		case 2:
+			m.Key, err = decodeBytes(typ, value)
+		case 3:
+			m.Value, err = decodeBytes(typ, value)
+		}
+		return err
+	})
+}
+
+type BatchWriteRequest struct {
+	Ops  []*BatchOp
+	Sync bool
+}
+
+func (m *BatchWriteRequest) marshal() []byte {
+	var b []byte
+	for _, op := range m.Ops {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, op.marshal())
+	}
+	return appendBool(b, 2, m.Sync)
+}
+
+func (m *BatchWriteRequest) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		switch num {
+		case 1:
+			buf, err := decodeBytes(typ, value)
+			if err != nil {
+				return err
+			}
+			op := &BatchOp{}
+			if err := op.unmarshal(buf); err != nil {
+				return err
+			}
+			m.Ops = append(m.Ops, op)
+		case 2:
+			v, err := decodeVarint(typ, value)
+			if err != nil {
+				return err
+			}
+			m.Sync = v != 0
+		}
+		return nil
+	})
+}
+
+type BatchWriteResponse struct {
+	Applied uint32
+}
+
+func (m *BatchWriteResponse) marshal() []byte {
+	return appendVarint(nil, 1, uint64(m.Applied))
+}
+
+func (m *BatchWriteResponse) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == 1 {
+			v, err := decodeVarint(typ, value)
+			m.Applied = uint32(v)
+			return err
+		}
+		return nil
+	})
+}
+
+type ScanRequest struct {
+	Prefix  []byte
+	Reverse bool
+	// 为 0 时不限制数量
+	Limit uint32
+}
+
+func (m *ScanRequest) marshal() []byte {
+	b := appendBytes(nil, 1, m.Prefix)
+	b = appendBool(b, 2, m.Reverse)
+	return appendVarint(b, 3, uint64(m.Limit))
+}
+
+func (m *ScanRequest) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		var v uint64
+		switch num {
+		case 1:
+			m.Prefix, err = decodeBytes(typ, value)
+		case 2:
+			v, err = decodeVarint(typ, value)
+			m.Reverse = v != 0
+		case 3:
+			v, err = decodeVarint(typ, value)
+			m.Limit = uint32(v)
+		}
+		return err
+	})
+}
+
+type ScanResponse struct {
+	Key   []byte
+	Value []byte
+}
+
+func (m *ScanResponse) marshal() []byte {
+	b := appendBytes(nil, 1, m.Key)
+	return appendBytes(b, 2, m.Value)
+}
+
+func (m *ScanResponse) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		switch num {
+		case 1:
+			m.Key, err = decodeBytes(typ, value)
+		case 2:
+			m.Value, err = decodeBytes(typ, value)
+		}
+		return err
+	})
+}
+
+type StatRequest struct{}
+
+func (m *StatRequest) marshal() []byte {
+	return nil
+}
+
+func (m *StatRequest) unmarshal(b []byte) error {
+	return rangeFields(b, skipField)
+}
+
+type StatResponse struct {
+	KeyNum          uint64
+	DataFileNum     uint64
+	ReclaimableSize int64
+	DiskSize        int64
+}
+
+func (m *StatResponse) marshal() []byte {
+	b := appendVarint(nil, 1, m.KeyNum)
+	b = appendVarint(b, 2, m.DataFileNum)
+	b = appendVarint(b, 3, uint64(m.ReclaimableSize))
+	return appendVarint(b, 4, uint64(m.DiskSize))
+}
+
+func (m *StatResponse) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) (err error) {
+		var v uint64
+		switch num {
+		case 1:
+			m.KeyNum, err = decodeVarint(typ, value)
+		case 2:
+			m.DataFileNum, err = decodeVarint(typ, value)
+		case 3:
+			v, err = decodeVarint(typ, value)
+			m.ReclaimableSize = int64(v)
+		case 4:
+			v, err = decodeVarint(typ, value)
+			m.DiskSize = int64(v)
+		}
+		return err
+	})
+}
+
+type MergeRequest struct{}
+
+func (m *MergeRequest) marshal() []byte {
+	return nil
+}
+
+func (m *MergeRequest) unmarshal(b []byte) error {
+	return rangeFields(b, skipField)
+}
+
+type MergeResponse struct{}
+
+func (m *MergeResponse) marshal() []byte {
+	return nil
+}
+
+func (m *MergeResponse) unmarshal(b []byte) error {
+	return rangeFields(b, skipField)
+}
+
+type BackupRequest struct {
+	Dir string
+	// 不为空时为增量备份
+	ParentDir string
+}
+
+func (m *BackupRequest) marshal() []byte {
+	b := appendBytes(nil, 1, []byte(m.Dir))
+	return appendBytes(b, 2, []byte(m.ParentDir))
+}
+
+func (m *BackupRequest) unmarshal(b []byte) error {
+	return rangeFields(b, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		v, err := decodeBytes(typ, value)
+		switch num {
+		case 1:
+			m.Dir = string(v)
+		case 2:
+			m.ParentDir = string(v)
+		default:
+			return nil
+		}
+		return err
+	})
+}
+
+type BackupResponse struct{}
+
+func (m *BackupResponse) marshal() []byte {
+	return nil
+}
+
+func (m *BackupResponse) unmarshal(b []byte) error {
+	return rangeFields(b, skipField)
+}
+
+// proto3 中的默认值不需要编码
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendVarint(b, num, 1)
+}
+
+// 依次处理消息中的每个字段, value 为字段编码后的值
+func rangeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if err := fn(num, typ, b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// 跳过不认识的字段, 兼容新版本增加的字段
+func skipField(protowire.Number, protowire.Type, []byte) error {
+	return nil
+}
+
+// 接收的缓冲区在解码之后可能被复用, 需要拷贝
+func decodeBytes(typ protowire.Type, value []byte) ([]byte, error) {
+	if typ != protowire.BytesType {
+		return nil, errInvalidFieldType
+	}
+	v, n := protowire.ConsumeBytes(value)
+	if n < 0 {
+		return nil, protowire.ParseError(n)
+	}
+	return bytes.Clone(v), nil
+}
+
+func decodeVarint(typ protowire.Type, value []byte) (uint64, error) {
+	if typ != protowire.VarintType {
+		return 0, errInvalidFieldType
+	}
+	v, n := protowire.ConsumeVarint(value)
+	if n < 0 {
+		return 0, protowire.ParseError(n)
+	}
+	return v, nil
+}
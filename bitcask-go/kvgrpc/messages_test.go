@@ -0,0 +1,204 @@
+package kvgrpc
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	protoMessageRe   = regexp.MustCompile(`^message (\w+) \{(\})?$`)
+	protoEnumRe      = regexp.MustCompile(`^enum (\w+) \{$`)
+	protoEnumValueRe = regexp.MustCompile(`^(\w+) = (\d+);$`)
+	protoFieldRe     = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+var protoScalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+}
+
+// 从 kv.proto 构造消息的描述, 和 protoc 生成的代码使用同样的 protobuf 运行时编解码
+// 只支持 kv.proto 中用到的语法
+func loadKVProto(t *testing.T) protoreflect.FileDescriptor {
+	content, err := os.ReadFile("kv.proto")
+	assert.Nil(t, err)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("kv.proto"),
+		Package: proto.String("bitcask"),
+		Syntax:  proto.String("proto3"),
+	}
+	var stack []*descriptorpb.DescriptorProto
+	var enum *descriptorpb.EnumDescriptorProto
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if m := protoMessageRe.FindStringSubmatch(line); m != nil {
+			msg := &descriptorpb.DescriptorProto{Name: proto.String(m[1])}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.NestedType = append(parent.NestedType, msg)
+			} else {
+				file.MessageType = append(file.MessageType, msg)
+			}
+			if m[2] == "" {
+				stack = append(stack, msg)
+			}
+		} else if m := protoEnumRe.FindStringSubmatch(line); m != nil && len(stack) > 0 {
+			enum = &descriptorpb.EnumDescriptorProto{Name: proto.String(m[1])}
+			parent := stack[len(stack)-1]
+			parent.EnumType = append(parent.EnumType, enum)
+		} else if enum != nil {
+			if line == "}" {
+				enum = nil
+			} else if m := protoEnumValueRe.FindStringSubmatch(line); m != nil {
+				number, _ := strconv.Atoi(m[2])
+				enum.Value = append(enum.Value, &descriptorpb.EnumValueDescriptorProto{
+					Name:   proto.String(m[1]),
+					Number: proto.Int32(int32(number)),
+				})
+			}
+		} else if m := protoFieldRe.FindStringSubmatch(line); m != nil && len(stack) > 0 {
+			msg := stack[len(stack)-1]
+			number, _ := strconv.Atoi(m[4])
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(m[3]),
+				JsonName: proto.String(m[3]),
+				Number:   proto.Int32(int32(number)),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}
+			if m[1] != "" {
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+			if typ, ok := protoScalarTypes[m[2]]; ok {
+				field.Type = typ.Enum()
+			} else if len(msg.EnumType) > 0 && msg.EnumType[0].GetName() == m[2] {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+				field.TypeName = proto.String(".bitcask." + msg.GetName() + "." + m[2])
+			} else {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String(".bitcask." + m[2])
+			}
+			msg.Field = append(msg.Field, field)
+		} else if line == "}" && len(stack) > 0 {
+			stack = stack[:len(stack)-1]
+		}
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	assert.Nil(t, err)
+	return fd
+}
+
+// 按照字段名称设置动态消息的值, 嵌套的消息使用 map 表示
+func setFields(m protoreflect.Message, values map[string]any) {
+	fields := m.Descriptor().Fields()
+	for name, value := range values {
+		field := fields.ByName(protoreflect.Name(name))
+		switch v := value.(type) {
+		case []byte:
+			m.Set(field, protoreflect.ValueOfBytes(v))
+		case string:
+			m.Set(field, protoreflect.ValueOfString(v))
+		case bool:
+			m.Set(field, protoreflect.ValueOfBool(v))
+		case uint32:
+			m.Set(field, protoreflect.ValueOfUint32(v))
+		case uint64:
+			m.Set(field, protoreflect.ValueOfUint64(v))
+		case int64:
+			m.Set(field, protoreflect.ValueOfInt64(v))
+		case protoreflect.EnumNumber:
+			m.Set(field, protoreflect.ValueOfEnum(v))
+		case []map[string]any:
+			list := m.Mutable(field).List()
+			for _, item := range v {
+				elem := list.NewElement()
+				setFields(elem.Message(), item)
+				list.Append(elem)
+			}
+		}
+	}
+}
+
+func TestMessages_ProtoCompatible(t *testing.T) {
+	fd := loadKVProto(t)
+	tests := []struct {
+		msg    message
+		values map[string]any
+	}{
+		{&PutRequest{Key: []byte("k"), Value: []byte("v")}, map[string]any{"key": []byte("k"), "value": []byte("v")}},
+		{&PutResponse{}, nil},
+		{&GetRequest{Key: []byte("k")}, map[string]any{"key": []byte("k")}},
+		{&GetResponse{Value: []byte("v")}, map[string]any{"value": []byte("v")}},
+		{&DeleteRequest{Key: []byte("k")}, map[string]any{"key": []byte("k")}},
+		{&DeleteResponse{}, nil},
+		{&BatchOp{Type: BatchOpDelete, Key: []byte("k")}, map[string]any{"type": protoreflect.EnumNumber(1), "key": []byte("k")}},
+		{
+			&BatchWriteRequest{
+				Ops: []*BatchOp{
+					{Type: BatchOpPut, Key: []byte("k1"), Value: []byte("v1")},
+					{Type: BatchOpDelete, Key: []byte("k2")},
+				},
+				Sync: true,
+			},
+			map[string]any{
+				"ops": []map[string]any{
+					{"type": protoreflect.EnumNumber(0), "key": []byte("k1"), "value": []byte("v1")},
+					{"type": protoreflect.EnumNumber(1), "key": []byte("k2")},
+				},
+				"sync": true,
+			},
+		},
+		{&BatchWriteResponse{Applied: 3}, map[string]any{"applied": uint32(3)}},
+		{&ScanRequest{Prefix: []byte("p"), Reverse: true, Limit: 10}, map[string]any{"prefix": []byte("p"), "reverse": true, "limit": uint32(10)}},
+		{&ScanResponse{Key: []byte("k"), Value: []byte("v")}, map[string]any{"key": []byte("k"), "value": []byte("v")}},
+		{&StatRequest{}, nil},
+		{
+			&StatResponse{KeyNum: 10, DataFileNum: 2, ReclaimableSize: -1, DiskSize: 1 << 40},
+			map[string]any{"key_num": uint64(10), "data_file_num": uint64(2), "reclaimable_size": int64(-1), "disk_size": int64(1 << 40)},
+		},
+		{&MergeRequest{}, nil},
+		{&MergeResponse{}, nil},
+		{&BackupRequest{Dir: "full", ParentDir: "parent"}, map[string]any{"dir": "full", "parent_dir": "parent"}},
+		{&BackupResponse{}, nil},
+	}
+	for _, tt := range tests {
+		name := reflect.TypeOf(tt.msg).Elem().Name()
+		desc := fd.Messages().ByName(protoreflect.Name(name))
+		assert.NotNil(t, desc, name)
+
+		expected := dynamicpb.NewMessage(desc)
+		setFields(expected, tt.values)
+		encoded, err := proto.MarshalOptions{Deterministic: true}.Marshal(expected)
+		assert.Nil(t, err)
+		// 编码的结果和 protobuf 运行时相同
+		assert.Equal(t, string(encoded), string(tt.msg.marshal()), name)
+
+		// protobuf 运行时可以解码手动编码的消息
+		decoded := dynamicpb.NewMessage(desc)
+		assert.Nil(t, proto.Unmarshal(tt.msg.marshal(), decoded), name)
+		assert.True(t, proto.Equal(expected, decoded), name)
+
+		// 手动编码的消息可以解码 protobuf 运行时编码的消息
+		msg := reflect.New(reflect.TypeOf(tt.msg).Elem()).Interface().(message)
+		assert.Nil(t, msg.unmarshal(encoded), name)
+		assert.Equal(t, tt.msg, msg, name)
+	}
+	// kv.proto 中的每个消息都有对应的实现
+	assert.Equal(t, fd.Messages().Len(), len(tests))
+}
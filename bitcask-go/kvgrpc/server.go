@@ -0,0 +1,410 @@
+package kvgrpc
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/auth"
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+)
+
+const serviceName = "bitcask.KV"
+
+// 使用手动编码的消息, 名称为 proto 保证和其他语言生成的客户端兼容
+type codec struct{}
+
+func (codec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(message)
+	if !ok {
+		return nil, fmt.Errorf("kvgrpc: unexpected message type %T", v)
+	}
+	return m.marshal(), nil
+}
+
+func (codec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(message)
+	if !ok {
+		return fmt.Errorf("kvgrpc: unexpected message type %T", v)
+	}
+	return m.unmarshal(data)
+}
+
+func (codec) Name() string {
+	return "proto"
+}
+
+// 服务需要实现的接口, 和 kv.proto 中的定义一致
+type kvServer interface {
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	BatchWrite(context.Context, *BatchWriteRequest) (*BatchWriteResponse, error)
+	Scan(*ScanRequest, grpc.ServerStream) error
+	Stat(context.Context, *StatRequest) (*StatResponse, error)
+	Merge(context.Context, *MergeRequest) (*MergeResponse, error)
+	Backup(context.Context, *BackupRequest) (*BackupResponse, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*kvServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("Put", kvServer.Put),
+		unaryMethod("Get", kvServer.Get),
+		unaryMethod("Delete", kvServer.Delete),
+		unaryMethod("BatchWrite", kvServer.BatchWrite),
+		unaryMethod("Stat", kvServer.Stat),
+		unaryMethod("Merge", kvServer.Merge),
+		unaryMethod("Backup", kvServer.Backup),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Scan",
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				req := &ScanRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(kvServer).Scan(req, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "kv.proto",
+}
+
+// 构造一元调用的处理函数, 和生成的代码一样支持拦截器
+func unaryMethod[T any, Req interface {
+	*T
+	message
+}, Resp message](name string, call func(kvServer, context.Context, Req) (Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := Req(new(T))
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(kvServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+			return interceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv.(kvServer), ctx, req.(Req))
+			})
+		},
+	}
+}
+
+// Server 提供 gRPC 接口的服务, 数据库由调用方打开和关闭
+type Server struct {
+	db     *bitcask.DB
+	server *grpc.Server
+	auth   *auth.Authenticator
+}
+
+// NewServer 创建 gRPC 服务, opts 用于配置 TLS 和拦截器等
+func NewServer(db *bitcask.DB, opts ...grpc.ServerOption) *Server {
+	return newServer(db, nil, opts)
+}
+
+// NewServerWithAuth 创建开启认证的 gRPC 服务, 客户端通过 authorization 元数据认证
+// 读写 key 需要 read/write 权限且 key 在用户可以访问的前缀中, Scan 只返回用户可以访问的 key,
+// Merge 和 Backup 需要 admin 权限
+func NewServerWithAuth(db *bitcask.DB, authenticator *auth.Authenticator, opts ...grpc.ServerOption) *Server {
+	return newServer(db, authenticator, opts)
+}
+
+func newServer(db *bitcask.DB, authenticator *auth.Authenticator, opts []grpc.ServerOption) *Server {
+	s := &Server{db: db, auth: authenticator}
+	opts = append(opts, grpc.ForceServerCodec(codec{}))
+	if authenticator != nil {
+		opts = append(opts, grpc.ChainUnaryInterceptor(s.authUnary), grpc.ChainStreamInterceptor(s.authStream))
+	}
+	s.server = grpc.NewServer(opts...)
+	s.server.RegisterService(&serviceDesc, s)
+	return s
+}
+
+// 认证通过的用户保存在请求的 context 中
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		authorization = md.Get("authorization")[0]
+	}
+	principal, err := s.auth.Authenticate(authorization)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return auth.NewContext(ctx, principal), nil
+}
+
+func (s *Server) authUnary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authStream(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authServerStream{ServerStream: stream, ctx: ctx})
+}
+
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// 检查用户是否可以对 key 执行 perm 操作, 没有开启认证时不检查
+func (s *Server) allow(ctx context.Context, perm auth.Permission, key []byte) error {
+	if s.auth == nil {
+		return nil
+	}
+	if err := auth.FromContext(ctx).Allow(perm, key); err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+// Serve 在 lis 上处理请求, 直到 Stop 被调用
+func (s *Server) Serve(lis net.Listener) error {
+	return s.server.Serve(lis)
+}
+
+// Stop 等待处理中的请求完成之后停止服务
+func (s *Server) Stop() {
+	s.server.GracefulStop()
+}
+
+func (s *Server) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	if err := s.allow(ctx, auth.PermWrite, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.db.PutContext(ctx, req.Key, req.Value); err != nil {
+		return nil, toStatus(err)
+	}
+	return &PutResponse{}, nil
+}
+
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	if err := s.allow(ctx, auth.PermRead, req.Key); err != nil {
+		return nil, err
+	}
+	value, err := s.db.GetContext(ctx, req.Key)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &GetResponse{Value: value}, nil
+}
+
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	if err := s.allow(ctx, auth.PermWrite, req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.db.DeleteContext(ctx, req.Key); err != nil {
+		return nil, toStatus(err)
+	}
+	return &DeleteResponse{}, nil
+}
+
+func (s *Server) BatchWrite(ctx context.Context, req *BatchWriteRequest) (*BatchWriteResponse, error) {
+	for _, op := range req.Ops {
+		if err := s.allow(ctx, auth.PermWrite, op.Key); err != nil {
+			return nil, err
+		}
+	}
+	opts := bitcask.DefaultWriteBatchOptions
+	opts.SyncWrites = req.Sync
+	wb := s.db.NewWriteBatch(opts)
+	for _, op := range req.Ops {
+		var err error
+		switch op.Type {
+		case BatchOpPut:
+			err = wb.Put(op.Key, op.Value)
+		case BatchOpDelete:
+			err = wb.Delete(op.Key)
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "invalid batch op type %d", op.Type)
+		}
+		if err != nil {
+			return nil, toStatus(err)
+		}
+	}
+	if err := wb.CommitContext(ctx); err != nil {
+		return nil, toStatus(err)
+	}
+	return &BatchWriteResponse{Applied: uint32(len(req.Ops))}, nil
+}
+
+func (s *Server) Scan(req *ScanRequest, stream grpc.ServerStream) error {
+	if err := s.allow(stream.Context(), auth.PermRead, nil); err != nil {
+		return err
+	}
+	prefixes := auth.FromContext(stream.Context()).ScanPrefixes(req.Prefix)
+	if len(prefixes) == 0 {
+		return nil
+	}
+	var sent uint32
+	var sendErr error
+	err := s.db.Scan(stream.Context(), bitcask.ScanOptions{Prefixes: prefixes, Reverse: req.Reverse}, func(key []byte, value []byte) bool {
+		if req.Limit > 0 && sent == req.Limit {
+			return false
+		}
+		// 客户端断开之后发送失败, 结束遍历
+		if sendErr = stream.SendMsg(&ScanResponse{Key: key, Value: value}); sendErr != nil {
+			return false
+		}
+		sent++
+		return true
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	return nil
+}
+
+func (s *Server) Stat(ctx context.Context, _ *StatRequest) (*StatResponse, error) {
+	if err := s.allow(ctx, auth.PermRead, nil); err != nil {
+		return nil, err
+	}
+	stat := s.db.Stat()
+	return &StatResponse{
+		KeyNum:          uint64(stat.KeyNum),
+		DataFileNum:     uint64(stat.DataFileNum),
+		ReclaimableSize: stat.ReclaimableSize,
+		DiskSize:        stat.DiskSize,
+	}, nil
+}
+
+func (s *Server) Merge(ctx context.Context, _ *MergeRequest) (*MergeResponse, error) {
+	if err := s.allow(ctx, auth.PermAdmin, nil); err != nil {
+		return nil, err
+	}
+	if err := s.db.MergeContext(ctx); err != nil {
+		return nil, toStatus(err)
+	}
+	return &MergeResponse{}, nil
+}
+
+func (s *Server) Backup(ctx context.Context, req *BackupRequest) (*BackupResponse, error) {
+	if err := s.allow(ctx, auth.PermAdmin, nil); err != nil {
+		return nil, err
+	}
+	if req.Dir == "" {
+		return nil, status.Error(codes.InvalidArgument, "backup dir is empty")
+	}
+	var err error
+	if req.ParentDir == "" {
+		err = s.db.BackupContext(ctx, req.Dir)
+	} else {
+		err = s.db.IncrementalBackupContext(ctx, req.Dir, req.ParentDir)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &BackupResponse{}, nil
+}
+
+// 错误详情中 ErrorInfo 的 domain, 用于区分其他服务返回的错误详情
+const errorDomain = "bitcask"
+
+// 存储引擎的错误对应的状态码, reason 作为错误详情中稳定的错误标识, 客户端根据它还原为原来的错误
+var errorCodes = []struct {
+	err    error
+	code   codes.Code
+	reason string
+}{
+	{bitcask.ErrKeyIsEmpty, codes.InvalidArgument, "KEY_IS_EMPTY"},
+	{bitcask.ErrKeyNotFound, codes.NotFound, "KEY_NOT_FOUND"},
+	{bitcask.ErrDataFileNotFound, codes.DataLoss, "DATA_FILE_NOT_FOUND"},
+	{bitcask.ErrDataDirectoryCorrupt, codes.DataLoss, "DATA_DIRECTORY_CORRUPT"},
+	{bitcask.ErrExceedMaxBatchNum, codes.InvalidArgument, "EXCEED_MAX_BATCH_NUM"},
+	{bitcask.ErrMergeIsProgress, codes.Aborted, "MERGE_IN_PROGRESS"},
+	{bitcask.ErrMergeRatioUnReached, codes.FailedPrecondition, "MERGE_RATIO_UNREACHED"},
+	{bitcask.ErrNoEnoughSpaceForMerge, codes.ResourceExhausted, "NO_ENOUGH_SPACE_FOR_MERGE"},
+	{bitcask.ErrDiskFull, codes.ResourceExhausted, "DISK_FULL"},
+	{bitcask.ErrWriteOnReplica, codes.FailedPrecondition, "WRITE_ON_REPLICA"},
+	{bitcask.ErrReadOnly, codes.FailedPrecondition, "READ_ONLY"},
+	{bitcask.ErrDirectoryNotEmpty, codes.AlreadyExists, "DIRECTORY_NOT_EMPTY"},
+	{bitcask.ErrBackupCorrupt, codes.DataLoss, "BACKUP_CORRUPT"},
+	{bitcask.ErrInvalidExportData, codes.InvalidArgument, "INVALID_EXPORT_DATA"},
+	{bitcask.ErrNamespaceNotFound, codes.NotFound, "NAMESPACE_NOT_FOUND"},
+	{auth.ErrUnauthenticated, codes.Unauthenticated, "UNAUTHENTICATED"},
+	{auth.ErrPermissionDenied, codes.PermissionDenied, "PERMISSION_DENIED"},
+	{context.Canceled, codes.Canceled, "CANCELED"},
+	{context.DeadlineExceeded, codes.DeadlineExceeded, "DEADLINE_EXCEEDED"},
+}
+
+// 服务端返回的包装之后的错误, 保留服务端的错误信息, 可以通过 errors.Is 判断原来的错误
+type remoteError struct {
+	msg string
+	err error
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.err
+}
+
+func toStatus(err error) error {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			st := status.New(e.code, err.Error())
+			if detailed, err := st.WithDetails(&errdetails.ErrorInfo{Reason: e.reason, Domain: errorDomain}); err == nil {
+				st = detailed
+			}
+			return st.Err()
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func fromStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Domain != errorDomain {
+			continue
+		}
+		for _, e := range errorCodes {
+			if info.Reason != e.reason {
+				continue
+			}
+			if st.Message() == e.err.Error() {
+				return e.err
+			}
+			return &remoteError{msg: st.Message(), err: e.err}
+		}
+	}
+	// 没有错误详情的旧版本服务端, 只能按照错误信息还原
+	for _, e := range errorCodes {
+		if st.Code() == e.code && st.Message() == e.err.Error() {
+			return e.err
+		}
+	}
+	return err
+}
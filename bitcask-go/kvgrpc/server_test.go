@@ -0,0 +1,217 @@
+package kvgrpc
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/auth"
+	"bitcask-go/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestClient(t *testing.T) (*bitcask.DB, *Client) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-grpc")
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := NewServer(db)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	client, err := Dial("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = client.Close()
+		server.Stop()
+		_ = db.Close()
+		_ = os.RemoveAll(opts.DirPath)
+	})
+	return db, client
+}
+
+func TestServer_PutGetDelete(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	assert.Nil(t, client.Put(ctx, []byte("k1"), []byte{0, 1, 0xff}))
+	val, err := client.Get(ctx, []byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 1, 0xff}, val)
+
+	assert.Nil(t, client.Delete(ctx, []byte("k1")))
+	_, err = client.Get(ctx, []byte("k1"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	assert.Equal(t, bitcask.ErrKeyIsEmpty, client.Put(ctx, nil, []byte("v")))
+
+	stat, err := client.Stat(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), stat.KeyNum)
+	assert.True(t, stat.ReclaimableSize > 0)
+	assert.Nil(t, client.Merge(ctx))
+}
+
+func TestServer_BatchWriteAndScan(t *testing.T) {
+	db, client := newTestClient(t)
+	ctx := context.Background()
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("v")))
+
+	var ops []*BatchOp
+	for i := 0; i < 20; i++ {
+		ops = append(ops, &BatchOp{Type: BatchOpPut, Key: []byte(fmt.Sprintf("user:%02d", i)), Value: utils.RandomValue(8)})
+	}
+	ops = append(ops, &BatchOp{Type: BatchOpDelete, Key: []byte("order:1")})
+	assert.Nil(t, client.BatchWrite(ctx, ops, true))
+	_, err := db.Get([]byte("order:1"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	var keys []string
+	err = client.Scan(ctx, &ScanRequest{Prefix: []byte("user:"), Reverse: true, Limit: 5}, func(key []byte, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user:19", "user:18", "user:17", "user:16", "user:15"}, keys)
+
+	// 客户端提前结束遍历
+	keys = nil
+	err = client.Scan(ctx, &ScanRequest{}, func(key []byte, value []byte) bool {
+		keys = append(keys, string(key))
+		return len(keys) < 3
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(keys))
+
+	dir, _ := os.MkdirTemp("", "bitcask-go-grpc-backup")
+	defer os.RemoveAll(dir)
+	assert.Nil(t, client.Backup(ctx, filepath.Join(dir, "full"), ""))
+	assert.Equal(t, bitcask.ErrDirectoryNotEmpty, client.Backup(ctx, filepath.Join(dir, "full"), ""))
+}
+
+func TestMessages(t *testing.T) {
+	req := &BatchWriteRequest{
+		Ops: []*BatchOp{
+			{Type: BatchOpPut, Key: []byte("k1"), Value: []byte("v1")},
+			{Type: BatchOpDelete, Key: []byte("k2")},
+		},
+		Sync: true,
+	}
+	decoded := &BatchWriteRequest{}
+	assert.Nil(t, decoded.unmarshal(req.marshal()))
+	assert.Equal(t, req, decoded)
+
+	stat := &StatResponse{KeyNum: 10, DataFileNum: 2, ReclaimableSize: 100, DiskSize: 1 << 40}
+	decodedStat := &StatResponse{}
+	assert.Nil(t, decodedStat.unmarshal(stat.marshal()))
+	assert.Equal(t, stat, decodedStat)
+
+	// 未知的字段被忽略, 类型错误的字段返回错误
+	buf := protowire.AppendTag((&GetRequest{Key: []byte("k")}).marshal(), 15, protowire.VarintType)
+	buf = protowire.AppendVarint(buf, 1)
+	get := &GetRequest{}
+	assert.Nil(t, get.unmarshal(buf))
+	assert.Equal(t, []byte("k"), get.Key)
+	buf = protowire.AppendTag(nil, 1, protowire.VarintType)
+	buf = protowire.AppendVarint(buf, 1)
+	assert.Equal(t, errInvalidFieldType, (&GetRequest{}).unmarshal(buf))
+}
+
+func TestStatusErrors(t *testing.T) {
+	// 包装之后的错误保留原来的错误信息, 客户端可以通过 errors.Is 判断
+	wrapped := fmt.Errorf("backup dir: %w", bitcask.ErrDirectoryNotEmpty)
+	err := fromStatus(toStatus(wrapped))
+	assert.True(t, errors.Is(err, bitcask.ErrDirectoryNotEmpty))
+	assert.Equal(t, wrapped.Error(), err.Error())
+	assert.Equal(t, bitcask.ErrKeyNotFound, fromStatus(toStatus(bitcask.ErrKeyNotFound)))
+
+	// 没有错误详情时按照错误信息还原
+	assert.Equal(t, bitcask.ErrReadOnly, fromStatus(status.Error(codes.FailedPrecondition, bitcask.ErrReadOnly.Error())))
+	err = fromStatus(status.Error(codes.FailedPrecondition, "wrapped: "+bitcask.ErrReadOnly.Error()))
+	assert.False(t, errors.Is(err, bitcask.ErrReadOnly))
+
+	// 其他的错误
+	err = fromStatus(toStatus(errors.New("unknown")))
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestServer_Auth(t *testing.T) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-grpc")
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+	authenticator, err := auth.New([]auth.User{
+		{Name: "admin", Password: "secret", Permissions: []string{"read", "write", "admin"}},
+		{Name: "user", Tokens: []string{"t1"}, Prefixes: []string{"user:"}, Permissions: []string{"read", "write"}},
+	})
+	assert.Nil(t, err)
+
+	lis := bufconn.Listen(1024 * 1024)
+	server := NewServerWithAuth(db, authenticator)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer func() {
+		server.Stop()
+		_ = db.Close()
+		_ = os.RemoveAll(opts.DirPath)
+	}()
+	dial := func(opts ...grpc.DialOption) *Client {
+		opts = append(opts,
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		client, err := Dial("passthrough:///bufconn", opts...)
+		assert.Nil(t, err)
+		t.Cleanup(func() {
+			_ = client.Close()
+		})
+		return client
+	}
+	ctx := context.Background()
+
+	assert.Equal(t, auth.ErrUnauthenticated, dial().Put(ctx, []byte("user:1"), []byte("v")))
+	assert.Equal(t, auth.ErrUnauthenticated, dial(WithPassword("admin", "wrong")).Put(ctx, []byte("user:1"), []byte("v")))
+
+	user := dial(WithToken("t1"))
+	assert.Nil(t, user.Put(ctx, []byte("user:1"), []byte("v")))
+	assert.Equal(t, auth.ErrPermissionDenied, user.Put(ctx, []byte("order:1"), []byte("v")))
+	assert.Equal(t, auth.ErrPermissionDenied, user.Merge(ctx))
+
+	admin := dial(WithPassword("admin", "secret"))
+	assert.Nil(t, admin.Put(ctx, []byte("order:1"), []byte("v")))
+	val, err := admin.Get(ctx, []byte("user:1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+
+	// Scan 只返回可以访问的 key
+	var keys []string
+	err = user.Scan(ctx, &ScanRequest{}, func(key []byte, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user:1"}, keys)
+	err = dial().Scan(ctx, &ScanRequest{}, func(key []byte, value []byte) bool {
+		return true
+	})
+	assert.Equal(t, auth.ErrUnauthenticated, err)
+}
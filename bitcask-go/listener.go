@@ -0,0 +1,164 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventListener 接收存储引擎的生命周期事件, 用于接入日志和告警
+// 没有配置 EventQueueSize 时在触发事件的协程中同步调用, 可能持有数据库的锁, 不能调用 DB 的方法
+// 配置了 EventQueueSize 时在单独的协程中调用, 可以调用 DB 的方法
+type EventListener interface {
+	// OnFileRotated 活跃文件写满或者 merge, 备份时切换到新的活跃文件
+	OnFileRotated(info FileRotatedInfo)
+	// OnMergeStarted merge 开始处理数据文件
+	OnMergeStarted(info MergeInfo)
+	// OnMergeCompleted merge 结束, 失败时 Err 不为空
+	OnMergeCompleted(info MergeInfo)
+	// OnBackupCompleted 备份结束, 失败时 Err 不为空
+	OnBackupCompleted(info BackupInfo)
+	// OnRecoveryTruncated 启动时截断了活跃文件末尾不完整的记录
+	OnRecoveryTruncated(info TruncatedInfo)
+	// OnSyncError 持久化活跃文件失败
+	OnSyncError(info SyncErrorInfo)
+	// OnDiskFullChanged 磁盘空间不足进入只读状态, 或者空间释放之后恢复写入
+	OnDiskFullChanged(info DiskFullInfo)
+}
+
+// FileRotatedInfo 切换活跃文件的信息
+type FileRotatedInfo struct {
+	FileId    uint32 // 切换下来的文件
+	NewFileId uint32
+	Size      int64 // 切换下来的文件的大小
+}
+
+// MergeInfo merge 的信息
+type MergeInfo struct {
+	Files          int    // 参与 merge 的数据文件数量
+	NonMergeFileId uint32 // 这个 id 及之后的文件没有参与 merge
+	Duration       time.Duration
+	Reclaimed      int64 // merge 减少的数据文件大小
+	Err            error
+}
+
+// BackupInfo 备份的信息
+type BackupInfo struct {
+	Dir       string
+	ParentDir string // 增量备份的上一个备份, 全量备份时为空
+	Files     int    // 拷贝的文件数量, 不包含从上一个备份继承的文件
+	Size      int64  // 拷贝的字节数
+	Duration  time.Duration
+	Err       error
+}
+
+// TruncatedInfo 启动时截断数据文件的信息
+type TruncatedInfo struct {
+	FileId uint32
+	Offset int64 // 截断之后的文件大小
+	Size   int64 // 截断的字节数
+}
+
+// SyncErrorInfo 持久化失败的信息
+type SyncErrorInfo struct {
+	FileId uint32
+	Err    error
+}
+
+// DiskFullInfo 磁盘空间状态变化的信息
+type DiskFullInfo struct {
+	Full      bool   // 为 true 时拒绝写入
+	Available uint64 // 磁盘可用空间, 写入返回 ENOSPC 时为 0
+	Threshold uint64 // 配置的 MinFreeDiskSize
+}
+
+// NoopEventListener 不处理任何事件, 嵌入到结构体中只实现需要的方法
+type NoopEventListener struct{}
+
+func (NoopEventListener) OnFileRotated(FileRotatedInfo)     {}
+func (NoopEventListener) OnMergeStarted(MergeInfo)          {}
+func (NoopEventListener) OnMergeCompleted(MergeInfo)        {}
+func (NoopEventListener) OnBackupCompleted(BackupInfo)      {}
+func (NoopEventListener) OnRecoveryTruncated(TruncatedInfo) {}
+func (NoopEventListener) OnSyncError(SyncErrorInfo)         {}
+func (NoopEventListener) OnDiskFullChanged(DiskFullInfo)    {}
+
+// 异步通知的事件队列, 按照触发的顺序在单独的协程中调用 EventListener
+// 事件通常在持有数据库的锁时触发, 队列已满时丢弃新的事件, 和订阅的缓冲区一样不阻塞写入
+type eventQueue struct {
+	lock    *sync.Mutex
+	events  chan func()
+	closed  bool
+	done    chan struct{}
+	dropped uint64 // 队列已满而丢弃的事件数量
+}
+
+func newEventQueue(size int) *eventQueue {
+	q := &eventQueue{
+		lock:   &sync.Mutex{},
+		events: make(chan func(), size),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(q.done)
+		for fn := range q.events {
+			fn()
+		}
+	}()
+	return q
+}
+
+// 加入队列, 队列已满时丢弃并返回 false
+func (q *eventQueue) push(fn func()) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	// 关闭之后触发的事件直接同步调用
+	if q.closed {
+		fn()
+		return true
+	}
+	select {
+	case q.events <- fn:
+		return true
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+		return false
+	}
+}
+
+// 等待队列中的事件处理完成
+func (q *eventQueue) close() {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.lock.Unlock()
+	<-q.done
+}
+
+func (db *DB) notifyFileRotated(oldFile *data.DataFile, newFile *data.DataFile) {
+	info := FileRotatedInfo{FileId: oldFile.FileId, NewFileId: newFile.FileId, Size: oldFile.WriteOffset}
+	db.logger.Info("data file rotated", "file_id", info.FileId, "new_file_id", info.NewFileId, "size", info.Size)
+	db.notifyListener(func(listener EventListener) {
+		listener.OnFileRotated(info)
+	})
+}
+
+// 通知 EventListener, 没有配置时不做处理
+func (db *DB) notifyListener(fn func(listener EventListener)) {
+	listener := db.options.EventListener
+	if listener == nil {
+		return
+	}
+	if db.eventQueue == nil {
+		fn(listener)
+		return
+	}
+	if !db.eventQueue.push(func() {
+		fn(listener)
+	}) {
+		db.logger.Warn("event listener queue is full, dropping event", "queue_size", db.options.EventQueueSize)
+	}
+}
@@ -0,0 +1,181 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testListener struct {
+	NoopEventListener
+	lock      sync.Mutex
+	rotated   []FileRotatedInfo
+	merges    []MergeInfo
+	backups   []BackupInfo
+	truncated []TruncatedInfo
+}
+
+func (l *testListener) OnFileRotated(info FileRotatedInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.rotated = append(l.rotated, info)
+}
+
+func (l *testListener) OnMergeStarted(info MergeInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.merges = append(l.merges, info)
+}
+
+func (l *testListener) OnMergeCompleted(info MergeInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.merges = append(l.merges, info)
+}
+
+func (l *testListener) OnBackupCompleted(info BackupInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.backups = append(l.backups, info)
+}
+
+func (l *testListener) OnRecoveryTruncated(info TruncatedInfo) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.truncated = append(l.truncated, info)
+}
+
+func TestDB_EventListener(t *testing.T) {
+	listener := &testListener{}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-listener")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.EventListener = listener
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%100), utils.RandomValue(128)))
+	}
+	assert.True(t, len(listener.rotated) > 1)
+	assert.Equal(t, uint32(0), listener.rotated[0].FileId)
+	assert.Equal(t, uint32(1), listener.rotated[0].NewFileId)
+	assert.True(t, listener.rotated[0].Size > 0 && listener.rotated[0].Size <= opts.DataFileSize)
+
+	rotated := len(listener.rotated)
+	assert.Nil(t, db.Merge())
+	assert.Equal(t, rotated+1, len(listener.rotated))
+	assert.Equal(t, 2, len(listener.merges))
+	assert.Equal(t, rotated+1, listener.merges[0].Files)
+	assert.Equal(t, listener.merges[0].NonMergeFileId, listener.merges[1].NonMergeFileId)
+	assert.True(t, listener.merges[1].Reclaimed > 0)
+	assert.Nil(t, listener.merges[1].Err)
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-listener-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.Backup(backupDir))
+	assert.NotNil(t, db.Backup(backupDir))
+	assert.Equal(t, 2, len(listener.backups))
+	assert.Equal(t, backupDir, listener.backups[0].Dir)
+	assert.True(t, listener.backups[0].Files > 0 && listener.backups[0].Size > 0)
+	assert.Nil(t, listener.backups[0].Err)
+	assert.Equal(t, ErrDirectoryNotEmpty, listener.backups[1].Err)
+}
+
+func TestDB_RecoveryTruncated(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-truncate")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Close())
+
+	// 模拟写入一半时崩溃, 末尾为不完整的记录
+	record, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecordKeyWithSeqNo([]byte("k2"), nonTransactionSeqNo), Value: []byte("v2")})
+	file, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(record[:len(record)-1])
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	// 异步通知的事件在关闭之前处理完成
+	listener := &testListener{}
+	opts.EventListener = listener
+	opts.EventQueueSize = 16
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k3"), []byte("v3")))
+	assert.Nil(t, db.Close())
+	assert.Equal(t, 1, len(listener.truncated))
+	assert.Equal(t, int64(len(record)-1), listener.truncated[0].Size)
+
+	// 截断之后写入的数据在重启之后可以读取
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 1, len(listener.truncated))
+}
+
+// 在异步队列中调用 DB 的方法, 开始时阻塞直到 release 被关闭
+type blockingListener struct {
+	NoopEventListener
+	db      *DB
+	release chan struct{}
+	rotated atomic.Int32
+}
+
+func (l *blockingListener) OnFileRotated(FileRotatedInfo) {
+	<-l.release
+	l.db.Stat()
+	l.rotated.Add(1)
+}
+
+func TestDB_EventQueueFull(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-event-queue-full")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024
+	listener := &blockingListener{release: make(chan struct{})}
+	opts.EventListener = listener
+	opts.EventQueueSize = 1
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	listener.db = db
+
+	// 队列已满时丢弃事件, 写入不会等待调用 DB 方法的 listener
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes blocked by the event listener queue")
+	}
+	dropped := db.Metrics().DroppedListenerEvents
+	assert.Greater(t, dropped, uint64(0))
+
+	// 没有丢弃的事件在关闭之前处理完成
+	rotations := db.Stat().DataFileNum - 1
+	close(listener.release)
+	assert.Nil(t, db.Close())
+	assert.Equal(t, rotations, uint(listener.rotated.Load())+uint(dropped))
+}
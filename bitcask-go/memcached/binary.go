@@ -0,0 +1,320 @@
+package memcached
+
+import (
+	"bitcask-go/auth"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	binaryRequestMagic  = 0x80
+	binaryResponseMagic = 0x81
+
+	// magic | opcode | key 长度 | extras 长度 | data type | vbucket/status | body 长度 | opaque | cas
+	// 1 + 1 + 2 + 1 + 1 + 2 + 4 + 4 + 8 = 24
+	binaryHeaderSize = 24
+)
+
+const (
+	opGet     = 0x00
+	opSet     = 0x01
+	opAdd     = 0x02
+	opReplace = 0x03
+	opDelete  = 0x04
+	opIncr    = 0x05
+	opDecr    = 0x06
+	opQuit    = 0x07
+	opGetQ    = 0x09
+	opNoop    = 0x0a
+	opVersion = 0x0b
+	opGetK    = 0x0c
+	opGetKQ   = 0x0d
+
+	opSASLListMechs = 0x20
+	opSASLAuth      = 0x21
+	opSASLStep      = 0x22
+)
+
+// 支持的 SASL 认证机制
+const saslMechanism = "PLAIN"
+
+const (
+	statusOK             = 0x00
+	statusKeyNotFound    = 0x01
+	statusKeyExists      = 0x02
+	statusInvalidArgs    = 0x04
+	statusNotStored      = 0x05
+	statusNonNumeric     = 0x06
+	statusAuthError      = 0x20
+	statusAccessDenied   = 0x24 // memcached 没有定义, 和 Couchbase 一致
+	statusUnknownCommand = 0x81
+	statusInternalError  = 0x84
+)
+
+// incr/decr 的 expiration 为该值时, 数据不存在不会自动创建
+const incrNoCreate = 0xffffffff
+
+var errInvalidMagic = errors.New("invalid binary protocol magic")
+
+// 二进制协议连接上已经认证的用户, 没有开启认证或者还没有认证时为空
+type binarySession struct {
+	principal *auth.Principal
+}
+
+type binaryRequest struct {
+	opcode byte
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+type binaryResponse struct {
+	status uint16
+	cas    uint64
+	extras []byte
+	key    []byte
+	value  []byte
+}
+
+func readBinaryRequest(reader *bufio.Reader) (*binaryRequest, error) {
+	header := make([]byte, binaryHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[0] != binaryRequestMagic {
+		return nil, errInvalidMagic
+	}
+	keyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLen := int(header[4])
+	bodyLen := int(binary.BigEndian.Uint32(header[8:12]))
+	if bodyLen < keyLen+extrasLen || bodyLen > keyLen+extrasLen+maxValueSize {
+		return nil, errors.New("invalid binary protocol body length")
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+	return &binaryRequest{
+		opcode: header[1],
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		cas:    binary.BigEndian.Uint64(header[16:24]),
+		extras: body[:extrasLen],
+		key:    body[extrasLen : extrasLen+keyLen],
+		value:  body[extrasLen+keyLen:],
+	}, nil
+}
+
+func writeBinaryResponse(writer *bufio.Writer, req *binaryRequest, resp *binaryResponse) error {
+	header := make([]byte, binaryHeaderSize)
+	header[0] = binaryResponseMagic
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(resp.key)))
+	header[4] = byte(len(resp.extras))
+	binary.BigEndian.PutUint16(header[6:8], resp.status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(resp.extras)+len(resp.key)+len(resp.value)))
+	binary.BigEndian.PutUint32(header[12:16], req.opaque)
+	binary.BigEndian.PutUint64(header[16:24], resp.cas)
+	_, _ = writer.Write(header)
+	_, _ = writer.Write(resp.extras)
+	_, _ = writer.Write(resp.key)
+	_, err := writer.Write(resp.value)
+	return err
+}
+
+// 处理二进制协议的请求
+func (s *Server) serveBinary(reader *bufio.Reader, writer *bufio.Writer) error {
+	session := &binarySession{}
+	for {
+		req, err := readBinaryRequest(reader)
+		if err != nil {
+			return err
+		}
+		resp := s.handleBinaryRequest(req, session)
+		if resp != nil {
+			err = writeBinaryResponse(writer, req, resp)
+		}
+		if err == nil && req.opcode == opQuit {
+			err = errQuit
+		}
+		// 客户端连续发送的请求处理完之后再一起发送响应
+		if err != nil || reader.Buffered() == 0 {
+			if flushErr := writer.Flush(); err == nil {
+				err = flushErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 返回 nil 时不发送响应
+func (s *Server) handleBinaryRequest(req *binaryRequest, session *binarySession) *binaryResponse {
+	if len(req.key) > maxKeySize {
+		return binaryError(statusInvalidArgs, "key too long")
+	}
+	switch req.opcode {
+	case opSASLListMechs, opSASLAuth, opSASLStep:
+		return s.handleSASL(req, session)
+	case opQuit, opNoop, opVersion:
+		// 不需要认证
+	default:
+		if resp := s.checkBinaryPermission(req, session); resp != nil {
+			return resp
+		}
+	}
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		it, err := s.get(req.key)
+		if err == errNotFound {
+			// quiet 的命令未命中时不返回
+			if req.opcode == opGetQ || req.opcode == opGetKQ {
+				return nil
+			}
+			resp := binaryError(statusKeyNotFound, "Not found")
+			if req.opcode == opGetK {
+				resp.key = req.key
+			}
+			return resp
+		}
+		if err != nil {
+			return binaryError(statusInternalError, err.Error())
+		}
+		resp := &binaryResponse{cas: it.cas, extras: make([]byte, 4), value: it.value}
+		binary.BigEndian.PutUint32(resp.extras, it.flags)
+		if req.opcode == opGetK || req.opcode == opGetKQ {
+			resp.key = req.key
+		}
+		return resp
+
+	case opSet, opAdd, opReplace:
+		if len(req.extras) != 8 || len(req.key) == 0 {
+			return binaryError(statusInvalidArgs, "Invalid arguments")
+		}
+		mode := modeSet
+		if req.opcode == opAdd {
+			mode = modeAdd
+		} else if req.opcode == opReplace {
+			mode = modeReplace
+		}
+		// 请求中带有 cas 时只有 cas 相同才会写入
+		if req.cas != 0 && mode != modeAdd {
+			mode = modeCas
+		}
+		flags := binary.BigEndian.Uint32(req.extras[0:4])
+		exptime := int64(int32(binary.BigEndian.Uint32(req.extras[4:8])))
+		cas, err := s.store(mode, req.key, flags, exptime, req.value, req.cas)
+		if err != nil {
+			return binaryStoreError(err)
+		}
+		return &binaryResponse{cas: cas}
+
+	case opDelete:
+		if err := s.delete(req.key); err != nil {
+			return binaryStoreError(err)
+		}
+		return &binaryResponse{}
+
+	case opIncr, opDecr:
+		// delta | initial | expiration
+		if len(req.extras) != 20 || len(req.key) == 0 {
+			return binaryError(statusInvalidArgs, "Invalid arguments")
+		}
+		delta := binary.BigEndian.Uint64(req.extras[0:8])
+		expiration := binary.BigEndian.Uint32(req.extras[16:20])
+		var initial *uint64
+		if expiration != incrNoCreate {
+			n := binary.BigEndian.Uint64(req.extras[8:16])
+			initial = &n
+		}
+		n, cas, err := s.incr(req.key, delta, req.opcode == opDecr, initial, int64(expiration))
+		if err != nil {
+			return binaryStoreError(err)
+		}
+		resp := &binaryResponse{cas: cas, value: make([]byte, 8)}
+		binary.BigEndian.PutUint64(resp.value, n)
+		return resp
+
+	case opQuit, opNoop:
+		return &binaryResponse{}
+
+	case opVersion:
+		return &binaryResponse{value: []byte(version)}
+	}
+	return binaryError(statusUnknownCommand, "Unknown command")
+}
+
+// SASL PLAIN 认证, 请求的 value 为 authzid \0 authcid \0 passwd, 认证成功之后连接上的请求都以这个用户执行
+func (s *Server) handleSASL(req *binaryRequest, session *binarySession) *binaryResponse {
+	if s.auth == nil {
+		return binaryError(statusUnknownCommand, "Unknown command")
+	}
+	switch req.opcode {
+	case opSASLListMechs:
+		return &binaryResponse{value: []byte(saslMechanism)}
+	case opSASLAuth:
+		if string(req.key) != saslMechanism {
+			return binaryError(statusAuthError, "Unsupported mechanism")
+		}
+		parts := bytes.Split(req.value, []byte{0})
+		if len(parts) != 3 {
+			return binaryError(statusAuthError, "Auth failure")
+		}
+		var principal *auth.Principal
+		var err error
+		if len(parts[1]) == 0 {
+			principal, err = s.auth.Token(string(parts[2]))
+		} else {
+			principal, err = s.auth.Password(string(parts[1]), string(parts[2]))
+		}
+		if err != nil {
+			return binaryError(statusAuthError, "Auth failure")
+		}
+		session.principal = principal
+		return &binaryResponse{value: []byte("Authenticated")}
+	}
+	// PLAIN 只需要一步, 不支持继续认证
+	return binaryError(statusAuthError, "Auth failure")
+}
+
+// 开启认证时检查连接上的用户是否可以执行请求, 可以执行时返回 nil
+func (s *Server) checkBinaryPermission(req *binaryRequest, session *binarySession) *binaryResponse {
+	if s.auth == nil {
+		return nil
+	}
+	if session.principal == nil {
+		return binaryError(statusAuthError, "Auth failure")
+	}
+	perm := auth.PermWrite
+	switch req.opcode {
+	case opGet, opGetQ, opGetK, opGetKQ:
+		perm = auth.PermRead
+	}
+	if err := session.principal.Allow(perm, req.key); err != nil {
+		return binaryError(statusAccessDenied, "Access denied")
+	}
+	return nil
+}
+
+func binaryError(status uint16, msg string) *binaryResponse {
+	return &binaryResponse{status: status, value: []byte(msg)}
+}
+
+func binaryStoreError(err error) *binaryResponse {
+	switch err {
+	case errNotFound:
+		return binaryError(statusKeyNotFound, "Not found")
+	case errExists:
+		return binaryError(statusKeyExists, "Data exists for key")
+	case errNotStored:
+		return binaryError(statusNotStored, "Not stored")
+	case errNonNumeric:
+		return binaryError(statusNonNumeric, "Non-numeric server-side value for incr or decr")
+	}
+	return binaryError(statusInternalError, err.Error())
+}
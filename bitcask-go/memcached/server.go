@@ -0,0 +1,119 @@
+package memcached
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/auth"
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const version = "bitcask-go-1.0"
+
+// Server 兼容 memcached 文本协议和二进制协议的服务, 数据持久化到 DB 中
+// flags 和过期时间与数据一起保存, 过期的数据在读取到时删除, 之后由 merge 回收
+type Server struct {
+	db        *bitcask.DB
+	auth      *auth.Authenticator // 为空时不需要认证
+	lock      *sync.Mutex         // 保证 add/replace/cas/incr 等读后写操作的原子性
+	casUnique uint64
+	connLock  *sync.Mutex
+	listener  net.Listener
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        *sync.WaitGroup
+}
+
+// NewServer 创建 memcached 服务, 数据库由调用方打开和关闭
+func NewServer(db *bitcask.DB) *Server {
+	return &Server{
+		db:   db,
+		lock: &sync.Mutex{},
+		// 重启之后 cas 也不会和之前的重复
+		casUnique: uint64(time.Now().UnixNano()),
+		connLock:  &sync.Mutex{},
+		conns:     make(map[net.Conn]struct{}),
+		wg:        &sync.WaitGroup{},
+	}
+}
+
+// NewServerWithAuth 创建开启认证的 memcached 服务, 只支持二进制协议, 客户端通过 SASL PLAIN 认证
+// 用户名为空时密码作为 token 认证, 文本协议没有认证的命令, 连接之后直接关闭
+func NewServerWithAuth(db *bitcask.DB, authenticator *auth.Authenticator) *Server {
+	s := NewServer(db)
+	s.auth = authenticator
+	return s
+}
+
+// Serve 在 lis 上接收连接, 直到 Close 被调用, 使用 tls.NewListener 包装 lis 即可开启 TLS
+func (s *Server) Serve(lis net.Listener) error {
+	s.connLock.Lock()
+	if s.closed {
+		s.connLock.Unlock()
+		return net.ErrClosed
+	}
+	s.listener = lis
+	s.connLock.Unlock()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			s.connLock.Lock()
+			closed := s.closed
+			s.connLock.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.connLock.Lock()
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.connLock.Unlock()
+		go s.handleConn(conn)
+	}
+}
+
+// Close 停止接收连接并关闭所有的连接
+func (s *Server) Close() error {
+	s.connLock.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.connLock.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// 根据第一个字节判断连接使用的协议
+func (s *Server) handleConn(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+		s.connLock.Lock()
+		delete(s.conns, conn)
+		s.connLock.Unlock()
+		s.wg.Done()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	magic, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	// 连接断开或者客户端退出时返回
+	if magic[0] == binaryRequestMagic {
+		_ = s.serveBinary(reader, writer)
+	} else {
+		_ = s.serveText(reader, writer)
+	}
+}
+
+var errQuit = errors.New("quit")
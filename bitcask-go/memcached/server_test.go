@@ -0,0 +1,262 @@
+package memcached
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/auth"
+	"bufio"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConn(t *testing.T) (*bitcask.DB, net.Conn) {
+	return newTestConnWithAuth(t, nil)
+}
+
+func newTestConnWithAuth(t *testing.T, authenticator *auth.Authenticator) (*bitcask.DB, net.Conn) {
+	opts := bitcask.DefaultOptions
+	opts.DirPath, _ = os.MkdirTemp("", "bitcask-go-memcached")
+	db, err := bitcask.Open(opts)
+	assert.Nil(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := NewServerWithAuth(db, authenticator)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = server.Close()
+		_ = db.Close()
+		_ = os.RemoveAll(opts.DirPath)
+	})
+	return db, conn
+}
+
+func TestServer_Text(t *testing.T) {
+	_, conn := newTestConn(t)
+	reader := bufio.NewReader(conn)
+	call := func(req string, lines int) string {
+		_, err := conn.Write([]byte(req))
+		assert.Nil(t, err)
+		var resp strings.Builder
+		for i := 0; i < lines; i++ {
+			line, err := reader.ReadString('\n')
+			assert.Nil(t, err)
+			resp.WriteString(line)
+		}
+		return resp.String()
+	}
+
+	assert.Equal(t, "STORED\r\n", call("set k1 5 0 2\r\nv1\r\n", 1))
+	assert.Equal(t, "VALUE k1 5 2\r\nv1\r\nEND\r\n", call("get k1 k2\r\n", 3))
+	assert.Equal(t, "NOT_STORED\r\n", call("add k1 0 0 1\r\nx\r\n", 1))
+	assert.Equal(t, "NOT_STORED\r\n", call("replace k2 0 0 1\r\nx\r\n", 1))
+	assert.Equal(t, "STORED\r\n", call("add k2 0 0 1\r\nx\r\n", 1))
+
+	resp := call("gets k1\r\n", 3)
+	fields := strings.Fields(strings.SplitN(resp, "\r\n", 2)[0])
+	assert.Equal(t, 5, len(fields))
+	cas := fields[4]
+	assert.Equal(t, "EXISTS\r\n", call("cas k1 0 0 2 1\r\nv2\r\n", 1))
+	assert.Equal(t, "STORED\r\n", call("cas k1 0 0 2 "+cas+"\r\nv2\r\n", 1))
+	assert.Equal(t, "EXISTS\r\n", call("cas k1 0 0 2 "+cas+"\r\nv3\r\n", 1))
+	assert.Equal(t, "NOT_FOUND\r\n", call("cas k3 0 0 2 1\r\nv3\r\n", 1))
+
+	assert.Equal(t, "STORED\r\n", call("set n 0 0 2\r\n10\r\n", 1))
+	assert.Equal(t, "15\r\n", call("incr n 5\r\n", 1))
+	assert.Equal(t, "0\r\n", call("decr n 100\r\n", 1))
+	assert.Equal(t, "NOT_FOUND\r\n", call("incr k3 1\r\n", 1))
+	assert.Equal(t, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n", call("incr k1 1\r\n", 1))
+
+	assert.Equal(t, "DELETED\r\n", call("delete k1\r\n", 1))
+	assert.Equal(t, "NOT_FOUND\r\n", call("delete k1\r\n", 1))
+
+	// 过期的数据读取不到
+	assert.Equal(t, "STORED\r\n", call("set k4 0 -1 1\r\nx\r\n", 1))
+	assert.Equal(t, "STORED\r\n", call("set k5 0 1000 1\r\nx\r\n", 1))
+	assert.Equal(t, "VALUE k5 0 1\r\nx\r\nEND\r\n", call("get k4 k5\r\n", 3))
+
+	// noreply 的命令没有响应, 多个命令一起发送
+	assert.Equal(t, "VALUE k6 0 1\r\ny\r\nEND\r\n", call("set k6 0 0 1 noreply\r\ny\r\nget k6\r\n", 3))
+	assert.Equal(t, "ERROR\r\n", call("unknown\r\n", 1))
+	assert.Equal(t, "VERSION "+version+"\r\n", call("version\r\n", 1))
+
+	assert.Equal(t, "CLIENT_ERROR bad data chunk\r\n", call("set k7 0 0 1\r\nxyz\r\n", 1))
+	_, err := reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServer_TextTooLarge(t *testing.T) {
+	for _, size := range []string{"1048577", "1099511627776", "9223372036854775807"} {
+		_, conn := newTestConn(t)
+		reader := bufio.NewReader(conn)
+		// 超过上限的长度在读取数据块之前拒绝, 然后关闭连接
+		_, err := conn.Write([]byte("set k 0 0 " + size + "\r\nxyz\r\n"))
+		assert.Nil(t, err)
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		assert.Equal(t, "SERVER_ERROR object too large for cache\r\n", line)
+		_, err = reader.ReadByte()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+// 返回发送一个二进制协议的请求并读取响应的函数, 响应为 status, cas, extras 和 value
+func binaryCaller(t *testing.T, conn net.Conn) func(opcode byte, cas uint64, extras, key, value []byte) (uint16, uint64, []byte, []byte) {
+	return func(opcode byte, cas uint64, extras, key, value []byte) (uint16, uint64, []byte, []byte) {
+		header := make([]byte, binaryHeaderSize)
+		header[0] = binaryRequestMagic
+		header[1] = opcode
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+		header[4] = byte(len(extras))
+		binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+		binary.BigEndian.PutUint32(header[12:16], 7)
+		binary.BigEndian.PutUint64(header[16:24], cas)
+		_, err := conn.Write(append(append(append(header, extras...), key...), value...))
+		assert.Nil(t, err)
+
+		_, err = io.ReadFull(conn, header)
+		assert.Nil(t, err)
+		assert.Equal(t, byte(binaryResponseMagic), header[0])
+		assert.Equal(t, opcode, header[1])
+		assert.Equal(t, uint32(7), binary.BigEndian.Uint32(header[12:16]))
+		body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+		_, err = io.ReadFull(conn, body)
+		assert.Nil(t, err)
+		return binary.BigEndian.Uint16(header[6:8]), binary.BigEndian.Uint64(header[16:24]), body[:header[4]], body[int(header[4])+int(binary.BigEndian.Uint16(header[2:4])):]
+	}
+}
+
+func TestServer_Binary(t *testing.T) {
+	_, conn := newTestConn(t)
+	call := binaryCaller(t, conn)
+
+	extras := make([]byte, 8)
+	binary.BigEndian.PutUint32(extras[0:4], 3)
+	status, cas, _, _ := call(opSet, 0, extras, []byte("k1"), []byte("v1"))
+	assert.Equal(t, uint16(statusOK), status)
+	assert.True(t, cas > 0)
+
+	status, getCas, flags, value := call(opGet, 0, nil, []byte("k1"), nil)
+	assert.Equal(t, uint16(statusOK), status)
+	assert.Equal(t, cas, getCas)
+	assert.Equal(t, uint32(3), binary.BigEndian.Uint32(flags))
+	assert.Equal(t, []byte("v1"), value)
+
+	status, _, _, _ = call(opSet, cas+100, extras, []byte("k1"), []byte("v2"))
+	assert.Equal(t, uint16(statusKeyExists), status)
+	status, _, _, _ = call(opAdd, 0, extras, []byte("k1"), []byte("v2"))
+	assert.Equal(t, uint16(statusNotStored), status)
+
+	// 数据不存在时写入 initial
+	incrExtras := make([]byte, 20)
+	binary.BigEndian.PutUint64(incrExtras[0:8], 2)
+	binary.BigEndian.PutUint64(incrExtras[8:16], 10)
+	status, _, _, value = call(opIncr, 0, incrExtras, []byte("n"), nil)
+	assert.Equal(t, uint16(statusOK), status)
+	assert.Equal(t, uint64(10), binary.BigEndian.Uint64(value))
+	status, _, _, value = call(opIncr, 0, incrExtras, []byte("n"), nil)
+	assert.Equal(t, uint16(statusOK), status)
+	assert.Equal(t, uint64(12), binary.BigEndian.Uint64(value))
+
+	status, _, _, _ = call(opDelete, 0, nil, []byte("k1"), nil)
+	assert.Equal(t, uint16(statusOK), status)
+	status, _, _, _ = call(opGet, 0, nil, []byte("k1"), nil)
+	assert.Equal(t, uint16(statusKeyNotFound), status)
+
+	status, _, _, value = call(opVersion, 0, nil, nil, nil)
+	assert.Equal(t, uint16(statusOK), status)
+	assert.Equal(t, []byte(version), value)
+	status, _, _, _ = call(0x50, 0, nil, nil, nil)
+	assert.Equal(t, uint16(statusUnknownCommand), status)
+}
+
+func TestServer_BinaryAuth(t *testing.T) {
+	authenticator, err := auth.New([]auth.User{
+		{Name: "admin", Password: "secret", Permissions: []string{"read", "write", "admin"}},
+		{Name: "user", Tokens: []string{"t1"}, Prefixes: []string{"user:"}, Permissions: []string{"read"}},
+	})
+	assert.Nil(t, err)
+	_, conn := newTestConnWithAuth(t, authenticator)
+	call := binaryCaller(t, conn)
+	extras := make([]byte, 8)
+
+	// 认证之前只能执行不涉及数据的命令
+	status, _, _, _ := call(opSet, 0, extras, []byte("k1"), []byte("v1"))
+	assert.Equal(t, uint16(statusAuthError), status)
+	status, _, _, _ = call(opGet, 0, nil, []byte("user:1"), nil)
+	assert.Equal(t, uint16(statusAuthError), status)
+	status, _, _, _ = call(opNoop, 0, nil, nil, nil)
+	assert.Equal(t, uint16(statusOK), status)
+	status, _, _, value := call(opSASLListMechs, 0, nil, nil, nil)
+	assert.Equal(t, uint16(statusOK), status)
+	assert.Equal(t, []byte("PLAIN"), value)
+	status, _, _, _ = call(opSASLAuth, 0, nil, []byte("PLAIN"), []byte("\x00admin\x00wrong"))
+	assert.Equal(t, uint16(statusAuthError), status)
+	status, _, _, _ = call(opSASLAuth, 0, nil, []byte("CRAM-MD5"), []byte("admin"))
+	assert.Equal(t, uint16(statusAuthError), status)
+
+	status, _, _, _ = call(opSASLAuth, 0, nil, []byte("PLAIN"), []byte("\x00admin\x00secret"))
+	assert.Equal(t, uint16(statusOK), status)
+	status, _, _, _ = call(opSet, 0, extras, []byte("user:1"), []byte("v"))
+	assert.Equal(t, uint16(statusOK), status)
+
+	// 同一个连接可以重新认证为其他用户, 用户名为空时使用 token 认证, 只能读取有权限的前缀
+	status, _, _, _ = call(opSASLAuth, 0, nil, []byte("PLAIN"), []byte("\x00\x00t1"))
+	assert.Equal(t, uint16(statusOK), status)
+	status, _, _, value = call(opGet, 0, nil, []byte("user:1"), nil)
+	assert.Equal(t, uint16(statusOK), status)
+	assert.Equal(t, []byte("v"), value)
+	status, _, _, _ = call(opGet, 0, nil, []byte("order:1"), nil)
+	assert.Equal(t, uint16(statusAccessDenied), status)
+	status, _, _, _ = call(opSet, 0, extras, []byte("user:2"), []byte("v"))
+	assert.Equal(t, uint16(statusAccessDenied), status)
+
+	// 开启认证时不支持文本协议
+	_, textConn := newTestConnWithAuth(t, authenticator)
+	_, err = textConn.Write([]byte("get user:1\r\n"))
+	assert.Nil(t, err)
+	reader := bufio.NewReader(textConn)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "CLIENT_ERROR unauthenticated\r\n", line)
+	_, err = reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestServer_DeleteExpired(t *testing.T) {
+	db, _ := newTestConn(t)
+	server := NewServer(db)
+
+	expired := &item{expireAt: time.Now().Unix() - 10, value: []byte("v1")}
+	assert.Nil(t, db.Put([]byte("k1"), expired.encode()))
+	live := &item{expireAt: time.Now().Unix() + 3600, value: []byte("v2")}
+	assert.Nil(t, db.Put([]byte("k2"), live.encode()))
+
+	// 读到过期的数据时从数据库中删除
+	_, err := server.get([]byte("k1"))
+	assert.Equal(t, errNotFound, err)
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	it, err := server.get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), it.value)
+
+	// 写入时覆盖过期的数据
+	assert.Nil(t, db.Put([]byte("k3"), expired.encode()))
+	_, err = server.store(modeAdd, []byte("k3"), 0, 0, []byte("v3"), 0)
+	assert.Nil(t, err)
+	it, err = server.get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), it.value)
+}
@@ -0,0 +1,204 @@
+package memcached
+
+import (
+	bitcask "bitcask-go"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// 存储的 value 为 flags | 过期时间 | cas | 数据
+// 4 + 8 + 8 = 20
+const itemHeaderSize = 20
+
+// exptime 超过 30 天时表示绝对的 unix 时间
+const maxRelativeExptime = 60 * 60 * 24 * 30
+
+var (
+	errNotStored  = errors.New("not stored")
+	errExists     = errors.New("exists")
+	errNotFound   = errors.New("not found")
+	errNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+	errExpired    = errors.New("expired")
+)
+
+type item struct {
+	flags    uint32
+	expireAt int64 // 过期的 unix 时间, 单位秒, 为 0 时不过期
+	cas      uint64
+	value    []byte
+}
+
+func (it *item) encode() []byte {
+	buf := make([]byte, itemHeaderSize+len(it.value))
+	binary.BigEndian.PutUint32(buf[0:4], it.flags)
+	binary.BigEndian.PutUint64(buf[4:12], uint64(it.expireAt))
+	binary.BigEndian.PutUint64(buf[12:20], it.cas)
+	copy(buf[itemHeaderSize:], it.value)
+	return buf
+}
+
+func decodeItem(buf []byte) *item {
+	if len(buf) < itemHeaderSize {
+		return nil
+	}
+	return &item{
+		flags:    binary.BigEndian.Uint32(buf[0:4]),
+		expireAt: int64(binary.BigEndian.Uint64(buf[4:12])),
+		cas:      binary.BigEndian.Uint64(buf[12:20]),
+		value:    buf[itemHeaderSize:],
+	}
+}
+
+func (it *item) expired(now time.Time) bool {
+	return it.expireAt != 0 && it.expireAt <= now.Unix()
+}
+
+// 将协议中的 exptime 转换为过期的 unix 时间, 负数表示立即过期
+func expireAt(exptime int64, now time.Time) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return -1
+	case exptime > maxRelativeExptime:
+		return exptime
+	default:
+		return now.Unix() + exptime
+	}
+}
+
+type storeMode int
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeCas
+)
+
+// 读取没有过期的数据, 不存在时返回 errNotFound
+// 读到过期的数据时加锁删除, 之后 merge 可以回收
+func (s *Server) get(key []byte) (*item, error) {
+	it, err := s.read(key)
+	if err != errExpired {
+		return it, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.getLocked(key)
+}
+
+// 持有 s.lock 时读取没有过期的数据, 过期的数据直接删除
+// 加锁之后重新读取, 不会删除并发写入的新数据
+func (s *Server) getLocked(key []byte) (*item, error) {
+	it, err := s.read(key)
+	if err != errExpired {
+		return it, err
+	}
+	if err := s.db.Delete(key); err != nil {
+		return nil, err
+	}
+	return nil, errNotFound
+}
+
+// 读取并解码数据, 数据已经过期时返回 errExpired
+func (s *Server) read(key []byte) (*item, error) {
+	buf, err := s.db.Get(key)
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	it := decodeItem(buf)
+	// 不是通过 memcached 写入的数据
+	if it == nil {
+		return nil, errNotFound
+	}
+	if it.expired(time.Now()) {
+		return nil, errExpired
+	}
+	return it, nil
+}
+
+// 按照 mode 写入数据, 返回新的 cas
+func (s *Server) store(mode storeMode, key []byte, flags uint32, exptime int64, value []byte, cas uint64) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old, err := s.getLocked(key)
+	if err != nil && err != errNotFound {
+		return 0, err
+	}
+	switch mode {
+	case modeAdd:
+		if old != nil {
+			return 0, errNotStored
+		}
+	case modeReplace:
+		if old == nil {
+			return 0, errNotStored
+		}
+	case modeCas:
+		if old == nil {
+			return 0, errNotFound
+		}
+		if old.cas != cas {
+			return 0, errExists
+		}
+	}
+
+	now := time.Now()
+	it := &item{flags: flags, expireAt: expireAt(exptime, now), cas: s.nextCas(), value: value}
+	// 立即过期的数据直接删除
+	if it.expired(now) {
+		return it.cas, s.db.Delete(key)
+	}
+	return it.cas, s.db.Put(key, it.encode())
+}
+
+func (s *Server) delete(key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.getLocked(key); err != nil {
+		return err
+	}
+	return s.db.Delete(key)
+}
+
+// 对十进制的数据加上或减去 delta, 减到 0 为止, 加法溢出时回绕
+// initial 不为空时, 数据不存在则写入 initial
+func (s *Server) incr(key []byte, delta uint64, decr bool, initial *uint64, exptime int64) (uint64, uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	it, err := s.getLocked(key)
+	if err == errNotFound && initial != nil {
+		it = &item{expireAt: expireAt(exptime, time.Now()), cas: s.nextCas(), value: []byte(strconv.FormatUint(*initial, 10))}
+		return *initial, it.cas, s.db.Put(key, it.encode())
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(string(it.value), 10, 64)
+	if err != nil {
+		return 0, 0, errNonNumeric
+	}
+	if !decr {
+		n += delta
+	} else if delta > n {
+		n = 0
+	} else {
+		n -= delta
+	}
+	it.value = []byte(strconv.FormatUint(n, 10))
+	it.cas = s.nextCas()
+	return n, it.cas, s.db.Put(key, it.encode())
+}
+
+func (s *Server) nextCas() uint64 {
+	s.casUnique++
+	return s.casUnique
+}
@@ -0,0 +1,212 @@
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// key 的最大长度
+	maxKeySize = 250
+	// value 的最大长度
+	maxValueSize = 1024 * 1024
+)
+
+// 处理文本协议的请求, 每个请求为一行命令, 写入命令后面还有一个数据块
+func (s *Server) serveText(reader *bufio.Reader, writer *bufio.Writer) error {
+	if s.auth != nil {
+		_, _ = writer.WriteString("CLIENT_ERROR unauthenticated\r\n")
+		_ = writer.Flush()
+		return errQuit
+	}
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			_, _ = writer.WriteString("CLIENT_ERROR line too long\r\n")
+			_ = writer.Flush()
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		err = s.handleTextCommand(strings.Fields(string(line)), reader, writer)
+		// 客户端连续发送的请求处理完之后再一起发送响应
+		if err != nil || reader.Buffered() == 0 {
+			if flushErr := writer.Flush(); err == nil {
+				err = flushErr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 返回错误时关闭连接
+func (s *Server) handleTextCommand(fields []string, reader *bufio.Reader, writer *bufio.Writer) error {
+	if len(fields) == 0 {
+		_, err := writer.WriteString("ERROR\r\n")
+		return err
+	}
+	switch fields[0] {
+	case "get", "gets":
+		return s.handleTextGet(fields, writer)
+	case "set", "add", "replace", "cas":
+		return s.handleTextStore(fields, reader, writer)
+	case "delete":
+		return s.handleTextDelete(fields, writer)
+	case "incr", "decr":
+		return s.handleTextIncr(fields, writer)
+	case "version":
+		_, err := writer.WriteString("VERSION " + version + "\r\n")
+		return err
+	case "quit":
+		return errQuit
+	}
+	_, err := writer.WriteString("ERROR\r\n")
+	return err
+}
+
+// get <key>*
+func (s *Server) handleTextGet(fields []string, writer *bufio.Writer) error {
+	if len(fields) < 2 {
+		_, err := writer.WriteString("ERROR\r\n")
+		return err
+	}
+	for _, key := range fields[1:] {
+		it, err := s.get([]byte(key))
+		if err == errNotFound {
+			continue
+		}
+		if err != nil {
+			_, err = writer.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+			return err
+		}
+		// VALUE <key> <flags> <bytes> [<cas unique>]
+		header := "VALUE " + key + " " + strconv.FormatUint(uint64(it.flags), 10) + " " + strconv.Itoa(len(it.value))
+		if fields[0] == "gets" {
+			header += " " + strconv.FormatUint(it.cas, 10)
+		}
+		_, _ = writer.WriteString(header + "\r\n")
+		_, _ = writer.Write(it.value)
+		_, _ = writer.WriteString("\r\n")
+	}
+	_, err := writer.WriteString("END\r\n")
+	return err
+}
+
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (s *Server) handleTextStore(fields []string, reader *bufio.Reader, writer *bufio.Writer) error {
+	argNum := 5
+	if fields[0] == "cas" {
+		argNum = 6
+	}
+	if len(fields) < argNum {
+		_, err := writer.WriteString("ERROR\r\n")
+		return err
+	}
+	noreply := len(fields) > argNum && fields[argNum] == "noreply"
+	flags, err1 := strconv.ParseUint(fields[2], 10, 32)
+	exptime, err2 := strconv.ParseInt(fields[3], 10, 64)
+	size, err3 := strconv.Atoi(fields[4])
+	var cas uint64
+	var err4 error
+	if fields[0] == "cas" {
+		cas, err4 = strconv.ParseUint(fields[5], 10, 64)
+	}
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || size < 0 {
+		_, err := writer.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return err
+	}
+
+	// 数据块过大时不读取, 也就无法跳过它继续解析后面的请求, 直接关闭连接
+	if size > maxValueSize {
+		_ = textReply(writer, noreply, "SERVER_ERROR object too large for cache")
+		return errQuit
+	}
+	// 读取数据块, 数据块的长度不对时无法继续解析后面的请求
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		_, _ = writer.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return errQuit
+	}
+	if len(fields[1]) > maxKeySize {
+		return textReply(writer, noreply, "CLIENT_ERROR key too long")
+	}
+
+	mode := modeSet
+	switch fields[0] {
+	case "add":
+		mode = modeAdd
+	case "replace":
+		mode = modeReplace
+	case "cas":
+		mode = modeCas
+	}
+	_, err := s.store(mode, []byte(fields[1]), uint32(flags), exptime, data[:size], cas)
+	switch err {
+	case nil:
+		return textReply(writer, noreply, "STORED")
+	case errNotStored:
+		return textReply(writer, noreply, "NOT_STORED")
+	case errExists:
+		return textReply(writer, noreply, "EXISTS")
+	case errNotFound:
+		return textReply(writer, noreply, "NOT_FOUND")
+	}
+	return textReply(writer, noreply, "SERVER_ERROR "+err.Error())
+}
+
+// delete <key> [noreply]
+func (s *Server) handleTextDelete(fields []string, writer *bufio.Writer) error {
+	if len(fields) < 2 {
+		_, err := writer.WriteString("ERROR\r\n")
+		return err
+	}
+	noreply := fields[len(fields)-1] == "noreply"
+	switch err := s.delete([]byte(fields[1])); err {
+	case nil:
+		return textReply(writer, noreply, "DELETED")
+	case errNotFound:
+		return textReply(writer, noreply, "NOT_FOUND")
+	default:
+		return textReply(writer, noreply, "SERVER_ERROR "+err.Error())
+	}
+}
+
+// incr|decr <key> <value> [noreply]
+func (s *Server) handleTextIncr(fields []string, writer *bufio.Writer) error {
+	if len(fields) < 3 {
+		_, err := writer.WriteString("ERROR\r\n")
+		return err
+	}
+	noreply := len(fields) > 3 && fields[3] == "noreply"
+	delta, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return textReply(writer, noreply, "CLIENT_ERROR invalid numeric delta argument")
+	}
+	n, _, err := s.incr([]byte(fields[1]), delta, fields[0] == "decr", nil, 0)
+	switch err {
+	case nil:
+		return textReply(writer, noreply, strconv.FormatUint(n, 10))
+	case errNotFound:
+		return textReply(writer, noreply, "NOT_FOUND")
+	case errNonNumeric:
+		return textReply(writer, noreply, "CLIENT_ERROR "+err.Error())
+	}
+	return textReply(writer, noreply, "SERVER_ERROR "+err.Error())
+}
+
+func textReply(writer *bufio.Writer, noreply bool, msg string) error {
+	if noreply {
+		return nil
+	}
+	_, err := writer.WriteString(msg + "\r\n")
+	return err
+}
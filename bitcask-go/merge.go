@@ -1,14 +1,19 @@
 package bitcask_go
 
 import (
+	"bitcask-go/bloom"
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"bitcask-go/utils"
+	"context"
 	"io"
+	"log/slog"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 )
 
 const (
@@ -16,10 +21,48 @@ const (
 	mergeFinishedKey = "mergeFinished"
 )
 
+// merge 使用的临时实例不输出日志
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
 func (db *DB) Merge() error {
+	return db.MergeContext(context.Background())
+}
+
+// MergeContext 和 Merge 相同, 处理每条记录之前检查 ctx
+// 取消时删除 merge 目录并返回 ctx 的错误, 数据目录不受影响
+func (db *DB) MergeContext(ctx context.Context) (err error) {
 	if db.activeFile == nil {
 		return nil
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 读取和写入的记录大小之差为 merge 减少的数据文件大小
+	start := time.Now()
+	var readSize, writeSize int64
+	var info *MergeInfo
+	defer func() {
+		if err == nil {
+			db.observeMerge(time.Since(start), readSize-writeSize)
+		}
+		// 开始处理数据文件之后才通知结束
+		if info != nil {
+			completed := *info
+			completed.Duration, completed.Reclaimed, completed.Err = time.Since(start), readSize-writeSize, err
+			if err != nil {
+				db.logger.Error("merge failed", "duration", completed.Duration, "error", err)
+			} else {
+				db.logger.Info("merge completed", "duration", completed.Duration, "reclaimed", completed.Reclaimed)
+			}
+			db.notifyListener(func(listener EventListener) {
+				listener.OnMergeCompleted(completed)
+			})
+		}
+	}()
+	// 只读模式不能 merge, 副本的数据文件需要和主节点保持一致, 也不能自行 merge
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
 
 	db.mu.Lock()
 	if db.isMerging == true {
@@ -35,15 +78,16 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return ErrMergeRatioUnReached
 	}
-	availableDiskSize, err := utils.AvailableDiskSize()
-	if uint64(totalSize-db.reclaimSize) > availableDiskSize {
-		db.mu.Unlock()
-		return ErrNoEnoughSpaceForMerge
-	}
+	// 磁盘空间不足的只读状态下也可以 merge, MinFreeDiskSize 保留的空间用于写入有效数据
+	availableSize, err := availableDiskSize(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
+	if uint64(totalSize-db.reclaimSize) > availableSize {
+		db.mu.Unlock()
+		return ErrNoEnoughSpaceForMerge
+	}
 	db.isMerging = true
 
 	defer func() {
@@ -51,7 +95,7 @@ func (db *DB) Merge() error {
 	}()
 
 	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	if err := db.syncActiveFile(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
@@ -69,8 +113,16 @@ func (db *DB) Merge() error {
 	for _, file := range db.oldFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
+	namespaces := db.namespaceSnapshot()
 	db.mu.Unlock()
 
+	info = &MergeInfo{Files: len(mergeFiles), NonMergeFileId: nonMergeFileId}
+	started := *info
+	db.logger.Info("merge started", "files", started.Files, "non_merge_file_id", started.NonMergeFileId)
+	db.notifyListener(func(listener EventListener) {
+		listener.OnMergeStarted(started)
+	})
+
 	// merge 排序
 	sort.Slice(mergeFiles, func(i, j int) bool {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
@@ -89,18 +141,51 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// merge 时只需要追加写数据, 使用内存索引, 避免在 merge 目录中生成 B+ 树索引文件
+	mergeOptions.IndexType = Btree
+	mergeOptions.CustomIndexer = ""
+	// merge 生成的文件会替换原来的文件, 不需要归档
+	mergeOptions.ArchiveDir = ""
+	mergeOptions.Observer = nil
+	mergeOptions.EventListener = nil
+	mergeOptions.Logger = discardLogger
+	mergeOptions.MinFreeDiskSize = 0
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
-	hintFile, err := data.OpenHintFile(mergePath)
+	// 失败或者取消时清理 merge 目录, 避免下次启动时使用不完整的结果
+	var hintFile *data.DataFile
+	defer func() {
+		if err == nil {
+			return
+		}
+		if hintFile != nil {
+			_ = hintFile.Close()
+		}
+		_ = mergeDB.Close()
+		_ = os.RemoveAll(mergePath)
+	}()
+	hintFile, err = data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	hintFile.SetKeyProvider(db.options.KeyProvider)
+	if err := writeNamespaceHints(hintFile, namespaces); err != nil {
+		return err
+	}
+	// 与 hint 文件一起生成只包含有效 key 的布隆过滤器
+	var filter *bloom.Filter
+	if db.filter != nil {
+		filter = bloom.NewFilter(uint64(db.index.Size()), db.options.BloomFalsePositive)
+	}
 	// 遍历处理每个文件
 	for _, dataFile := range mergeFiles {
 		var offset int64 = 0
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
@@ -110,7 +195,16 @@ func (db *DB) Merge() error {
 			}
 			// 得到实际的key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
+			var logRecordPos *data.LogRecordPos
+			switch logRecord.Type {
+			case data.LogRecordTypeNormal:
+				logRecordPos = db.index.Get(realKey)
+			case data.LogRecordNamespacePut:
+				// 已经删除的命名空间中的数据直接丢弃
+				if id, key := parseNamespaceKey(realKey); namespaces[id] != nil {
+					logRecordPos = namespaces[id].index.Get(key)
+				}
+			}
 			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeqNo(realKey, nonTransactionSeqNo)
@@ -119,10 +213,20 @@ func (db *DB) Merge() error {
 					return err
 				}
 				// 将位置索引存到 hint 文件
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				if logRecord.Type == data.LogRecordNamespacePut {
+					err = hintFile.WriteNamespaceHintRecord(realKey, pos)
+				} else {
+					err = hintFile.WriteHintRecord(realKey, pos)
+					if filter != nil {
+						filter.Add(realKey)
+					}
+				}
+				if err != nil {
 					return err
 				}
+				writeSize += int64(pos.Size)
 			}
+			readSize += size
 			offset += size
 		}
 	}
@@ -130,6 +234,11 @@ func (db *DB) Merge() error {
 	if err := hintFile.Sync(); err != nil {
 		return err
 	}
+	if filter != nil {
+		if err := writeBloomFilter(mergePath, filter, db.options.KeyProvider); err != nil {
+			return err
+		}
+	}
 
 	if err := mergeDB.Sync(); err != nil {
 		return err
@@ -138,6 +247,7 @@ func (db *DB) Merge() error {
 	if err != nil {
 		return err
 	}
+	mergeFinishedFile.SetKeyProvider(db.options.KeyProvider)
 	mergeFinRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
@@ -185,10 +295,18 @@ func (db *DB) loadMergeFiles() error {
 		if entry.Name() == fileFlockName {
 			continue
 		}
+		// 正常关闭时保存的过滤器包含全部的 key, 持久化索引启动时也不会重放全部数据文件, 都保留数据目录中的过滤器
+		if entry.Name() == data.BloomFilterFileName {
+			_, err := os.Stat(filepath.Join(db.options.DirPath, data.BloomFilterFileName))
+			if _, ok := db.index.(index.PersistentIndexer); ok || err == nil {
+				continue
+			}
+		}
 		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 
 	if !mergeFinished {
+		db.logger.Warn("discarded unfinished merge", "dir", mergePath)
 		return nil
 	}
 	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
@@ -200,7 +318,7 @@ func (db *DB) loadMergeFiles() error {
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(path.Join(mergePath, fileName)); err == nil {
+		if _, err := os.Stat(fileName); err == nil {
 			if err := os.Remove(fileName); err != nil {
 				return err
 			}
@@ -214,14 +332,16 @@ func (db *DB) loadMergeFiles() error {
 			return err
 		}
 	}
+	db.logger.Info("applied merged files", "dir", db.options.DirPath, "non_merge_file_id", nonMergeFileId)
 	return nil
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenReadOnlyFile(dirPath, data.MergeFinishedFileName)
 	if err != nil {
 		return 0, nil
 	}
+	mergeFinishedFile.SetKeyProvider(db.options.KeyProvider)
 	record, _, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
 		return 0, err
@@ -233,7 +353,7 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
-func (db *DB) loadIndexFromHintFile() error {
+func (db *DB) loadIndexFromHintFile(addToFilter bool) error {
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
 
 	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
@@ -241,10 +361,11 @@ func (db *DB) loadIndexFromHintFile() error {
 	}
 
 	// 打开 hint 索引文件
-	hintFile, err := data.OpenHintFile(hintFileName)
+	hintFile, err := data.OpenReadOnlyFile(db.options.DirPath, data.HintFileName)
 	if err != nil {
 		return err
 	}
+	hintFile.SetKeyProvider(db.options.KeyProvider)
 
 	var offset int64 = 0
 	for {
@@ -255,9 +376,105 @@ func (db *DB) loadIndexFromHintFile() error {
 			}
 			return err
 		}
+		offset += size
+		if logRecord.Type != data.LogRecordTypeNormal {
+			var pos *data.LogRecordPos
+			if logRecord.Type == data.LogRecordNamespacePut {
+				pos = data.DecodeLogRecordPos(logRecord.Value)
+			}
+			if _, err := db.replayNamespaceRecord(logRecord.Key, logRecord, pos); err != nil {
+				return err
+			}
+			continue
+		}
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		oldPos, err := db.index.Put(logRecord.Key, pos)
+		if err != nil {
+			return err
+		}
+		if oldPos == nil && addToFilter && db.filter != nil {
+			db.filter.Add(logRecord.Key)
+		}
+	}
+	return nil
+}
+
+// 持久化索引不会从 hint 文件加载, 需要在 merge 完成后将 hint 文件中的位置更新到索引中
+func (db *DB) loadMergedHintFile(pi index.PersistentIndexer) error {
+	checkpoint, err := pi.Checkpoint()
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil {
+		db.mergedFileId = checkpoint.MergedFid
+	}
+
+	mergedFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergedFileName); os.IsNotExist(err) {
+		return nil
+	}
+	nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	// 这次 merge 的结果已经应用过了
+	if checkpoint != nil && checkpoint.MergedFid == nonMergeFileId {
+		return nil
+	}
+
+	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	hintFile.SetKeyProvider(db.options.KeyProvider)
+	defer func() {
+		_ = hintFile.Close()
+	}()
+
+	var entries []*index.BatchEntry
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
 		offset += size
+		// 持久化索引不支持命名空间
+		if logRecord.Type != data.LogRecordTypeNormal {
+			continue
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		// 没有检查点时会从 nonMergeFileId 开始重放全部数据, 直接使用 hint 文件中的位置即可
+		// 否则只更新位置仍然指向 merge 之前的文件的 key, 不存在的 key 在 merge 之后已经被删除
+		if checkpoint != nil {
+			curPos := pi.Get(logRecord.Key)
+			if curPos == nil || curPos.Fid >= nonMergeFileId {
+				continue
+			}
+		}
+		entries = append(entries, &index.BatchEntry{Key: logRecord.Key, Pos: pos})
+		// 中途不更新检查点, 保证异常退出后能够重新应用
+		if len(entries) >= replayBatchSize {
+			if _, err := pi.ApplyBatch(entries, nil); err != nil {
+				return err
+			}
+			entries = nil
+		}
+	}
+
+	newCheckpoint := &index.Checkpoint{Fid: nonMergeFileId, MergedFid: nonMergeFileId}
+	if checkpoint != nil {
+		newCheckpoint.SeqNo = checkpoint.SeqNo
+		if checkpoint.Fid >= nonMergeFileId {
+			newCheckpoint.Fid, newCheckpoint.Offset = checkpoint.Fid, checkpoint.Offset
+		}
+	}
+	if _, err := pi.ApplyBatch(entries, newCheckpoint); err != nil {
+		return err
 	}
+	db.mergedFileId = nonMergeFileId
 	return nil
 }
@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bitcask-go/utils"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"sync"
@@ -201,3 +202,101 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// B+ 树索引 merge 之后重启, 索引指向 merge 之后的数据文件
+func TestDB_Merge_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-bptree")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.IndexType = BPlusTree
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// merge 之后又有新的写入
+	for i := 40000; i < 45000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new value after merge"))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(49999))
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 39999, len(db2.ListKeys()))
+	for i := 10000; i < 40000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	for i := 40000; i < 45000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new value after merge"), val)
+	}
+	_, err = db2.Get(utils.GetTestKey(49999))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 调用一定次数之后取消的 context
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (ctx *countdownContext) Err() error {
+	if ctx.remaining--; ctx.remaining < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDB_MergeContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-context")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%100), utils.RandomValue(128)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.MergeContext(ctx))
+
+	// 处理到一半时取消, merge 目录被删除
+	assert.Equal(t, context.Canceled, db.MergeContext(&countdownContext{Context: context.Background(), remaining: 500}))
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 重启之后数据不受影响, 可以重新 merge
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db.ListKeys()))
+	assert.Nil(t, db.MergeContext(context.Background()))
+}
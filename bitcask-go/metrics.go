@@ -0,0 +1,206 @@
+package bitcask_go
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Operation 被统计的操作
+type Operation string
+
+const (
+	OpPut         Operation = "put"
+	OpGet         Operation = "get"
+	OpDelete      Operation = "delete"
+	OpBatchCommit Operation = "batch_commit"
+	OpSync        Operation = "sync"
+	OpMerge       Operation = "merge"
+)
+
+// 统计延迟的操作
+var operations = []Operation{OpPut, OpGet, OpDelete, OpBatchCommit, OpSync, OpMerge}
+
+// Observer 接收存储引擎的统计信息, 用于接入自定义的监控系统
+// 方法在执行操作的协程中同步调用, 不能阻塞
+type Observer interface {
+	// ObserveOperation 读写, 批量提交和 fsync 完成之后调用, 读取不存在的 key 不算作错误
+	ObserveOperation(op Operation, duration time.Duration, err error)
+	// ObserveBytesWritten 追加写入数据文件之后调用
+	ObserveBytesWritten(n int64)
+	// ObserveMerge merge 成功之后调用, reclaimed 为 merge 减少的数据文件大小
+	ObserveMerge(duration time.Duration, reclaimed int64)
+}
+
+// LatencyBuckets 延迟直方图中每个桶的上界
+var LatencyBuckets = []time.Duration{
+	10 * time.Microsecond, 50 * time.Microsecond, 100 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second,
+}
+
+// Histogram 延迟直方图
+type Histogram struct {
+	// 每个桶中小于等于 LatencyBuckets 中对应上界的累计数量
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Metrics 存储引擎运行以来的统计信息
+type Metrics struct {
+	Latencies             map[Operation]Histogram
+	Errors                map[Operation]uint64
+	BytesWritten          uint64
+	MergeReclaimedBytes   uint64
+	KeyNum                uint
+	DataFileNum           uint
+	DataFileSize          int64 // 数据文件的总大小, 不包含 hint 文件和索引文件
+	ReclaimableSize       int64
+	CacheHits             uint64
+	CacheMisses           uint64
+	DroppedEvents         uint64
+	DiskFull              bool   // 是否因为磁盘空间不足处于只读状态
+	DroppedListenerEvents uint64 // EventListener 的异步队列已满而丢弃的事件数量
+}
+
+type histogram struct {
+	counts []uint64 // 最后一个桶为超过所有上界的数量
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// 总数由同一次读取的所有桶相加得到, 不会小于任何一个桶的累计数量
+func (h *histogram) snapshot() Histogram {
+	result := Histogram{
+		Counts: make([]uint64, len(LatencyBuckets)),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	var cumulative uint64
+	for i := range LatencyBuckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		result.Counts[i] = cumulative
+	}
+	result.Count = cumulative + atomic.LoadUint64(&h.counts[len(LatencyBuckets)])
+	return result
+}
+
+// 存储引擎内部维护的统计, 初始化之后 map 不再修改, 可以并发访问
+type metrics struct {
+	latencies      map[Operation]*histogram
+	errors         map[Operation]*uint64
+	bytesWritten   uint64
+	mergeReclaimed uint64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		latencies: make(map[Operation]*histogram, len(operations)),
+		errors:    make(map[Operation]*uint64, len(operations)),
+	}
+	for _, op := range operations {
+		m.latencies[op] = &histogram{counts: make([]uint64, len(LatencyBuckets)+1)}
+		m.errors[op] = new(uint64)
+	}
+	return m
+}
+
+// 记录一次操作, 在操作的开头 defer 调用
+func (db *DB) observe(op Operation, start time.Time, errp *error) {
+	duration := time.Since(start)
+	var err error
+	if errp != nil && !errors.Is(*errp, ErrKeyNotFound) {
+		err = *errp
+	}
+	db.metrics.latencies[op].observe(duration)
+	if err != nil {
+		atomic.AddUint64(db.metrics.errors[op], 1)
+	}
+	if db.options.Observer != nil {
+		db.options.Observer.ObserveOperation(op, duration, err)
+	}
+}
+
+func (db *DB) observeBytesWritten(n int64) {
+	atomic.AddUint64(&db.metrics.bytesWritten, uint64(n))
+	if db.options.Observer != nil {
+		db.options.Observer.ObserveBytesWritten(n)
+	}
+}
+
+func (db *DB) observeMerge(duration time.Duration, reclaimed int64) {
+	db.metrics.latencies[OpMerge].observe(duration)
+	if reclaimed > 0 {
+		atomic.AddUint64(&db.metrics.mergeReclaimed, uint64(reclaimed))
+	}
+	if db.options.Observer != nil {
+		db.options.Observer.ObserveMerge(duration, reclaimed)
+	}
+}
+
+// 持久化活跃文件并记录 fsync 的耗时
+func (db *DB) syncActiveFile() (err error) {
+	defer db.observe(OpSync, time.Now(), &err)
+	if err = db.activeFile.Sync(); err != nil {
+		info := SyncErrorInfo{FileId: db.activeFile.FileId, Err: err}
+		db.logger.Error("failed to sync data file", "file_id", info.FileId, "error", err)
+		db.notifyListener(func(listener EventListener) {
+			listener.OnSyncError(info)
+		})
+	}
+	return err
+}
+
+// Metrics 返回运行以来的统计信息, 不需要遍历数据目录
+func (db *DB) Metrics() *Metrics {
+	m := &Metrics{
+		Latencies:           make(map[Operation]Histogram, len(operations)),
+		Errors:              make(map[Operation]uint64, len(operations)),
+		BytesWritten:        atomic.LoadUint64(&db.metrics.bytesWritten),
+		MergeReclaimedBytes: atomic.LoadUint64(&db.metrics.mergeReclaimed),
+		DroppedEvents:       atomic.LoadUint64(&db.droppedEvents),
+		DiskFull:            db.disk.isFull(),
+	}
+	for _, op := range operations {
+		m.Latencies[op] = db.metrics.latencies[op].snapshot()
+		m.Errors[op] = atomic.LoadUint64(db.metrics.errors[op])
+	}
+	if db.eventQueue != nil {
+		m.DroppedListenerEvents = atomic.LoadUint64(&db.eventQueue.dropped)
+	}
+	if db.valueCache != nil {
+		m.CacheHits = db.valueCache.Hits()
+		m.CacheMisses = db.valueCache.Misses()
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	m.KeyNum = uint(db.index.Size())
+	m.ReclaimableSize = db.reclaimSize
+	m.DataFileNum, m.DataFileSize = db.dataFileStat()
+	return m
+}
+
+// 数据文件的数量和总大小, 旧的数据文件不再写入, 大小直接从打开的文件中获取, 调用时需要持有 db.mu
+func (db *DB) dataFileStat() (uint, int64) {
+	var size int64
+	for _, file := range db.oldFiles {
+		if fileSize, err := file.IoManager.Size(); err == nil {
+			size += fileSize
+		}
+	}
+	num := uint(len(db.oldFiles))
+	if db.activeFile != nil {
+		num++
+		size += db.activeFile.WriteOffset
+	}
+	return num, size
+}
@@ -0,0 +1,130 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testObserver struct {
+	lock         sync.Mutex
+	operations   map[Operation]int
+	errors       map[Operation]int
+	bytesWritten int64
+	merges       int
+	reclaimed    int64
+}
+
+func (o *testObserver) ObserveOperation(op Operation, duration time.Duration, err error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.operations[op]++
+	if err != nil {
+		o.errors[op]++
+	}
+}
+
+func (o *testObserver) ObserveBytesWritten(n int64) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.bytesWritten += n
+}
+
+func (o *testObserver) ObserveMerge(duration time.Duration, reclaimed int64) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.merges++
+	o.reclaimed += reclaimed
+}
+
+func TestDB_Metrics(t *testing.T) {
+	observer := &testObserver{operations: map[Operation]int{}, errors: map[Operation]int{}}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.Observer = observer
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	_, err = db.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	// 不存在的 key 不算作错误
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, ErrKeyIsEmpty, db.Put(nil, []byte("v")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(200), []byte("v")))
+	assert.Nil(t, wb.Commit())
+
+	m := db.Metrics()
+	assert.Equal(t, uint64(101), m.Latencies[OpPut].Count)
+	assert.Equal(t, uint64(1), m.Errors[OpPut])
+	assert.Equal(t, uint64(50), m.Latencies[OpDelete].Count)
+	assert.Equal(t, uint64(2), m.Latencies[OpGet].Count)
+	assert.Equal(t, uint64(0), m.Errors[OpGet])
+	assert.Equal(t, uint64(1), m.Latencies[OpBatchCommit].Count)
+	// 批量提交时 fsync
+	assert.Equal(t, uint64(1), m.Latencies[OpSync].Count)
+	assert.Equal(t, m.Latencies[OpPut].Count, m.Latencies[OpPut].Counts[len(LatencyBuckets)-1])
+	assert.True(t, m.Latencies[OpPut].Sum > 0)
+	assert.Equal(t, uint(51), m.KeyNum)
+	assert.Equal(t, uint(1), m.DataFileNum)
+	assert.Equal(t, int64(m.BytesWritten), m.DataFileSize)
+	assert.Equal(t, m.DataFileSize, db.Stat().DiskSize)
+	assert.Equal(t, observer.bytesWritten, int64(m.BytesWritten))
+	assert.Equal(t, 101, observer.operations[OpPut])
+	assert.Equal(t, 1, observer.errors[OpPut])
+	assert.Equal(t, 0, observer.errors[OpGet])
+
+	assert.Nil(t, db.Merge())
+	m = db.Metrics()
+	assert.Equal(t, uint64(1), m.Latencies[OpMerge].Count)
+	assert.True(t, m.MergeReclaimedBytes > 0)
+	assert.Equal(t, 1, observer.merges)
+	assert.Equal(t, int64(m.MergeReclaimedBytes), observer.reclaimed)
+}
+
+func TestHistogram_Snapshot(t *testing.T) {
+	h := &histogram{counts: make([]uint64, len(LatencyBuckets)+1)}
+	// 超过所有上界的也计入总数
+	h.observe(LatencyBuckets[len(LatencyBuckets)-1] + time.Second)
+	h.observe(time.Microsecond)
+	snapshot := h.snapshot()
+	assert.Equal(t, uint64(2), snapshot.Count)
+	assert.Equal(t, uint64(1), snapshot.Counts[len(LatencyBuckets)-1])
+
+	// 并发记录时总数也不会小于任何一个桶的累计数量
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					h.observe(LatencyBuckets[i%len(LatencyBuckets)])
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 1000; i++ {
+		snapshot := h.snapshot()
+		assert.GreaterOrEqual(t, snapshot.Count, snapshot.Counts[len(LatencyBuckets)-1])
+	}
+	close(done)
+	wg.Wait()
+}
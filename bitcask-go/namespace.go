@@ -0,0 +1,364 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"encoding/binary"
+	"sort"
+)
+
+// Namespace 数据库中一个独立的命名空间, 和默认命名空间共用数据文件, 但是有自己的索引
+// 命名空间中的数据不会发布变更事件, 也不会加入布隆过滤器
+type Namespace struct {
+	db          *DB
+	id          uint32
+	name        string
+	indexType   IndexerType
+	index       index.Indexer
+	reclaimSize int64 // 命名空间中的无效数据
+	liveSize    int64 // 命名空间中有效数据的大小, 删除命名空间时全部成为无效数据
+	dropped     bool
+}
+
+// CreateNamespace 创建命名空间, 使用和数据库相同的索引类型
+func (db *DB) CreateNamespace(name string) (*Namespace, error) {
+	return db.CreateNamespaceWithOptions(name, NamespaceOptions{IndexType: db.options.IndexType})
+}
+
+// CreateNamespaceWithOptions 按照配置创建命名空间
+// 命名空间的索引在启动时通过重放数据文件构建, 只支持内存索引, 数据库也不能使用持久化索引
+func (db *DB) CreateNamespaceWithOptions(name string, opts NamespaceOptions) (*Namespace, error) {
+	if len(name) == 0 {
+		return nil, ErrNamespaceNameIsEmpty
+	}
+	if err := db.checkWritable(); err != nil {
+		return nil, err
+	}
+	if opts.IndexType == 0 {
+		opts.IndexType = db.options.IndexType
+	}
+	if _, ok := db.index.(index.PersistentIndexer); ok || opts.IndexType == BPlusTree {
+		return nil, ErrNamespaceUnsupported
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.namespaces[name]; ok {
+		return nil, ErrNamespaceExists
+	}
+	id := db.nextNamespaceId
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeqNo([]byte(name), nonTransactionSeqNo),
+		Value: encodeNamespaceMeta(id, opts.IndexType),
+		Type:  data.LogRecordNamespaceCreate,
+	}
+	// 先创建索引, 避免写入了无法重放的记录
+	indexer, err := index.NewIndexer(opts.IndexType, db.options.DirPath, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.appendLogRecord(logRecord); err != nil {
+		return nil, err
+	}
+	return db.registerNamespace(name, id, opts.IndexType, indexer), nil
+}
+
+// Namespace 获取已经创建的命名空间
+func (db *DB) Namespace(name string) (*Namespace, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	ns, ok := db.namespaces[name]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+	return ns, nil
+}
+
+// ListNamespaces 获取所有命名空间的名称
+func (db *DB) ListNamespaces() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	names := make([]string, 0, len(db.namespaces))
+	for name := range db.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DropNamespace 删除命名空间, 只写入一条删除标识并丢弃索引, 数据在 merge 时清理
+func (db *DB) DropNamespace(name string) error {
+	if err := db.checkWritable(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	ns, ok := db.namespaces[name]
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeqNo([]byte(name), nonTransactionSeqNo),
+		Value: encodeNamespaceMeta(ns.id, 0),
+		Type:  data.LogRecordNamespaceDrop,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	_, err = db.replayNamespaceRecord([]byte(name), logRecord, pos)
+	return err
+}
+
+// Name 命名空间的名称
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+// Put 写入命名空间中的数据
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if err := ns.db.checkWritable(); err != nil {
+		return err
+	}
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+	if ns.dropped {
+		return ErrNamespaceDropped
+	}
+	pos, err := ns.db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeqNo(ns.encodeKey(key), nonTransactionSeqNo),
+		Value: value,
+		Type:  data.LogRecordNamespacePut,
+	})
+	if err != nil {
+		return err
+	}
+	return ns.apply(key, data.LogRecordNamespacePut, pos)
+}
+
+// Get 读取命名空间中的数据
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	if ns.dropped {
+		return nil, ErrNamespaceDropped
+	}
+	logRecordPos := ns.index.Get(key)
+	if logRecordPos == nil {
+		return nil, ErrKeyNotFound
+	}
+	return ns.db.getValueByPosition(logRecordPos)
+}
+
+// Delete 删除命名空间中的数据
+func (ns *Namespace) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if err := ns.db.checkWritable(); err != nil {
+		return err
+	}
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+	if ns.dropped {
+		return ErrNamespaceDropped
+	}
+	if pos := ns.index.Get(key); pos == nil {
+		return nil
+	}
+	pos, err := ns.db.appendLogRecord(&data.LogRecord{
+		Key:  logRecordKeyWithSeqNo(ns.encodeKey(key), nonTransactionSeqNo),
+		Type: data.LogRecordNamespaceDelete,
+	})
+	if err != nil {
+		return err
+	}
+	return ns.apply(key, data.LogRecordNamespaceDelete, pos)
+}
+
+// NewIterator 遍历命名空间中的数据
+func (ns *Namespace) NewIterator(opts IteratorOptions) *Iterator {
+	return &Iterator{
+		indexIter: ns.index.Iterator(opts.Reverse),
+		db:        ns.db,
+		options:   opts,
+	}
+}
+
+// NewWriteBatch 创建只写入这个命名空间的 WriteBatch
+func (ns *Namespace) NewWriteBatch(opts WriteBatchOptions) *NamespaceWriteBatch {
+	return ns.db.NewWriteBatch(opts).Namespace(ns)
+}
+
+// ListKeys 获取命名空间中所有的 key
+func (ns *Namespace) ListKeys() [][]byte {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	iterator := ns.index.Iterator(false)
+	defer iterator.Close()
+	keys := make([][]byte, 0, ns.index.Size())
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	return keys
+}
+
+// Stat 返回命名空间的信息, 数据文件和磁盘占用等为整个数据库的信息
+func (ns *Namespace) Stat() *Stat {
+	stat := ns.db.Stat()
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+	stat.KeyNum = uint(ns.index.Size())
+	stat.ReclaimableSize = ns.reclaimSize
+	return stat
+}
+
+// 命名空间中的 key 编码为 命名空间 id | key
+func (ns *Namespace) encodeKey(key []byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(ns.id))
+	return append(buf, key...)
+}
+
+func parseNamespaceKey(nsKey []byte) (uint32, []byte) {
+	id, n := binary.Uvarint(nsKey)
+	return uint32(id), nsKey[n:]
+}
+
+// 创建和删除命名空间的记录中保存 id | 索引类型
+func encodeNamespaceMeta(id uint32, typ IndexerType) []byte {
+	buf := binary.AppendUvarint(nil, uint64(id))
+	return append(buf, byte(typ))
+}
+
+func decodeNamespaceMeta(buf []byte) (uint32, IndexerType) {
+	id, n := binary.Uvarint(buf)
+	var typ IndexerType
+	if n < len(buf) {
+		typ = IndexerType(buf[n])
+	}
+	return uint32(id), typ
+}
+
+// 更新命名空间的索引, 同时维护有效数据和无效数据的大小
+func (ns *Namespace) apply(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) error {
+	var oldPos *data.LogRecordPos
+	var err error
+	if typ == data.LogRecordNamespaceDelete {
+		if oldPos, _, err = ns.index.Delete(key); err != nil {
+			return err
+		}
+		ns.reclaim(int64(pos.Size))
+	} else {
+		if oldPos, err = ns.index.Put(key, pos); err != nil {
+			return err
+		}
+		ns.liveSize += int64(pos.Size)
+	}
+	if oldPos != nil {
+		ns.liveSize -= int64(oldPos.Size)
+		ns.reclaim(int64(oldPos.Size))
+	}
+	return nil
+}
+
+func (ns *Namespace) reclaim(size int64) {
+	ns.reclaimSize += size
+	ns.db.reclaimSize += size
+}
+
+func (db *DB) registerNamespace(name string, id uint32, typ IndexerType, indexer index.Indexer) *Namespace {
+	if old, ok := db.namespaces[name]; ok {
+		db.unregisterNamespace(old)
+	}
+	ns := &Namespace{db: db, id: id, name: name, indexType: typ, index: indexer}
+	db.namespaces[name] = ns
+	db.namespaceIds[id] = ns
+	if id >= db.nextNamespaceId {
+		db.nextNamespaceId = id + 1
+	}
+	return ns
+}
+
+// 删除命名空间之后其中的数据都成为无效数据
+func (db *DB) unregisterNamespace(ns *Namespace) {
+	db.reclaimSize += ns.liveSize
+	if err := ns.index.Close(); err != nil {
+		db.logger.Error("failed to close namespace index", "namespace", ns.name, "error", err)
+	}
+	ns.dropped = true
+	delete(db.namespaces, ns.name)
+	delete(db.namespaceIds, ns.id)
+}
+
+// 重放命名空间相关的记录, 返回 false 表示记录属于默认命名空间
+func (db *DB) replayNamespaceRecord(realKey []byte, logRecord *data.LogRecord, pos *data.LogRecordPos) (bool, error) {
+	switch logRecord.Type {
+	case data.LogRecordNamespaceCreate:
+		id, typ := decodeNamespaceMeta(logRecord.Value)
+		indexer, err := index.NewIndexer(typ, db.options.DirPath, false)
+		if err != nil {
+			return true, err
+		}
+		db.registerNamespace(string(realKey), id, typ, indexer)
+	case data.LogRecordNamespaceDrop:
+		id, _ := decodeNamespaceMeta(logRecord.Value)
+		if id >= db.nextNamespaceId {
+			db.nextNamespaceId = id + 1
+		}
+		if ns, ok := db.namespaceIds[id]; ok {
+			db.unregisterNamespace(ns)
+		}
+		db.reclaimSize += int64(pos.Size)
+	case data.LogRecordNamespacePut, data.LogRecordNamespaceDelete:
+		id, key := parseNamespaceKey(realKey)
+		// 命名空间已经被删除
+		if ns, ok := db.namespaceIds[id]; ok {
+			if err := ns.apply(key, logRecord.Type, pos); err != nil {
+				return true, err
+			}
+		} else {
+			db.reclaimSize += int64(pos.Size)
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// merge 开始时的所有命名空间
+func (db *DB) namespaceSnapshot() map[uint32]*Namespace {
+	namespaces := make(map[uint32]*Namespace, len(db.namespaceIds))
+	for id, ns := range db.namespaceIds {
+		namespaces[id] = ns
+	}
+	return namespaces
+}
+
+// 将命名空间写入 hint 文件, merge 之后创建命名空间的记录只保存在 hint 文件中
+func writeNamespaceHints(hintFile *data.DataFile, namespaces map[uint32]*Namespace) error {
+	ids := make([]uint32, 0, len(namespaces))
+	for id := range namespaces {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids {
+		ns := namespaces[id]
+		encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+			Key:   []byte(ns.name),
+			Value: encodeNamespaceMeta(id, ns.indexType),
+			Type:  data.LogRecordNamespaceCreate,
+		})
+		if err := hintFile.Write(encRecord); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Namespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	orders, err := db.CreateNamespaceWithOptions("orders", NamespaceOptions{IndexType: SkipList})
+	assert.Nil(t, err)
+	_, err = db.CreateNamespace("users")
+	assert.Equal(t, ErrNamespaceExists, err)
+
+	// 相同的 key 在不同的命名空间中互不影响
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("default")))
+	assert.Nil(t, users.Put(utils.GetTestKey(1), []byte("user")))
+	assert.Nil(t, orders.Put(utils.GetTestKey(1), []byte("order")))
+	assert.Nil(t, users.Put(utils.GetTestKey(2), []byte("user")))
+	assert.Nil(t, users.Delete(utils.GetTestKey(2)))
+
+	val, err := users.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	val, err = orders.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("order"), val)
+	_, err = users.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Equal(t, 1, len(db.ListKeys()))
+	assert.Equal(t, 1, len(users.ListKeys()))
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+	assert.Equal(t, uint(1), users.Stat().KeyNum)
+	assert.True(t, users.Stat().ReclaimableSize > 0)
+	assert.Equal(t, int64(0), orders.Stat().ReclaimableSize)
+
+	iter := orders.NewIterator(DefaultIteratorOptions)
+	var keys int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("order"), value)
+		keys++
+	}
+	iter.Close()
+	assert.Equal(t, 1, keys)
+
+	// 重启之后通过重放数据文件恢复命名空间
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders", "users"}, db.ListNamespaces())
+	users, err = db.Namespace("users")
+	assert.Nil(t, err)
+	val, err = users.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	_, err = users.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+}
+
+func TestDB_NamespaceWriteBatch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-batch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	orders, err := db.CreateNamespace("orders")
+	assert.Nil(t, err)
+	assert.Nil(t, orders.Put(utils.GetTestKey(2), []byte("order")))
+
+	// 多个命名空间的数据在一个事务中提交
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("default")))
+	assert.Nil(t, wb.Namespace(users).Put(utils.GetTestKey(1), []byte("user")))
+	assert.Nil(t, wb.Namespace(orders).Delete(utils.GetTestKey(2)))
+	_, err = users.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, wb.Commit())
+
+	val, err := users.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("user"), val)
+	_, err = orders.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	nwb := users.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, nwb.Put(utils.GetTestKey(3), []byte("user")))
+	assert.Nil(t, db.DropNamespace("users"))
+	assert.Equal(t, ErrNamespaceDropped, nwb.Commit())
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders"}, db.ListNamespaces())
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+}
+
+func TestDB_DropNamespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-drop")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	logs, err := db.CreateNamespace("logs")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, users.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+		assert.Nil(t, logs.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+
+	// 覆盖写入和删除之后, 有效数据的大小和索引中的数据一致
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, logs.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+		assert.Nil(t, logs.Delete(utils.GetTestKey(i+50)))
+	}
+	var liveSize int64
+	iterator := logs.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		liveSize += int64(iterator.Value().Size)
+	}
+	iterator.Close()
+	assert.Equal(t, liveSize, logs.liveSize)
+
+	reclaimSize := db.Stat().ReclaimableSize
+	assert.Nil(t, db.DropNamespace("logs"))
+	assert.True(t, db.Stat().ReclaimableSize > reclaimSize+liveSize)
+	assert.Equal(t, ErrNamespaceNotFound, db.DropNamespace("logs"))
+	_, err = logs.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrNamespaceDropped, err)
+	assert.Equal(t, ErrNamespaceDropped, logs.Put(utils.GetTestKey(1), nil))
+
+	// 命名空间 id 不会复用, 重新创建的命名空间中没有旧的数据
+	logs, err = db.CreateNamespace("logs")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(logs.ListKeys()))
+
+	// merge 之后被删除的命名空间中的数据被清理, 命名空间保存在 hint 文件中
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"logs", "users"}, db.ListNamespaces())
+	users, err = db.Namespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), users.Stat().KeyNum)
+	logs, err = db.Namespace("logs")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(logs.ListKeys()))
+	assert.Equal(t, int64(0), db.Stat().ReclaimableSize)
+}
@@ -1,6 +1,10 @@
 package bitcask_go
 
-import "os"
+import (
+	"bitcask-go/data"
+	"log/slog"
+	"os"
+)
 
 type Options struct {
 	DirPath            string      // 数据库文件目录
@@ -10,6 +14,38 @@ type Options struct {
 	IndexType          IndexerType // 索引类型
 	MMapAtStartup      bool        // 启动时是否使用 mmap 加速
 	DataFileMergeRatio float32     // 数据文件合并的阈值
+	BloomFalsePositive float64     // 布隆过滤器的误判率, 为 0 时不启用布隆过滤器
+	ValueCacheSize     int64       // value 缓存的大小, 单位字节, 为 0 时不启用缓存
+	CustomIndexer      string      // 通过 index.Register 注册的自定义索引名称, 不为空时忽略 IndexType
+	ReplicaOf          string      // 主节点复制服务的地址, 不为空时以副本模式运行, 拒绝本地写入
+	ArchiveDir         string      // 归档目录, 不为空时保留切换下来的数据文件并定期写入时间戳, 用于按时间点恢复
+	// 加密数据文件, hint 文件和 merge 完成标识使用的密钥, 为空时不加密
+	// merge 时使用当前的密钥重新加密, 副本需要和主节点同时开启或关闭加密
+	// 持久化的索引以明文保存 key, 设置了密钥时只能使用内存索引, 布隆过滤器文件同样加密
+	KeyProvider KeyProvider
+	// 接收操作的计数和延迟等统计信息, 为空时只在 DB.Metrics 中统计
+	Observer Observer
+	// 接收文件切换, merge, 备份等生命周期事件, 为空时不通知
+	EventListener EventListener
+	// 大于 0 时通过这个长度的队列在单独的协程中通知 EventListener, 为 0 时同步通知
+	// 队列已满时丢弃新的事件并计入 Metrics.DroppedListenerEvents, 不会阻塞持有数据库锁的写入
+	EventQueueSize int
+	// 记录恢复, merge, 文件切换和错误等信息的日志, 为空时使用 slog.Default()
+	Logger *slog.Logger
+	// 磁盘可用空间低于这个字节数时拒绝写入并返回 ErrDiskFull, 读取不受影响, 空间释放之后自动恢复写入
+	// 保留的空间用于 merge 回收无效数据, 为 0 时只在写入返回 ENOSPC 时进入只读状态
+	MinFreeDiskSize uint64
+	// 只读模式, 不获取文件锁, 可以和写入的进程同时打开同一个目录, 不会创建或者修改任何文件
+	// 拒绝写入和 merge, 通过 DB.Refresh 加载写入进程之后追加的数据, 只支持内存索引
+	ReadOnly bool
+}
+
+// KeyProvider 提供加密使用的主密钥
+type KeyProvider = data.KeyProvider
+
+// NewStaticKeyProvider 使用固定的一组密钥, current 为加密新数据使用的密钥 id
+func NewStaticKeyProvider(current uint32, keys map[uint32][]byte) KeyProvider {
+	return data.NewStaticKeyProvider(current, keys)
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -20,12 +56,26 @@ type IteratorOptions struct {
 	Reverse bool
 }
 
+// ScanOptions 范围遍历的配置项
+type ScanOptions struct {
+	// 只遍历以其中任意一个前缀开头的 key, 为空时遍历所有的 key
+	Prefixes [][]byte
+	// 遍历 [Start, End) 范围内的 key, 为空时不限制
+	Start []byte
+	End   []byte
+	// 不为空时从 After 之后开始遍历, 一般是上一页的最后一个 key
+	After []byte
+	// 是否反向, 默认 false 是正向
+	Reverse bool
+}
+
 type IndexerType = int8
 
 const (
 	Btree IndexerType = iota + 1
 	ART
 	BPlusTree
+	SkipList
 )
 
 var DefaultOptions = Options{
@@ -36,6 +86,8 @@ var DefaultOptions = Options{
 	IndexType:          Btree,
 	MMapAtStartup:      true,
 	DataFileMergeRatio: 0.5,
+	BloomFalsePositive: 0.01,
+	ValueCacheSize:     0,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -43,6 +95,48 @@ var DefaultIteratorOptions = IteratorOptions{
 	Reverse: false,
 }
 
+// WatchOptions 订阅 key 变更的配置项
+type WatchOptions struct {
+	// 事件缓冲区的大小
+	BufferSize int
+	// 缓冲区已满时是否阻塞写入, 默认 false 丢弃新的事件
+	// 事件在释放数据库的锁之后发送, 阻塞时只有写入的调用等待, 不影响读取; 消费事件时可以读取数据库,
+	// 但不能在同一个协程里写入, 写入会等待这个订阅取走之前的事件
+	BlockOnFull bool
+}
+
+var DefaultWatchOptions = WatchOptions{
+	BufferSize:  1024,
+	BlockOnFull: false,
+}
+
+// ExportOptions 导出数据的配置项
+type ExportOptions struct {
+	// 导出的格式
+	Format ExportFormat
+	// 只导出以 prefix 为前缀的 key, 默认为空
+	Prefix []byte
+}
+
+// ImportOptions 导入数据的配置项
+type ImportOptions struct {
+	// 只导入以 prefix 为前缀的 key, 默认为空
+	Prefix []byte
+	// 每个 WriteBatch 写入的 key 数量
+	BatchSize uint
+}
+
+var DefaultImportOptions = ImportOptions{
+	Prefix:    nil,
+	BatchSize: 1024,
+}
+
+// NamespaceOptions 命名空间的配置项
+type NamespaceOptions struct {
+	// 命名空间的索引类型, 为 0 时和数据库相同, 不支持持久化索引
+	IndexType IndexerType
+}
+
 type WriteBatchOptions struct {
 	MaxBatchNum uint
 	SyncWrites  bool
@@ -0,0 +1,110 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 检查是否可以写入, 只读模式和副本都拒绝本地写入
+func (db *DB) checkWritable() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.isReplica() {
+		return ErrWriteOnReplica
+	}
+	return nil
+}
+
+// merge 完成标识的修改时间, 写入的进程启动时应用 merge 的结果会替换这个文件, 不存在时为零值
+func mergeFinishedTime(dirPath string) (time.Time, error) {
+	info, err := os.Stat(filepath.Join(dirPath, data.MergeFinishedFileName))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Refresh 只读模式下加载写入的进程在打开之后追加的数据和切换出的新数据文件, 非只读模式下不做处理
+// 写入的进程重启时应用了 merge 的结果, 已经加载的数据文件被替换, 返回 ErrDataFilesReplaced, 需要重新打开
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	mergedAt, err := mergeFinishedTime(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if !mergedAt.Equal(db.mergeFinishedAt) {
+		return ErrDataFilesReplaced
+	}
+	fileIds, err := listDataFileIds(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var loadedNum int
+	var newFileIds []int
+	for _, fid := range fileIds {
+		if db.activeFile == nil || uint32(fid) > db.activeFile.FileId {
+			newFileIds = append(newFileIds, fid)
+		} else {
+			loadedNum++
+		}
+	}
+	loaded := len(db.oldFiles)
+	if db.activeFile != nil {
+		loaded++
+	}
+	if loadedNum != loaded {
+		return ErrDataFilesReplaced
+	}
+
+	// 先打开所有新的数据文件, 失败时不修改已经加载的状态
+	newFiles := make([]*data.DataFile, 0, len(newFileIds))
+	for i, fid := range newFileIds {
+		dataFile, err := db.openDataFile(uint32(fid), i == len(newFileIds)-1)
+		if err != nil {
+			for _, file := range newFiles {
+				_ = file.Close()
+			}
+			return err
+		}
+		newFiles = append(newFiles, dataFile)
+	}
+
+	// 从上次读到的位置继续重放活跃文件, 然后重放新的数据文件
+	replayFileIds := newFileIds
+	var startFileId uint32 = 0
+	var startOffset int64 = 0
+	if db.activeFile != nil {
+		replayFileIds = append([]int{int(db.activeFile.FileId)}, newFileIds...)
+		startFileId, startOffset = db.activeFile.FileId, db.activeFile.WriteOffset
+	}
+	for _, dataFile := range newFiles {
+		if db.activeFile != nil {
+			db.oldFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+	}
+	db.fileIds = append(db.fileIds, newFileIds...)
+	if len(replayFileIds) == 0 {
+		return nil
+	}
+
+	offset, _, err := db.replayDataFiles(replayFileIds, startFileId, startOffset, db.seqNo, db.replicaTxns)
+	if err != nil {
+		return err
+	}
+	db.activeFile.WriteOffset = offset
+	db.logger.Debug("refreshed read-only database", "dir", db.options.DirPath,
+		"new_files", len(newFiles), "keys", db.index.Size())
+	return nil
+}
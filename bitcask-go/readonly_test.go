@@ -0,0 +1,211 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// 在数据文件末尾追加编码之后的记录
+func appendRecords(t *testing.T, dir string, fid uint32, records ...[]byte) {
+	file, err := os.OpenFile(data.GetDataFileName(dir, fid), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	for _, record := range records {
+		_, err = file.Write(record)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, file.Close())
+}
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Sync())
+	files := listDir(t, dir)
+
+	// 写入的进程持有文件锁时也可以只读打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), ro.Stat().KeyNum)
+	val, err := ro.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	assert.Equal(t, ErrReadOnly, ro.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+	assert.Equal(t, ErrReadOnly, ro.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrReadOnly, ro.Merge())
+	assert.Equal(t, ErrReadOnly, ro.DeleteRange(utils.GetTestKey(0), utils.GetTestKey(10)))
+	wb := ro.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+	_, err = ro.CreateNamespace("ns")
+	assert.Equal(t, ErrReadOnly, err)
+
+	// 写入的进程追加数据并切换出新的数据文件, 刷新之后可以读到
+	for i := 1000; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch-key"), []byte("batch-value")))
+	assert.Nil(t, wb.Commit())
+	_, err = ro.Get(utils.GetTestKey(1500))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, ro.Refresh())
+	assert.Equal(t, uint(2000), ro.Stat().KeyNum)
+	_, err = ro.Get(utils.GetTestKey(1500))
+	assert.Nil(t, err)
+	_, err = ro.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = ro.Get([]byte("batch-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch-value"), val)
+
+	// 只读实例不会创建或者修改任何文件
+	assert.Nil(t, ro.Close())
+	assert.Subset(t, listDir(t, dir), files)
+	assert.NotContains(t, listDir(t, dir), data.BloomFilterFileName)
+
+	// 非只读模式下刷新不做处理
+	assert.Nil(t, db.Refresh())
+
+	// 写入的进程正常关闭之后, 只读打开不会删除保存的布隆过滤器
+	assert.Nil(t, db.Close())
+	ro, err = Open(roOpts)
+	assert.Nil(t, err)
+	assert.Nil(t, ro.Close())
+	assert.Contains(t, listDir(t, dir), data.BloomFilterFileName)
+}
+
+func TestDB_ReadOnlyRefreshPartial(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-partial")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Close())
+
+	opts.ReadOnly = true
+	ro, err := Open(opts)
+	defer destroyDB(ro)
+	assert.Nil(t, err)
+
+	// 写入了一半的记录在写完之前不可见
+	record, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecordKeyWithSeqNo([]byte("k2"), nonTransactionSeqNo), Value: []byte("v2")})
+	appendRecords(t, dir, 0, record[:len(record)/2])
+	assert.Nil(t, ro.Refresh())
+	_, err = ro.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	appendRecords(t, dir, 0, record[len(record)/2:])
+	assert.Nil(t, ro.Refresh())
+	val, err := ro.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	// 事务的数据在读到完成标识之后才可见, 完成标识可以在下一次刷新时读到
+	txnRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecordKeyWithSeqNo([]byte("k3"), 100), Value: []byte("v3")})
+	finRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecordKeyWithSeqNo(txnFinKey, 100), Type: data.LogRecordTxnFinished})
+	appendRecords(t, dir, 0, txnRecord)
+	assert.Nil(t, ro.Refresh())
+	_, err = ro.Get([]byte("k3"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	appendRecords(t, dir, 0, finRecord)
+	assert.Nil(t, ro.Refresh())
+	val, err = ro.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+}
+
+func TestDB_ReadOnlyMergeReplaced(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%100), utils.RandomValue(128)))
+	}
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+
+	// 写入的进程重启时应用 merge 的结果, 只读实例需要重新打开
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrDataFilesReplaced, ro.Refresh())
+	assert.Nil(t, ro.Close())
+
+	ro, err = Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), ro.Stat().KeyNum)
+	assert.Nil(t, ro.Refresh())
+	assert.Nil(t, ro.Close())
+}
+
+func TestOpen_ReadOnlyOptions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-options")
+	defer os.RemoveAll(dir)
+	opts.ReadOnly = true
+
+	// 只读模式不会创建数据目录
+	opts.DirPath = filepath.Join(dir, "not-exist")
+	_, err := Open(opts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	opts.DirPath = dir
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+
+	// 空的目录可以打开, 刷新之后读到写入的进程写入的数据
+	opts.IndexType = Btree
+	ro, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(listDir(t, dir)))
+
+	wOpts := opts
+	wOpts.ReadOnly = false
+	db, err := Open(wOpts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, ro.Refresh())
+	val, err := ro.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.Nil(t, ro.Close())
+	assert.Nil(t, db.Close())
+}
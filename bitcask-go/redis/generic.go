@@ -1,11 +1,28 @@
 package redis
 
 import (
+	bitcask "bitcask-go"
 	"errors"
 )
 
+// Del 删除 key, hash/set/list 的元数据和这个版本所有数据的范围删除在一个事务中提交
+// 和其他读取元数据之后再写入的操作一样, 没有和并发修改同一个 key 的操作隔离
+// 数据的 key 以 key | version 为前缀, 没有记录 key 的长度, 其他 key 恰好以这个前缀开头时也会被删除
 func (rds *RedisDataStructure) Del(key []byte) error {
-	return rds.db.Delete(key)
+	metaBuf, err := rds.db.Get(key)
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	_ = wb.Delete(key)
+	if len(metaBuf) > 0 && metaBuf[0] != String {
+		meta := decodeMetadata(metaBuf)
+		_ = wb.DropPrefix(dataKeyPrefix(key, meta.version))
+	}
+	return wb.Commit()
 }
 
 func (rds *RedisDataStructure) Type(key []byte) (redisDataType, error) {
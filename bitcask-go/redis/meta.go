@@ -66,6 +66,14 @@ func decodeMetadata(buf []byte) *metadata {
 	}
 }
 
+// hash/set/list 中的数据都以 key | version 为前缀
+func dataKeyPrefix(key []byte, version int64) []byte {
+	buf := make([]byte, len(key)+8)
+	copy(buf, key)
+	binary.LittleEndian.PutUint64(buf[len(key):], uint64(version))
+	return buf
+}
+
 type hashInternalKey struct {
 	key     []byte
 	version int64
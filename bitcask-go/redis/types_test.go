@@ -48,6 +48,30 @@ func TestRedisDataStructure_Del(t *testing.T) {
 	_, err = rds.Get(utils.GetTestKey(1))
 	assert.Equal(t, bitcask.ErrKeyNotFound, err)
 
+	// 删除 hash 时同时删除所有的 field
+	for i := 0; i < 100; i++ {
+		_, err = rds.HSet(utils.GetTestKey(2), utils.GetTestKey(i), utils.RandomValue(16))
+		assert.Nil(t, err)
+	}
+	err = rds.Del(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rds.db.ListKeys()))
+	val, err := rds.HGet(utils.GetTestKey(2), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Nil(t, val)
+
+	// 删除之后重新写入使用新的版本, 和之前的数据无关
+	ok, err := rds.SAdd(utils.GetTestKey(3), []byte("m1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, rds.Del(utils.GetTestKey(3)))
+	ok, err = rds.SAdd(utils.GetTestKey(3), []byte("m2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SIsMember(utils.GetTestKey(3), []byte("m1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 2, len(rds.db.ListKeys()))
 }
 
 func TestRedisDataStructure_Type(t *testing.T) {
@@ -0,0 +1,549 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 复制协议:
+// 副本连接之后发送复制位置 fid | offset, 主节点返回一个字节的状态,
+// 之后主节点持续发送数据帧 fid | offset | size | record, record 为数据文件中的原始字节,
+// size 为 0 的帧表示主节点切换到了新的数据文件 fid
+const (
+	replicationStatusOK byte = iota
+	replicationStatusCursorLost
+)
+
+const (
+	replicationCursorSize      = 4 + 8
+	replicationFrameHeaderSize = 4 + 8 + 4
+
+	replicaDialTimeout = 3 * time.Second
+	replicaMinBackoff  = 100 * time.Millisecond
+	replicaMaxBackoff  = 5 * time.Second
+)
+
+// ReplicationCursor 复制位置, 指向下一条需要同步的记录
+type ReplicationCursor struct {
+	Fid    uint32
+	Offset int64
+}
+
+func (c ReplicationCursor) encode() []byte {
+	buf := make([]byte, replicationCursorSize)
+	binary.LittleEndian.PutUint32(buf[:4], c.Fid)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(c.Offset))
+	return buf
+}
+
+func decodeReplicationCursor(buf []byte) ReplicationCursor {
+	return ReplicationCursor{
+		Fid:    binary.LittleEndian.Uint32(buf[:4]),
+		Offset: int64(binary.LittleEndian.Uint64(buf[4:])),
+	}
+}
+
+func encodeReplicationFrame(cursor ReplicationCursor, encRecord []byte) []byte {
+	buf := make([]byte, replicationFrameHeaderSize+len(encRecord))
+	binary.LittleEndian.PutUint32(buf[:4], cursor.Fid)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(cursor.Offset))
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(len(encRecord)))
+	copy(buf[replicationFrameHeaderSize:], encRecord)
+	return buf
+}
+
+// ReplicationServer 主节点的复制服务, 将追加写入数据文件的记录推送给副本
+type ReplicationServer struct {
+	db       *DB
+	listener net.Listener
+	lock     *sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   chan struct{}
+	wg       *sync.WaitGroup
+}
+
+// StartReplication 在 addr 上监听副本的连接, 需要在关闭 DB 之前关闭返回的 ReplicationServer
+func (db *DB) StartReplication(addr string) (*ReplicationServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &ReplicationServer{
+		db:       db,
+		listener: listener,
+		lock:     &sync.Mutex{},
+		conns:    make(map[net.Conn]struct{}),
+		closed:   make(chan struct{}),
+		wg:       &sync.WaitGroup{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr 复制服务监听的地址
+func (s *ReplicationServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close 停止监听并断开所有副本的连接
+func (s *ReplicationServer) Close() error {
+	s.lock.Lock()
+	select {
+	case <-s.closed:
+		s.lock.Unlock()
+		return nil
+	default:
+	}
+	close(s.closed)
+	err := s.listener.Close()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *ReplicationServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		select {
+		case <-s.closed:
+			s.lock.Unlock()
+			_ = conn.Close()
+			return
+		default:
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			_ = s.stream(conn)
+			s.lock.Lock()
+			delete(s.conns, conn)
+			s.lock.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+// 向一个副本持续发送数据
+func (s *ReplicationServer) stream(conn net.Conn) error {
+	buf := make([]byte, replicationCursorSize)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	cursor := decodeReplicationCursor(buf)
+	if err := s.db.checkReplicationCursor(cursor); err != nil {
+		_, _ = conn.Write([]byte{replicationStatusCursorLost})
+		return err
+	}
+	if _, err := conn.Write([]byte{replicationStatusOK}); err != nil {
+		return err
+	}
+
+	for {
+		frame, next, wait, err := s.db.readReplicationFrame(cursor)
+		if err != nil {
+			return err
+		}
+		// 已经同步到最新的位置, 等待新的数据写入
+		if wait != nil {
+			select {
+			case <-wait:
+				continue
+			case <-s.closed:
+				return nil
+			}
+		}
+		if _, err := conn.Write(frame); err != nil {
+			return err
+		}
+		cursor = next
+	}
+}
+
+// 检查副本的复制位置是否仍然有效
+// merge 之后小于 nonMergeFileId 的数据文件被重写, 副本的位置如果在其中则无法继续同步
+func (db *DB) checkReplicationCursor(cursor ReplicationCursor) error {
+	if cursor.Fid == 0 && cursor.Offset == 0 {
+		return nil
+	}
+	mergeFinishedFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinishedFileName); os.IsNotExist(err) {
+		return nil
+	}
+	nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if cursor.Fid < nonMergeFileId {
+		return ErrReplicationCursorLost
+	}
+	return nil
+}
+
+// 读取复制位置上的一条记录, 返回编码后的数据帧和下一个复制位置
+// 没有新的数据时返回一个 channel, 在下一次写入数据之后被关闭
+func (db *DB) readReplicationFrame(cursor ReplicationCursor) ([]byte, ReplicationCursor, <-chan struct{}, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// 空的数据库
+	if db.activeFile == nil {
+		return nil, cursor, db.waitAppend(), nil
+	}
+
+	var dataFile *data.DataFile
+	if cursor.Fid == db.activeFile.FileId {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.oldFiles[cursor.Fid]
+	}
+	if dataFile == nil {
+		// 从头开始同步, 跳转到第一个数据文件
+		if cursor.Fid == 0 && cursor.Offset == 0 {
+			next := ReplicationCursor{Fid: db.firstDataFileIdFrom(0)}
+			return encodeReplicationFrame(next, nil), next, nil, nil
+		}
+		return nil, cursor, nil, ErrReplicationCursorLost
+	}
+	if dataFile == db.activeFile && cursor.Offset >= dataFile.WriteOffset {
+		return nil, cursor, db.waitAppend(), nil
+	}
+
+	logRecord, size, err := dataFile.ReadLogRecord(cursor.Offset)
+	if err == io.EOF {
+		// 旧的数据文件已经读完, 切换到下一个数据文件
+		next := ReplicationCursor{Fid: db.firstDataFileIdFrom(cursor.Fid + 1)}
+		return encodeReplicationFrame(next, nil), next, nil, nil
+	}
+	if err != nil {
+		return nil, cursor, nil, err
+	}
+	encRecord, _ := data.EncodeLogRecord(logRecord)
+	next := ReplicationCursor{Fid: cursor.Fid, Offset: cursor.Offset + size}
+	return encodeReplicationFrame(cursor, encRecord), next, nil, nil
+}
+
+// 返回大于等于 fid 的最小数据文件 id, 活跃文件一定满足条件
+func (db *DB) firstDataFileIdFrom(fid uint32) uint32 {
+	first := db.activeFile.FileId
+	for fileId := range db.oldFiles {
+		if fileId >= fid && fileId < first {
+			first = fileId
+		}
+	}
+	return first
+}
+
+// 获取等待新数据写入的 channel
+func (db *DB) waitAppend() <-chan struct{} {
+	db.notifyLock.Lock()
+	defer db.notifyLock.Unlock()
+	if db.appendNotify == nil {
+		db.appendNotify = make(chan struct{})
+	}
+	return db.appendNotify
+}
+
+// 通知等待新数据的复制连接, 调用时需要持有 db.mu 的写锁
+func (db *DB) notifyAppend() {
+	db.notifyLock.Lock()
+	defer db.notifyLock.Unlock()
+	if db.appendNotify != nil {
+		close(db.appendNotify)
+		db.appendNotify = nil
+	}
+}
+
+func (db *DB) isReplica() bool {
+	return db.options.ReplicaOf != ""
+}
+
+// ReplicationCursor 副本当前的复制位置
+func (db *DB) ReplicationCursor() ReplicationCursor {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.activeFile == nil {
+		return ReplicationCursor{}
+	}
+	return ReplicationCursor{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOffset}
+}
+
+// ReplicationError 副本因为无法恢复的错误停止同步时返回该错误
+func (db *DB) ReplicationError() error {
+	if db.replicator == nil {
+		return nil
+	}
+	return db.replicator.error()
+}
+
+// 在副本上应用从主节点同步的记录, 副本的数据文件和主节点保持逐字节一致, 因此本地的写入位置就是复制位置
+func (db *DB) applyReplicatedRecord(cursor ReplicationCursor, encRecord []byte) error {
+	var pub *publication
+	defer func() { pub.deliver() }()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.switchReplicaDataFile(cursor.Fid); err != nil {
+		return err
+	}
+	if db.activeFile.WriteOffset != cursor.Offset {
+		return ErrReplicationOutOfOrder
+	}
+	// 切换数据文件
+	if len(encRecord) == 0 {
+		return nil
+	}
+
+	logRecord, size, err := data.DecodeLogRecord(encRecord)
+	if err != nil {
+		return err
+	}
+	if err := db.checkDiskSpace(); err != nil {
+		return err
+	}
+	if err := db.writeActiveFile(encRecord[:size]); err != nil {
+		return err
+	}
+	// 加密之后写入的长度和编码的长度不同
+	size = db.activeFile.WriteOffset - cursor.Offset
+	if db.options.SyncWrites {
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+	}
+	db.notifyAppend()
+
+	// 和启动时重放数据文件一样, 事务的数据在读到事务完成的标识之后才更新索引
+	pos := &data.LogRecordPos{Fid: cursor.Fid, Offset: cursor.Offset, Size: uint32(size)}
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if seqNo > db.seqNo {
+		db.seqNo = seqNo
+	}
+	var entries []*index.BatchEntry
+	var events []Event
+	if logRecord.Type == data.LogRecordTimestamp {
+		return nil
+	} else if seqNo == nonTransactionSeqNo {
+		if ok, err := db.replayNamespaceRecord(realKey, logRecord, pos); ok || err != nil {
+			return err
+		}
+		if logRecord.Type == data.LogRecordRangeDelete {
+			db.reclaimSize += int64(pos.Size)
+			if events, err = db.deleteRangeFromIndex(realKey, logRecord.Value, pos, true); err != nil {
+				return err
+			}
+			pub = db.publish(events)
+			return nil
+		}
+		entries = append(entries, db.replayEntry(realKey, logRecord.Type, pos))
+		events = append(events, replicatedEvent(realKey, logRecord))
+	} else if logRecord.Type == data.LogRecordTxnFinished {
+		for _, txnRecord := range db.replicaTxns[seqNo] {
+			realKey, _ = parseLogRecordKey(txnRecord.Record.Key)
+			if ok, err := db.replayNamespaceRecord(realKey, txnRecord.Record, txnRecord.Pos); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+			// 事务中的范围删除在其他数据之前写入, 和主节点一样先于其他数据应用
+			if txnRecord.Record.Type == data.LogRecordRangeDelete {
+				db.reclaimSize += int64(txnRecord.Pos.Size)
+				rangeEvents, err := db.deleteRangeFromIndex(realKey, txnRecord.Record.Value, txnRecord.Pos, true)
+				if err != nil {
+					return err
+				}
+				events = append(events, rangeEvents...)
+				continue
+			}
+			entries = append(entries, db.replayEntry(realKey, txnRecord.Record.Type, txnRecord.Pos))
+			events = append(events, replicatedEvent(realKey, txnRecord.Record))
+		}
+		delete(db.replicaTxns, seqNo)
+	} else {
+		db.replicaTxns[seqNo] = append(db.replicaTxns[seqNo], &data.TransactionRecord{
+			Record: logRecord,
+			Pos:    pos,
+		})
+		return nil
+	}
+	if len(entries) == 0 && len(events) == 0 {
+		return nil
+	}
+	if len(entries) > 0 {
+		if err := db.applyToIndex(entries, pos); err != nil {
+			return err
+		}
+	}
+	pub = db.publish(events)
+	return nil
+}
+
+func replicatedEvent(key []byte, logRecord *data.LogRecord) Event {
+	if logRecord.Type == data.LogRecordTypeDeleted {
+		return Event{Type: EventDelete, Key: key}
+	}
+	return Event{Type: EventPut, Key: key, Value: logRecord.Value}
+}
+
+// 副本跟随主节点切换数据文件
+func (db *DB) switchReplicaDataFile(fid uint32) error {
+	if db.activeFile != nil {
+		if db.activeFile.FileId == fid {
+			return nil
+		}
+		if fid < db.activeFile.FileId {
+			return ErrReplicationOutOfOrder
+		}
+		if err := db.syncActiveFile(); err != nil {
+			return err
+		}
+		db.oldFiles[db.activeFile.FileId] = db.activeFile
+		if err := db.archiveDataFile(db.activeFile); err != nil {
+			return err
+		}
+	}
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFileIO)
+	if err != nil {
+		return err
+	}
+	dataFile.SetKeyProvider(db.options.KeyProvider)
+	if db.activeFile != nil {
+		db.notifyFileRotated(db.activeFile, dataFile)
+	}
+	db.activeFile = dataFile
+	return nil
+}
+
+// 副本的同步协程, 断开连接之后按照指数退避重连, 并从本地的复制位置继续同步
+type replicator struct {
+	db     *DB
+	addr   string
+	lock   *sync.Mutex
+	conn   net.Conn
+	err    error
+	closed chan struct{}
+	done   chan struct{}
+}
+
+func newReplicator(db *DB, addr string) *replicator {
+	return &replicator{
+		db:     db,
+		addr:   addr,
+		lock:   &sync.Mutex{},
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (r *replicator) run() {
+	defer close(r.done)
+	backoff := replicaMinBackoff
+	for {
+		connected, err := r.sync()
+		if errors.Is(err, ErrReplicationCursorLost) || errors.Is(err, ErrReplicationOutOfOrder) ||
+			errors.Is(err, data.ErrInvalidCRC) {
+			r.db.logger.Error("replication stopped", "primary", r.addr, "error", err)
+			r.lock.Lock()
+			r.err = err
+			r.lock.Unlock()
+			return
+		}
+		if connected {
+			backoff = replicaMinBackoff
+		}
+		if err != nil {
+			r.db.logger.Warn("replication disconnected, retrying", "primary", r.addr, "backoff", backoff, "error", err)
+		}
+		select {
+		case <-r.closed:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > replicaMaxBackoff {
+			backoff = replicaMaxBackoff
+		}
+	}
+}
+
+// 连接主节点并同步数据, 直到连接断开, 返回是否成功建立过连接
+func (r *replicator) sync() (bool, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, replicaDialTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	r.lock.Lock()
+	select {
+	case <-r.closed:
+		r.lock.Unlock()
+		return false, nil
+	default:
+	}
+	r.conn = conn
+	r.lock.Unlock()
+
+	if _, err := conn.Write(r.db.ReplicationCursor().encode()); err != nil {
+		return false, err
+	}
+	status := make([]byte, 1)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return false, err
+	}
+	if status[0] == replicationStatusCursorLost {
+		return true, ErrReplicationCursorLost
+	}
+
+	header := make([]byte, replicationFrameHeaderSize)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return true, err
+		}
+		cursor := decodeReplicationCursor(header[:replicationCursorSize])
+		encRecord := make([]byte, binary.LittleEndian.Uint32(header[replicationCursorSize:]))
+		if _, err := io.ReadFull(conn, encRecord); err != nil {
+			return true, err
+		}
+		if err := r.db.applyReplicatedRecord(cursor, encRecord); err != nil {
+			return true, err
+		}
+	}
+}
+
+func (r *replicator) error() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err
+}
+
+func (r *replicator) close() {
+	r.lock.Lock()
+	close(r.closed)
+	if r.conn != nil {
+		_ = r.conn.Close()
+	}
+	r.lock.Unlock()
+	<-r.done
+}
@@ -0,0 +1,183 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// 等待副本同步到主节点的最新位置
+func waitReplicaSynced(t *testing.T, primary, replica *DB) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if replica.ReplicationCursor() == primary.ReplicationCursor() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("replica is not synced, primary %+v, replica %+v",
+		primary.ReplicationCursor(), replica.ReplicationCursor())
+}
+
+func assertSameData(t *testing.T, primary, replica *DB) {
+	expected := make(map[string]string)
+	err := primary.Fold(func(key []byte, value []byte) bool {
+		expected[string(key)] = string(value)
+		return true
+	})
+	assert.Nil(t, err)
+	actual := make(map[string]string)
+	err = replica.Fold(func(key []byte, value []byte) bool {
+		actual[string(key)] = string(value)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
+func openReplicationPair(t *testing.T) (*DB, *ReplicationServer, Options) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-primary")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	primary, err := Open(opts)
+	assert.Nil(t, err)
+
+	server, err := primary.StartReplication("127.0.0.1:0")
+	assert.Nil(t, err)
+
+	replicaOpts := DefaultOptions
+	replicaDir, _ := os.MkdirTemp("", "bitcask-go-replica")
+	replicaOpts.DirPath = replicaDir
+	replicaOpts.ReplicaOf = server.Addr().String()
+	return primary, server, replicaOpts
+}
+
+func TestDB_Replication(t *testing.T) {
+	primary, server, replicaOpts := openReplicationPair(t)
+	defer destroyDB(primary)
+	defer server.Close()
+
+	// 写入的数据跨越多个数据文件
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, primary.Delete(utils.GetTestKey(i)))
+	}
+	assert.Greater(t, len(primary.oldFiles), 0)
+
+	replica, err := Open(replicaOpts)
+	assert.Nil(t, err)
+	defer destroyDB(replica)
+	waitReplicaSynced(t, primary, replica)
+	assertSameData(t, primary, replica)
+
+	// 连接之后写入的数据, 包括事务
+	wb := primary.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 100; i < 150; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, wb.Delete(utils.GetTestKey(50)))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, primary.Put(utils.GetTestKey(20), []byte("new-value")))
+	waitReplicaSynced(t, primary, replica)
+	assertSameData(t, primary, replica)
+
+	val, err := replica.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new-value"), val)
+	_, err = replica.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 事务中的范围删除
+	wb = primary.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.DeleteRange(utils.GetTestKey(100), utils.GetTestKey(120)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(110), []byte("in-range")))
+	assert.Nil(t, wb.Commit())
+	waitReplicaSynced(t, primary, replica)
+	assertSameData(t, primary, replica)
+	val, err = replica.Get(utils.GetTestKey(110))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("in-range"), val)
+
+	// 副本拒绝本地写入
+	assert.Equal(t, ErrWriteOnReplica, replica.Put(utils.GetTestKey(1), utils.RandomValue(8)))
+	assert.Equal(t, ErrWriteOnReplica, replica.Delete(utils.GetTestKey(30)))
+	wb = replica.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), utils.RandomValue(8)))
+	assert.Equal(t, ErrWriteOnReplica, wb.Commit())
+	assert.Nil(t, replica.ReplicationError())
+}
+
+func TestDB_ReplicationResume(t *testing.T) {
+	primary, server, replicaOpts := openReplicationPair(t)
+	defer func() {
+		destroyDB(primary)
+	}()
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	replica, err := Open(replicaOpts)
+	assert.Nil(t, err)
+	waitReplicaSynced(t, primary, replica)
+
+	// 事务的数据只同步了一半时副本重启
+	primary.mu.Lock()
+	for i := 50; i < 60; i++ {
+		_, err := primary.appendLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeqNo(utils.GetTestKey(i), 100),
+			Value: utils.RandomValue(64),
+		})
+		assert.Nil(t, err)
+	}
+	primary.mu.Unlock()
+	waitReplicaSynced(t, primary, replica)
+	_, err = replica.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, replica.Close())
+
+	primary.mu.Lock()
+	_, err = primary.appendLogRecord(&data.LogRecord{
+		Key:  logRecordKeyWithSeqNo(txnFinKey, 100),
+		Type: data.LogRecordTxnFinished,
+	})
+	assert.Nil(t, err)
+	primary.mu.Unlock()
+
+	// 主节点重启之后从数据文件中加载完整的事务
+	addr := server.Addr().String()
+	assert.Nil(t, server.Close())
+	assert.Nil(t, primary.Close())
+	primary, err = Open(primary.options)
+	assert.Nil(t, err)
+	server, err = primary.StartReplication(addr)
+	assert.Nil(t, err)
+	for i := 60; i < 100; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+
+	replica, err = Open(replicaOpts)
+	assert.Nil(t, err)
+	defer destroyDB(replica)
+	waitReplicaSynced(t, primary, replica)
+	assertSameData(t, primary, replica)
+	_, err = replica.Get(utils.GetTestKey(55))
+	assert.Nil(t, err)
+
+	// 主节点的复制服务断开, 副本重连之后继续同步
+	assert.Nil(t, server.Close())
+	for i := 100; i < 150; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	server, err = primary.StartReplication(addr)
+	assert.Nil(t, err)
+	defer server.Close()
+	waitReplicaSynced(t, primary, replica)
+	assertSameData(t, primary, replica)
+	assert.Nil(t, replica.ReplicationError())
+}
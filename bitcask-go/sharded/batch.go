@@ -0,0 +1,104 @@
+package sharded
+
+import (
+	bitcask "bitcask-go"
+	"sync"
+)
+
+type pendingWrite struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// WriteBatch 按照分片拆分的批量写入
+// 每个分片内的写入是原子的, 不同分片之间不保证原子性
+type WriteBatch struct {
+	options       bitcask.WriteBatchOptions
+	mu            *sync.Mutex
+	db            *DB
+	pendingWrites map[string]*pendingWrite
+}
+
+func (db *DB) NewWriteBatch(opts bitcask.WriteBatchOptions) *WriteBatch {
+	return &WriteBatch{
+		options:       opts,
+		mu:            &sync.Mutex{},
+		db:            db,
+		pendingWrites: make(map[string]*pendingWrite),
+	}
+}
+
+func (wb *WriteBatch) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.pendingWrites[string(key)] = &pendingWrite{key: key, value: value}
+	return nil
+}
+
+func (wb *WriteBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.pendingWrites[string(key)] = &pendingWrite{key: key, delete: true}
+	return nil
+}
+
+// Commit 将写入按照所属的分片分组, 依次提交每个分片的批量写入
+func (wb *WriteBatch) Commit() error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if len(wb.pendingWrites) == 0 {
+		return nil
+	}
+	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
+		return bitcask.ErrExceedMaxBatchNum
+	}
+
+	db := wb.db
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	batches := make(map[int]*bitcask.WriteBatch)
+	batch := func(i int) *bitcask.WriteBatch {
+		if batches[i] == nil {
+			batches[i] = db.shards[i].NewWriteBatch(wb.options)
+		}
+		return batches[i]
+	}
+	for _, write := range wb.pendingWrites {
+		owner := db.ring.get(write.key)
+		if !write.delete {
+			if err := batch(owner).Put(write.key, write.value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := batch(owner).Delete(write.key); err != nil {
+			return err
+		}
+		// 迁移过程中同时删除旧分片中的数据
+		if db.oldRing != nil {
+			if old := db.oldRing.get(write.key); old != owner {
+				if err := batch(old).Delete(write.key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for i := range db.shards {
+		if batches[i] == nil {
+			continue
+		}
+		if err := batches[i].Commit(); err != nil {
+			return err
+		}
+	}
+	wb.pendingWrites = make(map[string]*pendingWrite)
+	return nil
+}
@@ -0,0 +1,94 @@
+package sharded
+
+import (
+	bitcask "bitcask-go"
+	"bytes"
+)
+
+// Iterator 将所有分片的迭代器按照 key 的顺序合并
+type Iterator struct {
+	iters   []*bitcask.Iterator
+	ring    *ring
+	options bitcask.IteratorOptions
+	current int // 当前 key 所在的迭代器, -1 表示已经遍历结束
+}
+
+// NewIterator 创建合并所有分片的迭代器
+func (db *DB) NewIterator(opts bitcask.IteratorOptions) *Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	iters := make([]*bitcask.Iterator, len(db.shards))
+	for i, shard := range db.shards {
+		iters[i] = shard.NewIterator(opts)
+	}
+	return &Iterator{iters: iters, ring: db.ring, options: opts, current: -1}
+}
+
+func (it *Iterator) Rewind() {
+	for _, iter := range it.iters {
+		iter.Rewind()
+	}
+	it.pick()
+}
+
+func (it *Iterator) Seek(key []byte) {
+	for _, iter := range it.iters {
+		iter.Seek(key)
+	}
+	it.pick()
+}
+
+// Next 跳过所有分片中等于当前 key 的数据
+func (it *Iterator) Next() {
+	if it.current < 0 {
+		return
+	}
+	key := it.iters[it.current].Key()
+	for _, iter := range it.iters {
+		if iter.Valid() && bytes.Equal(iter.Key(), key) {
+			iter.Next()
+		}
+	}
+	it.pick()
+}
+
+func (it *Iterator) Valid() bool {
+	return it.current >= 0
+}
+
+func (it *Iterator) Key() []byte {
+	return it.iters[it.current].Key()
+}
+
+func (it *Iterator) Value() ([]byte, error) {
+	return it.iters[it.current].Value()
+}
+
+func (it *Iterator) Close() {
+	for _, iter := range it.iters {
+		iter.Close()
+	}
+}
+
+// 选出所有分片中最小的 key, 反向遍历时选出最大的 key
+// 迁移过程中同一个 key 可能同时存在于新旧两个分片, 以 key 所属的分片为准
+func (it *Iterator) pick() {
+	it.current = -1
+	var key []byte
+	for i, iter := range it.iters {
+		if !iter.Valid() {
+			continue
+		}
+		if it.current < 0 {
+			it.current, key = i, iter.Key()
+			continue
+		}
+		cmp := bytes.Compare(iter.Key(), key)
+		if it.options.Reverse {
+			cmp = -cmp
+		}
+		if cmp < 0 || (cmp == 0 && it.ring.get(key) == i) {
+			it.current, key = i, iter.Key()
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package sharded
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// 一致性哈希环, 每个分片在环上对应多个虚拟节点, 使 key 分布更加均匀
+type ring struct {
+	hashes []uint32       // 排好序的虚拟节点哈希值
+	owners map[uint32]int // 虚拟节点对应的分片下标
+}
+
+func newRing(names []string, virtualNodes int) *ring {
+	r := &ring{owners: make(map[uint32]int, len(names)*virtualNodes)}
+	for i, name := range names {
+		for v := 0; v < virtualNodes; v++ {
+			hash := crc32.ChecksumIEEE([]byte(name + "#" + strconv.Itoa(v)))
+			// 哈希冲突时保留先加入的分片
+			if _, ok := r.owners[hash]; ok {
+				continue
+			}
+			r.owners[hash] = i
+			r.hashes = append(r.hashes, hash)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+	return r
+}
+
+// 返回 key 所属分片的下标, 即顺时针方向第一个虚拟节点对应的分片
+func (r *ring) get(key []byte) int {
+	hash := crc32.ChecksumIEEE(key)
+	idx := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= hash
+	})
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return r.owners[r.hashes[idx]]
+}
@@ -0,0 +1,35 @@
+package sharded
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRing_Get(t *testing.T) {
+	r := newRing([]string{"shard-000", "shard-001", "shard-002"}, 160)
+	counts := make([]int, 3)
+	for i := 0; i < 30000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		owner := r.get(key)
+		// 相同的 key 总是路由到相同的分片
+		assert.Equal(t, owner, r.get(key))
+		counts[owner]++
+	}
+	for _, count := range counts {
+		assert.Greater(t, count, 5000)
+	}
+
+	// 添加分片之后, 只有属于新分片的 key 会改变位置
+	r2 := newRing([]string{"shard-000", "shard-001", "shard-002", "shard-003"}, 160)
+	moved := 0
+	for i := 0; i < 30000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if owner := r2.get(key); owner != r.get(key) {
+			assert.Equal(t, 3, owner)
+			moved++
+		}
+	}
+	assert.Greater(t, moved, 3000)
+	assert.Less(t, moved, 12000)
+}
@@ -0,0 +1,369 @@
+// Package sharded 将数据分散到多个 DB 实例, 通过一致性哈希路由 key, 对外提供和 DB 相同的读写接口
+package sharded
+
+import (
+	bitcask "bitcask-go"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidShardNum     = errors.New("shard num must be greater than 0")
+	ErrMigrationInProgress = errors.New("shard migration is in progress")
+)
+
+const (
+	shardDirPrefix = "shard-"
+	// 记录正在迁移的分片名称, 重启之后继续迁移
+	migrationFileName = "migration"
+	// 每次加锁迁移的 key 数量
+	migrateBatchSize = 256
+)
+
+// Options 分片 DB 的配置
+type Options struct {
+	DirPath      string          // 数据目录, 每个分片使用其中的一个子目录
+	ShardNum     int             // 初始的分片数量, 目录中已有分片时忽略, 以已有的分片为准, 通过 AddShard 增加分片
+	VirtualNodes int             // 每个分片在哈希环上的虚拟节点数量
+	ShardOptions bitcask.Options // 每个分片的配置, DirPath 会被忽略
+}
+
+var DefaultOptions = Options{
+	DirPath:      filepath.Join(os.TempDir(), "bitcask-go-sharded"),
+	ShardNum:     4,
+	VirtualNodes: 160,
+	ShardOptions: bitcask.DefaultOptions,
+}
+
+// DB 管理多个 DB 实例, 每个 key 只属于一个分片
+type DB struct {
+	options Options
+	lock    *sync.RWMutex // 迁移 key 时持有写锁, 读写持有读锁
+	names   []string      // 分片的名称, 即子目录名称
+	shards  []*bitcask.DB
+	ring    *ring // 包含所有分片的哈希环
+
+	// 迁移过程中 key 可能还在旧的分片中, 读取时先查找新的分片, 再查找旧的分片
+	oldRing   *ring
+	migration *migration
+}
+
+// 一次后台迁移
+type migration struct {
+	target int // 新分片的下标
+	stop   chan struct{}
+	done   chan struct{}
+	err    error
+}
+
+// Open 打开分片 DB
+func Open(options Options) (*DB, error) {
+	if options.ShardNum <= 0 {
+		return nil, ErrInvalidShardNum
+	}
+	if options.VirtualNodes <= 0 {
+		options.VirtualNodes = DefaultOptions.VirtualNodes
+	}
+	if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	names, err := loadShardNames(options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	// 已有的分片中保存着数据, 增加分片需要通过 AddShard 迁移 key, 这里只在新目录中创建分片
+	if len(names) == 0 {
+		for i := 0; i < options.ShardNum; i++ {
+			names = append(names, shardName(i))
+		}
+	}
+
+	db := &DB{options: options, lock: &sync.RWMutex{}}
+	for _, name := range names {
+		if err := db.openShard(name); err != nil {
+			_ = db.closeShards()
+			return nil, err
+		}
+	}
+
+	// 上次的迁移没有完成, 继续迁移
+	migrating, err := os.ReadFile(filepath.Join(options.DirPath, migrationFileName))
+	if err != nil && !os.IsNotExist(err) {
+		_ = db.closeShards()
+		return nil, err
+	}
+	if len(migrating) > 0 {
+		name := string(migrating)
+		// 记录迁移之后还没有来得及创建新的分片
+		if !containsName(db.names, name) {
+			if err := db.openShard(name); err != nil {
+				_ = db.closeShards()
+				return nil, err
+			}
+		}
+		db.startMigration(name)
+	}
+	return db, nil
+}
+
+// 按照名称顺序加载已有的分片, 保证每次打开时哈希环相同
+func loadShardNames(dirPath string) ([]string, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), shardDirPrefix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func shardName(i int) string {
+	return fmt.Sprintf("%s%03d", shardDirPrefix, i)
+}
+
+func (db *DB) openShard(name string) error {
+	options := db.options.ShardOptions
+	options.DirPath = filepath.Join(db.options.DirPath, name)
+	shard, err := bitcask.Open(options)
+	if err != nil {
+		return err
+	}
+	db.names = append(db.names, name)
+	db.shards = append(db.shards, shard)
+	db.ring = newRing(db.names, db.options.VirtualNodes)
+	return nil
+}
+
+// Put 写入数据到 key 所属的分片
+func (db *DB) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.shards[db.ring.get(key)].Put(key, value)
+}
+
+// Get 从 key 所属的分片读取数据
+func (db *DB) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, bitcask.ErrKeyIsEmpty
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	value, err := db.shards[db.ring.get(key)].Get(key)
+	if err == bitcask.ErrKeyNotFound && db.oldRing != nil {
+		return db.shards[db.oldRing.get(key)].Get(key)
+	}
+	return value, err
+}
+
+// Delete 删除数据, 迁移过程中同时删除旧分片中的数据
+func (db *DB) Delete(key []byte) error {
+	if len(key) == 0 {
+		return bitcask.ErrKeyIsEmpty
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	owner := db.ring.get(key)
+	if err := db.shards[owner].Delete(key); err != nil {
+		return err
+	}
+	if db.oldRing != nil {
+		if old := db.oldRing.get(key); old != owner {
+			return db.shards[old].Delete(key)
+		}
+	}
+	return nil
+}
+
+// ListKeys 获取所有分片中的 key, 按照 key 排序
+func (db *DB) ListKeys() [][]byte {
+	iter := db.NewIterator(bitcask.DefaultIteratorOptions)
+	defer iter.Close()
+	var keys [][]byte
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	return keys
+}
+
+// ShardNum 返回分片数量
+func (db *DB) ShardNum() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return len(db.shards)
+}
+
+// AddShard 添加一个分片, 并在后台将属于新分片的 key 迁移过去
+// 迁移过程中可以正常读写, 同一时间只能有一个迁移
+func (db *DB) AddShard() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if db.migration != nil {
+		return ErrMigrationInProgress
+	}
+
+	name := shardName(len(db.names))
+	// 先记录迁移的分片, 避免新分片创建之后进程退出, 重启时找不到旧分片中的 key
+	if err := os.WriteFile(filepath.Join(db.options.DirPath, migrationFileName), []byte(name), 0644); err != nil {
+		return err
+	}
+	if err := db.openShard(name); err != nil {
+		_ = os.Remove(filepath.Join(db.options.DirPath, migrationFileName))
+		return err
+	}
+	db.startMigration(name)
+	return nil
+}
+
+// WaitMigration 等待后台迁移完成, 返回迁移的错误, 没有迁移时直接返回
+func (db *DB) WaitMigration() error {
+	db.lock.RLock()
+	m := db.migration
+	db.lock.RUnlock()
+	if m == nil {
+		return nil
+	}
+	<-m.done
+	return m.err
+}
+
+// 调用方需要持有写锁或者还未对外提供服务
+func (db *DB) startMigration(name string) {
+	m := &migration{stop: make(chan struct{}), done: make(chan struct{})}
+	var oldNames []string
+	for i, n := range db.names {
+		if n == name {
+			m.target = i
+		} else {
+			oldNames = append(oldNames, n)
+		}
+	}
+	db.oldRing = newRing(oldNames, db.options.VirtualNodes)
+	db.migration = m
+	go db.migrate(m)
+}
+
+func (db *DB) migrate(m *migration) {
+	defer close(m.done)
+	for i := range db.shards {
+		if i == m.target {
+			continue
+		}
+		keys := db.shards[i].ListKeys()
+		for start := 0; start < len(keys); start += migrateBatchSize {
+			select {
+			case <-m.stop:
+				return
+			default:
+			}
+			end := start + migrateBatchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+			if err := db.migrateKeys(i, keys[start:end]); err != nil {
+				m.err = err
+				return
+			}
+		}
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	if err := os.Remove(filepath.Join(db.options.DirPath, migrationFileName)); err != nil {
+		m.err = err
+		return
+	}
+	db.oldRing = nil
+	db.migration = nil
+}
+
+// 将分片中不再属于它的 key 移动到新的分片
+// 新分片中已经存在的 key 是迁移开始之后写入的, 不能被覆盖
+func (db *DB) migrateKeys(from int, keys [][]byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for _, key := range keys {
+		owner := db.ring.get(key)
+		if owner == from {
+			continue
+		}
+		value, err := db.shards[from].Get(key)
+		if err == bitcask.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = db.shards[owner].Get(key)
+		if err == bitcask.ErrKeyNotFound {
+			err = db.shards[owner].Put(key, value)
+		}
+		if err != nil {
+			return err
+		}
+		if err := db.shards[from].Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync 持久化所有分片的数据
+func (db *DB) Sync() error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	for _, shard := range db.shards {
+		if err := shard.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close 停止迁移并关闭所有分片, 未完成的迁移在下次打开时继续
+func (db *DB) Close() error {
+	db.lock.RLock()
+	m := db.migration
+	db.lock.RUnlock()
+	if m != nil {
+		close(m.stop)
+		<-m.done
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.migration = nil
+	return db.closeShards()
+}
+
+func (db *DB) closeShards() error {
+	var firstErr error
+	for _, shard := range db.shards {
+		if err := shard.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,232 @@
+package sharded
+
+import (
+	bitcask "bitcask-go"
+	"bitcask-go/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func openShardedDB(t *testing.T, dir string, shardNum int) *DB {
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.ShardNum = shardNum
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db
+}
+
+func TestDB_PutGetDelete(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded")
+	defer os.RemoveAll(dir)
+	db := openShardedDB(t, dir, 3)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 1000; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+	// 每个分片都分到了数据
+	for _, shard := range db.shards {
+		assert.Greater(t, len(shard.ListKeys()), 0)
+	}
+
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	_, err := db.Get(utils.GetTestKey(1))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	assert.Equal(t, bitcask.ErrKeyIsEmpty, db.Put(nil, []byte("v")))
+	assert.Equal(t, 999, len(db.ListKeys()))
+
+	// 重启之后数据仍然在原来的分片
+	assert.Nil(t, db.Close())
+	db = openShardedDB(t, dir, 1)
+	defer db.Close()
+	assert.Equal(t, 3, db.ShardNum())
+	value, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(10), value)
+}
+
+func TestDB_ReopenLargerShardNum(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-reopen")
+	defer os.RemoveAll(dir)
+	db := openShardedDB(t, dir, 2)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	// 已有分片时忽略 ShardNum, 不会创建没有迁移数据的空分片
+	db = openShardedDB(t, dir, 4)
+	defer db.Close()
+	assert.Equal(t, 2, db.ShardNum())
+	for i := 0; i < 1000; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+	assert.Equal(t, 1000, len(db.ListKeys()))
+}
+
+func TestDB_Iterator(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-iter")
+	defer os.RemoveAll(dir)
+	db := openShardedDB(t, dir, 4)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		assert.Nil(t, db.Put(key, key))
+	}
+	assert.Nil(t, db.Put([]byte("other"), []byte("v")))
+
+	iter := db.NewIterator(bitcask.DefaultIteratorOptions)
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+		value, err := iter.Value()
+		assert.Nil(t, err)
+		assert.NotNil(t, value)
+	}
+	iter.Close()
+	assert.Equal(t, 101, len(keys))
+	assert.Equal(t, "key-000", keys[0])
+	assert.Equal(t, "other", keys[100])
+	for i := 1; i < len(keys); i++ {
+		assert.Less(t, keys[i-1], keys[i])
+	}
+
+	// 反向遍历并指定前缀
+	iter = db.NewIterator(bitcask.IteratorOptions{Prefix: []byte("key-"), Reverse: true})
+	iter.Rewind()
+	assert.Equal(t, []byte("key-099"), iter.Key())
+	iter.Seek([]byte("key-050"))
+	assert.Equal(t, []byte("key-050"), iter.Key())
+	iter.Next()
+	assert.Equal(t, []byte("key-049"), iter.Key())
+	count := 0
+	for ; iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 50, count)
+	iter.Close()
+}
+
+func TestDB_WriteBatch(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-batch")
+	defer os.RemoveAll(dir)
+	db := openShardedDB(t, dir, 3)
+	defer db.Close()
+
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("v")))
+	wb := db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+	for i := 1; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+	// 提交之前数据不可见
+	_, err := db.Get(utils.GetTestKey(1))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+
+	assert.Nil(t, wb.Commit())
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, bitcask.ErrKeyNotFound, err)
+	assert.Equal(t, 99, len(db.ListKeys()))
+
+	wb = db.NewWriteBatch(bitcask.WriteBatchOptions{MaxBatchNum: 1, SyncWrites: false})
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("v")))
+	assert.Nil(t, wb.Put(utils.GetTestKey(2), []byte("v")))
+	assert.Equal(t, bitcask.ErrExceedMaxBatchNum, wb.Commit())
+}
+
+func TestDB_AddShard(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-add")
+	defer os.RemoveAll(dir)
+	db := openShardedDB(t, dir, 2)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.AddShard())
+	assert.Equal(t, ErrMigrationInProgress, db.AddShard())
+
+	// 迁移过程中的读写
+	for i := 0; i < 3000; i += 3 {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+		assert.Nil(t, db.Delete(utils.GetTestKey(i+1)))
+	}
+	assert.Nil(t, db.WaitMigration())
+	assert.Nil(t, db.WaitMigration())
+
+	check := func(db *DB) {
+		for i := 0; i < 3000; i++ {
+			value, err := db.Get(utils.GetTestKey(i))
+			switch i % 3 {
+			case 0:
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("new"), value)
+			case 1:
+				assert.Equal(t, bitcask.ErrKeyNotFound, err)
+			default:
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), value)
+			}
+		}
+		assert.Equal(t, 2000, len(db.ListKeys()))
+		// 每个 key 都只存在于所属的分片
+		for i, shard := range db.shards {
+			keys := shard.ListKeys()
+			assert.Greater(t, len(keys), 0)
+			for _, key := range keys {
+				assert.Equal(t, i, db.ring.get(key))
+			}
+		}
+	}
+	check(db)
+
+	assert.Nil(t, db.Close())
+	db = openShardedDB(t, dir, 2)
+	defer db.Close()
+	assert.Equal(t, 3, db.ShardNum())
+	check(db)
+}
+
+func TestDB_AddShardResume(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-sharded-resume")
+	defer os.RemoveAll(dir)
+	db := openShardedDB(t, dir, 2)
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	// 迁移开始之后立即关闭, 重新打开时继续迁移
+	assert.Nil(t, db.AddShard())
+	assert.Nil(t, db.Close())
+
+	db = openShardedDB(t, dir, 2)
+	defer db.Close()
+	assert.Equal(t, 3, db.ShardNum())
+	for i := 0; i < 3000; i++ {
+		value, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), value)
+	}
+	assert.Nil(t, db.WaitMigration())
+	assert.Equal(t, 3000, len(db.ListKeys()))
+	for i, shard := range db.shards {
+		for _, key := range shard.ListKeys() {
+			assert.Equal(t, i, db.ring.get(key))
+		}
+	}
+	_, err := os.Stat(dir + "/" + migrationFileName)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -22,17 +23,13 @@ func DirSize(dirPath string) (int64, error) {
 	return size, err
 }
 
-// AvailableDiskSize 获取磁盘可用空间
-func AvailableDiskSize() (uint64, error) {
-	wd, err := syscall.Getwd()
-	if err != nil {
-		return 0, err
-	}
+// AvailableDiskSize 获取目录所在磁盘中非特权用户可用的空间
+func AvailableDiskSize(dirPath string) (uint64, error) {
 	var stat syscall.Statfs_t
-	if err := syscall.Statfs(wd, &stat); err != nil {
+	if err := syscall.Statfs(dirPath, &stat); err != nil {
 		return 0, err
 	}
-	return stat.Blocks * uint64(stat.Bsize), nil
+	return stat.Bavail * uint64(stat.Bsize), nil
 }
 
 // CopyDir 拷贝数据目录
@@ -71,3 +68,26 @@ func CopyDir(src string, dest string, exclude []string) error {
 		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
 	})
 }
+
+// CopyFile 拷贝文件的前 size 个字节并持久化
+func CopyFile(src string, dest string, size int64) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(destFile, srcFile, size); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+	if err := destFile.Sync(); err != nil {
+		_ = destFile.Close()
+		return err
+	}
+	return destFile.Close()
+}
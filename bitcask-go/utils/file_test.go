@@ -3,6 +3,7 @@ package utils
 import (
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -14,8 +15,28 @@ func TestDirSize(t *testing.T) {
 }
 
 func TestAvailableDiskSize(t *testing.T) {
-	size, err := AvailableDiskSize()
+	dir, _ := os.Getwd()
+	size, err := AvailableDiskSize(dir)
 	assert.Nil(t, err)
 	t.Log(size / (1024 * 1024 * 1024))
 	assert.True(t, size > 1024*1024*1024)
+
+	_, err = AvailableDiskSize(filepath.Join(dir, "not-exist"))
+	assert.NotNil(t, err)
+}
+
+func TestCopyFile(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-copy-file")
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	assert.Nil(t, os.WriteFile(src, []byte("hello world"), 0644))
+
+	dest := filepath.Join(dir, "dest")
+	assert.Nil(t, CopyFile(src, dest, 5))
+	content, err := os.ReadFile(dest)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hello"), content)
+
+	// 源文件长度不足
+	assert.NotNil(t, CopyFile(src, dest, 100))
 }
@@ -0,0 +1,162 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+type EventType = byte
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+// Event key 变更事件, 只在数据提交之后发出
+type Event struct {
+	Type  EventType
+	Key   []byte
+	Value []byte // 删除事件的 value 为空
+	SeqNo uint64 // 提交序号, 单调递增, 同一个 WriteBatch 中的事件序号相同
+}
+
+type watcher struct {
+	prefix []byte
+	ch     chan Event
+	block  bool
+	done   chan struct{} // 取消订阅时关闭, 唤醒阻塞在发送上的写入
+	once   *sync.Once
+}
+
+// Watch 订阅以 prefix 为前缀的 key 的变更, 使用默认的配置
+func (db *DB) Watch(prefix []byte) (<-chan Event, func()) {
+	return db.WatchWithOptions(prefix, DefaultWatchOptions)
+}
+
+// WatchWithOptions 订阅以 prefix 为前缀的 key 的变更, 调用返回的 cancel 函数取消订阅并关闭 channel
+func (db *DB) WatchWithOptions(prefix []byte, opts WatchOptions) (<-chan Event, func()) {
+	w := &watcher{
+		prefix: append([]byte{}, prefix...),
+		ch:     make(chan Event, opts.BufferSize),
+		block:  opts.BlockOnFull,
+		done:   make(chan struct{}),
+		once:   &sync.Once{},
+	}
+
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	// 数据库已经关闭
+	if db.watchers == nil {
+		close(w.ch)
+		return w.ch, func() {}
+	}
+	db.watchers[w] = struct{}{}
+	atomic.AddInt32(&db.watcherNum, 1)
+
+	cancel := func() {
+		w.once.Do(func() {
+			close(w.done)
+		})
+		// 从订阅中移除的一方负责关闭 channel
+		db.watchLock.Lock()
+		defer db.watchLock.Unlock()
+		if _, ok := db.watchers[w]; ok {
+			delete(db.watchers, w)
+			atomic.AddInt32(&db.watcherNum, -1)
+			close(w.ch)
+		}
+	}
+	return w.ch, cancel
+}
+
+// 提交之后等待发送的变更事件
+type publication struct {
+	db     *DB
+	ticket uint64 // 发送的顺序, 和提交的顺序一致
+	events []Event
+}
+
+// 发布提交之后的变更事件, 调用时需要持有 db.mu 的写锁, 按照提交的顺序分配序号
+// 返回的事件在释放 db.mu 之后通过 deliver 发送, 阻塞的订阅不会阻塞其他的读写, 没有订阅时返回 nil
+func (db *DB) publish(events []Event) *publication {
+	db.commitSeq++
+	if atomic.LoadInt32(&db.watcherNum) == 0 {
+		return nil
+	}
+
+	for i := range events {
+		events[i].SeqNo = db.commitSeq
+		// 调用方可能复用 key 和 value 的内存, 发送拷贝
+		events[i].Key = append([]byte{}, events[i].Key...)
+		if events[i].Value != nil {
+			events[i].Value = append([]byte{}, events[i].Value...)
+		}
+	}
+	db.publishTicket++
+	return &publication{db: db, ticket: db.publishTicket, events: events}
+}
+
+// 在释放 db.mu 之后发送事件, 等待之前提交的事件发送完成, 保证事件的顺序和提交的顺序一致
+func (p *publication) deliver() {
+	if p == nil {
+		return
+	}
+	db := p.db
+	db.publishLock.Lock()
+	for db.publishedTicket+1 != p.ticket {
+		db.publishCond.Wait()
+	}
+	db.publishLock.Unlock()
+	defer func() {
+		db.publishLock.Lock()
+		db.publishedTicket = p.ticket
+		db.publishCond.Broadcast()
+		db.publishLock.Unlock()
+	}()
+	db.send(p.events)
+}
+
+func (db *DB) send(events []Event) {
+	db.watchLock.RLock()
+	defer db.watchLock.RUnlock()
+	for w := range db.watchers {
+		for _, event := range events {
+			if !bytes.HasPrefix(event.Key, w.prefix) {
+				continue
+			}
+			if w.block {
+				select {
+				case w.ch <- event:
+				case <-w.done:
+				case <-db.watchClosed:
+				}
+				continue
+			}
+			select {
+			case w.ch <- event:
+			default:
+				atomic.AddUint64(&db.droppedEvents, 1)
+			}
+		}
+	}
+}
+
+// 关闭数据库时取消所有的订阅
+func (db *DB) closeWatchers() {
+	select {
+	case <-db.watchClosed:
+		return
+	default:
+	}
+	// 先唤醒阻塞在发送上的写入, 否则无法获取锁
+	close(db.watchClosed)
+
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	for w := range db.watchers {
+		close(w.ch)
+	}
+	db.watchers = nil
+	atomic.StoreInt32(&db.watcherNum, 0)
+}
@@ -0,0 +1,180 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func receiveEvent(t *testing.T, ch <-chan Event) Event {
+	select {
+	case event, ok := <-ch:
+		assert.True(t, ok)
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func assertNoEvent(t *testing.T, ch <-chan Event) {
+	select {
+	case event := <-ch:
+		t.Fatalf("unexpected event %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDB_Watch(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-watch", DefaultOptions)
+	defer destroyDB(db)
+
+	ch, cancel := db.Watch([]byte("user:"))
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("v1")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("v1")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+	// 不存在的 key 删除不会产生事件
+	assert.Nil(t, db.Delete([]byte("user:2")))
+
+	event := receiveEvent(t, ch)
+	assert.Equal(t, EventPut, event.Type)
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.Equal(t, []byte("v1"), event.Value)
+	putSeq := event.SeqNo
+
+	event = receiveEvent(t, ch)
+	assert.Equal(t, EventDelete, event.Type)
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.Nil(t, event.Value)
+	assert.Greater(t, event.SeqNo, putSeq)
+	assertNoEvent(t, ch)
+
+	// 未提交的事务不产生事件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:3"), []byte("v3")))
+	assert.Nil(t, wb.Put([]byte("user:4"), []byte("v4")))
+	assertNoEvent(t, ch)
+
+	// 提交之后事务中的每个 key 都产生事件, 且序号相同
+	assert.Nil(t, wb.Commit())
+	e1, e2 := receiveEvent(t, ch), receiveEvent(t, ch)
+	keys := []string{string(e1.Key), string(e2.Key)}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"user:3", "user:4"}, keys)
+	assert.Equal(t, e1.SeqNo, e2.SeqNo)
+	assertNoEvent(t, ch)
+
+	// 取消订阅之后 channel 被关闭
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Nil(t, db.Put([]byte("user:5"), []byte("v5")))
+	cancel()
+}
+
+func TestDB_WatchDrop(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-watch-drop", DefaultOptions)
+	defer destroyDB(db)
+
+	ch, cancel := db.WatchWithOptions(nil, WatchOptions{BufferSize: 2})
+	defer cancel()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, db.Put([]byte{byte('a' + i)}, []byte("v")))
+	}
+	assert.Equal(t, []byte("a"), receiveEvent(t, ch).Key)
+	assert.Equal(t, []byte("b"), receiveEvent(t, ch).Key)
+	assertNoEvent(t, ch)
+	assert.Equal(t, uint64(3), db.Metrics().DroppedEvents)
+}
+
+func TestDB_WatchBlock(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-watch-block", DefaultOptions)
+	defer destroyDB(db)
+
+	ch, cancel := db.WatchWithOptions(nil, WatchOptions{BufferSize: 1, BlockOnFull: true})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			assert.Nil(t, db.Put([]byte{byte('a' + i)}, []byte("v")))
+		}
+	}()
+
+	// 缓冲区已满, 写入被阻塞
+	select {
+	case <-done:
+		t.Fatal("put should be blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, []byte{byte('a' + i)}, receiveEvent(t, ch).Key)
+	}
+	<-done
+	assert.Equal(t, uint64(0), db.Metrics().DroppedEvents)
+
+	// 取消订阅可以唤醒被阻塞的写入
+	assert.Nil(t, db.Put([]byte("x"), []byte("v")))
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, db.Put([]byte("y"), []byte("v")))
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestDB_WatchBlockRead(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-watch-block-read", DefaultOptions)
+	defer destroyDB(db)
+
+	ch, cancel := db.WatchWithOptions(nil, WatchOptions{BufferSize: 1, BlockOnFull: true})
+	defer cancel()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(w*50+i), []byte("v")))
+			}
+		}(w)
+	}
+
+	// 缓冲区已满时写入被阻塞, 但是不持有数据库的锁, 可以读取
+	time.Sleep(20 * time.Millisecond)
+	_, err := db.Get([]byte("not-exist"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 消费事件时读取数据库不会死锁, 事件的顺序和提交的顺序一致
+	var lastSeq uint64
+	for i := 0; i < 200; i++ {
+		event := receiveEvent(t, ch)
+		assert.Greater(t, event.SeqNo, lastSeq)
+		lastSeq = event.SeqNo
+		val, err := db.Get(event.Key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v"), val)
+	}
+	wg.Wait()
+}
+
+func TestDB_WatchClose(t *testing.T) {
+	db := openTestDB(t, "bitcask-go-watch-close", DefaultOptions)
+	defer destroyDB(db)
+
+	ch, cancel := db.WatchWithOptions(nil, WatchOptions{BufferSize: 0, BlockOnFull: true})
+	assert.Nil(t, db.Close())
+	_, ok := <-ch
+	assert.False(t, ok)
+	cancel()
+
+	// 关闭之后订阅直接返回已关闭的 channel
+	ch, _ = db.Watch(nil)
+	_, ok = <-ch
+	assert.False(t, ok)
+}